@@ -0,0 +1,386 @@
+// Package alert evaluates threshold rules over incoming readings and delivers the alerts by webhook, e.g to be paged
+// when a gust at the Puig Sesolles station passes 90 km/h or the 1-hour precipitation anywhere passes 30 mm:
+//
+//	webhooks:
+//	  - name: oncall
+//	    url: https://hooks.slack.com/services/T000/B000/XXXX
+//	    format: slack
+//	rules:
+//	  - name: Ratxa a Puig Sesolles
+//	    stations: [XK]
+//	    variables: [50]   # Ratxa màxima del vent a 10 m, in m/s
+//	    above: 25         # 90 km/h
+//	    for: 3            # 3 consecutive readings
+//	    hysteresis: 3     # resolved at 22 m/s or less
+//	  - name: Pluja intensa
+//	    variables: [35]
+//	    above: 30
+//	    sum: 1h
+//
+// An Engine is a sink.Sink, so readings are written to it as to any other sink. An alert fires once for a rule,
+// station and variable when the threshold is crossed for the consecutive readings of the rule, and a resolution is
+// sent when the value goes back beyond the threshold by the hysteresis. Readings already seen, as those returned again
+// by consecutive polls of the last measurements, are ignored. An alert which no notifier could deliver does not change
+// the state of the rule, so it is sent again with the next reading which still crosses, or clears, the threshold.
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// webhookTimeout bounds the calls to the webhooks of the default http client
+const webhookTimeout = 10 * time.Second
+
+// States of an alert
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+var errInvalidOption = errors.New("invalid option")
+var errInvalidHttpClient = errors.New("invalid http client")
+var errNoConfig = errors.New("no config")
+
+// Alert is a change of the state of a rule for a station and variable
+type Alert struct {
+	Rule       string  `json:"rule"`
+	State      string  `json:"state"` // firing or resolved
+	Estacio    string  `json:"estacio"`
+	NomEstacio string  `json:"nomEstacio,omitempty"`
+	Comarca    string  `json:"comarca,omitempty"`
+	Variable   int     `json:"variable"`
+	NomVar     string  `json:"nomVariable,omitempty"`
+	Unitats    string  `json:"unitats,omitempty"`
+	Valor      float64 `json:"valor"`   // Value of the reading, or the sum of the readings of the window
+	Llindar    float64 `json:"llindar"` // Threshold of the rule
+	Data       string  `json:"data"`    // RFC 3339 time of the reading
+	Message    string  `json:"message"`
+}
+
+// Notifier delivers alerts
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// sample is a reading within the sum window of a rule
+type sample struct {
+	t time.Time
+	v float64
+}
+
+// state is the state of a rule for a station and variable
+type state struct {
+	last    time.Time // Time of the last evaluated reading
+	count   int       // Consecutive readings crossing the threshold
+	firing  bool
+	samples []sample
+}
+
+// Engine evaluates the rules of a config over the readings written to it. It is safe for concurrent use.
+type Engine struct {
+	rules     []Rule
+	notifiers map[string]Notifier
+	client    *http.Client
+	stations  map[string]meteocat.MetadadesEstacions
+	variables map[int]meteocat.MetadadesVariable
+
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+// Option configures an Engine
+type Option func(e *Engine) error
+
+// WithStations joins the station metadata, used by the comarques selectors and the station names of the alerts.
+func WithStations(stations meteocat.MetadadesTotesEstacions) Option {
+	return func(e *Engine) error {
+		for _, st := range stations {
+			e.stations[st.Codi] = st
+		}
+		return nil
+	}
+}
+
+// WithVariables joins the variable catalogue, used by the variable names and units of the alerts.
+func WithVariables(variables meteocat.MetadadesVariables) Option {
+	return func(e *Engine) error {
+		for _, v := range variables {
+			e.variables[v.Codi] = v.MetadadesVariable
+		}
+		return nil
+	}
+}
+
+// WithHttpClient sets the http client used to call the webhooks, by default a client with a timeout of 10 seconds.
+func WithHttpClient(client *http.Client) Option {
+	return func(e *Engine) error {
+		if client == nil {
+			return errInvalidHttpClient
+		}
+		e.client = client
+		return nil
+	}
+}
+
+// WithNotifier adds a notifier under a name the rules can refer to, e.g to deliver the alerts by other means than
+// webhooks.
+func WithNotifier(name string, n Notifier) Option {
+	return func(e *Engine) error {
+		if name == "" || n == nil {
+			return errInvalidOption
+		}
+		e.notifiers[name] = n
+		return nil
+	}
+}
+
+// NewEngine returns a new Engine pointer evaluating the rules of c
+func NewEngine(c *Config, options ...Option) (*Engine, error) {
+	if c == nil {
+		return nil, errNoConfig
+	}
+	e := &Engine{
+		rules:     c.Rules,
+		notifiers: make(map[string]Notifier),
+		client:    &http.Client{Timeout: webhookTimeout},
+		stations:  make(map[string]meteocat.MetadadesEstacions),
+		variables: make(map[int]meteocat.MetadadesVariable),
+		states:    make(map[string]*state),
+	}
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(e); err != nil {
+			return nil, err
+		}
+	}
+	for _, w := range c.Webhooks {
+		if _, ok := e.notifiers[w.Name]; !ok {
+			e.notifiers[w.Name] = &Webhook{config: w, client: e.client}
+		}
+	}
+	return e, nil
+}
+
+// matches reports whether a rule applies to a station and variable
+func (e *Engine) matches(r *Rule, estacio string, variable int) bool {
+	found := false
+	for _, v := range r.Variables {
+		if v == variable {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	if len(r.Stations) == 0 && len(r.Comarques) == 0 {
+		return true
+	}
+	for _, s := range r.Stations {
+		if s == estacio {
+			return true
+		}
+	}
+	comarca := e.stations[estacio].Comarca.Nom
+	for _, c := range r.Comarques {
+		if comarca != "" && strings.EqualFold(c, comarca) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate updates the state of a rule with a reading and returns the alert of its change, if any, with the state
+// the change applies to once the alert is delivered
+func (e *Engine) evaluate(r *Rule, row export.Row, t time.Time) (*Alert, *state) {
+	key := r.Name + "/" + row.Estacio + "/" + strconv.Itoa(row.Variable)
+	s, ok := e.states[key]
+	if !ok {
+		s = &state{}
+		e.states[key] = s
+	}
+	if !t.After(s.last) {
+		return nil, nil
+	}
+	s.last = t
+
+	value := row.Lectura.Valor
+	if r.Sum > 0 {
+		s.samples = append(s.samples, sample{t, value})
+		i := 0
+		for i < len(s.samples) && !s.samples[i].t.After(t.Add(-r.Sum)) {
+			i++
+		}
+		s.samples = s.samples[i:]
+		value = 0
+		for _, smp := range s.samples {
+			value += smp.v
+		}
+	}
+
+	switch {
+	case !s.firing && r.crossed(value):
+		s.count++
+		if s.count < r.For {
+			return nil, nil
+		}
+		return e.alert(r, Firing, row, value, t), s
+	case !s.firing:
+		s.count = 0
+	case r.cleared(value):
+		return e.alert(r, Resolved, row, value, t), s
+	}
+	return nil, nil
+}
+
+// commit applies the change of an alert delivered to a state
+func (s *state) commit(a *Alert) {
+	s.firing = a.State == Firing
+	if !s.firing {
+		s.count = 0
+	}
+}
+
+// alert returns the alert of a rule for a reading
+func (e *Engine) alert(r *Rule, st string, row export.Row, value float64, t time.Time) *Alert {
+	station := e.stations[row.Estacio]
+	variable := e.variables[row.Variable]
+	a := &Alert{
+		Rule:       r.Name,
+		State:      st,
+		Estacio:    row.Estacio,
+		NomEstacio: station.Nom,
+		Comarca:    station.Comarca.Nom,
+		Variable:   row.Variable,
+		NomVar:     variable.Nom,
+		Unitats:    variable.Unitats,
+		Valor:      value,
+		Llindar:    r.threshold(),
+		Data:       t.UTC().Format(time.RFC3339),
+	}
+	if a.NomEstacio == "" {
+		a.NomEstacio = meteocat.CodisEstacions[row.Estacio]
+	}
+	if a.NomVar == "" {
+		a.NomVar = meteocat.CodisVariables[strconv.Itoa(row.Variable)]
+	}
+	a.Message = message(a, r)
+	return a
+}
+
+// message returns the text of an alert, e.g [firing] Ratxa a Puig Sesolles: XK Puig Sesolles, Ratxa màxima del vent
+// a 10 m 26.1 m/s > 25 at 2023-03-12T10:30:00Z
+func message(a *Alert, r *Rule) string {
+	op := ">"
+	if r.Below != nil {
+		op = "<"
+	}
+	what := a.NomVar
+	if what == "" {
+		what = "variable " + strconv.Itoa(a.Variable)
+	}
+	if r.Sum > 0 {
+		what += " (" + r.Sum.String() + " sum)"
+	}
+	unit := ""
+	if a.Unitats != "" {
+		unit = " " + a.Unitats
+	}
+	where := a.Estacio
+	if a.NomEstacio != "" {
+		where += " " + a.NomEstacio
+	}
+	return fmt.Sprintf("[%s] %s: %s, %s %s%s %s %s at %s", a.State, a.Rule, where, what,
+		strconv.FormatFloat(a.Valor, 'f', -1, 64), unit, op, strconv.FormatFloat(a.Llindar, 'f', -1, 64), a.Data)
+}
+
+// Write evaluates the rules over a reading and delivers the alerts of the rules which change state. A rule changes
+// state once at least one of its notifiers delivered the alert. Readings must be written in time order for each
+// station and variable, older ones are ignored.
+func (e *Engine) Write(row export.Row) error {
+	t, err := meteocat.ParseData(row.Lectura.Data)
+	if err != nil {
+		return err
+	}
+	row.Estacio = strings.ToUpper(row.Estacio)
+
+	var alerts []*Alert
+	var rules []*Rule
+	var states []*state
+	e.mu.Lock()
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !e.matches(r, row.Estacio, row.Variable) {
+			continue
+		}
+		if a, s := e.evaluate(r, row, t); a != nil {
+			alerts = append(alerts, a)
+			rules = append(rules, r)
+			states = append(states, s)
+		}
+	}
+	e.mu.Unlock()
+
+	var first error
+	for i, a := range alerts {
+		delivered, err := e.deliver(rules[i], *a)
+		if err != nil && first == nil {
+			first = err
+		}
+		if delivered {
+			e.mu.Lock()
+			states[i].commit(a)
+			e.mu.Unlock()
+		}
+	}
+	return first
+}
+
+// deliver sends an alert to the notifiers of its rule, all of them when the rule names none. It reports whether the
+// alert was delivered, that is whether a notifier succeeded or there was none to call.
+func (e *Engine) deliver(r *Rule, a Alert) (bool, error) {
+	names := r.Webhooks
+	if len(names) == 0 {
+		for name := range e.notifiers {
+			names = append(names, name)
+		}
+	}
+
+	var first error
+	called, delivered := 0, 0
+	for _, name := range names {
+		n, ok := e.notifiers[name]
+		if !ok {
+			continue
+		}
+		called++
+		if err := n.Notify(a); err != nil {
+			if first == nil {
+				first = fmt.Errorf("%s: %v", name, err)
+			}
+			continue
+		}
+		delivered++
+	}
+	return called == 0 || delivered > 0, first
+}
+
+// Flush implements the sink.Sink interface. Alerts are delivered as the readings are written.
+func (e *Engine) Flush() error {
+	return nil
+}
+
+// Close implements the sink.Sink interface
+func (e *Engine) Close() error {
+	return nil
+}
@@ -0,0 +1,322 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+	"github.com/oscaromeu/meteocat/sink"
+)
+
+var _ sink.Sink = (*Engine)(nil)
+
+// receiver is a local HTTP server collecting the bodies posted to it by path
+type receiver struct {
+	*httptest.Server
+	mu      sync.Mutex
+	bodies  map[string][][]byte
+	headers []http.Header
+}
+
+// newReceiver starts a receiver
+func newReceiver() *receiver {
+	r := &receiver{bodies: make(map[string][][]byte)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies[req.URL.Path] = append(r.bodies[req.URL.Path], b)
+		r.headers = append(r.headers, req.Header)
+		r.mu.Unlock()
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	return r
+}
+
+// alerts decodes the JSON alerts posted to a path
+func (r *receiver) alerts(t *testing.T, path string) []Alert {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var alerts []Alert
+	for _, b := range r.bodies[path] {
+		var a Alert
+		if err := json.Unmarshal(b, &a); err != nil {
+			t.Fatal(err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// newEngine returns an engine over a YAML config whose webhook URLs are relative to the receiver
+func newEngine(t *testing.T, r *receiver, config string) *Engine {
+	t.Helper()
+
+	c, err := ParseConfig([]byte(strings.ReplaceAll(config, "RECEIVER", r.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stations meteocat.MetadadesTotesEstacions
+	b, err := ioutil.ReadFile("../testdata/metadades_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &stations); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(c, WithStations(stations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// write writes the readings of a variable at a station, one every 30 minutes from 10:00
+func write(t *testing.T, e *Engine, estacio string, variable int, values ...float64) {
+	t.Helper()
+
+	for i, v := range values {
+		data := []string{"10:00", "10:30", "11:00", "11:30", "12:00", "12:30", "13:00", "13:30", "14:00", "14:30"}[i]
+		row := export.Row{Estacio: estacio, Variable: variable, Lectura: meteocat.Lectura{Data: "2023-03-12T" + data + "Z", Valor: v}}
+		if err := e.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestDuration tests that an alert fires after consecutive readings over the threshold, once, and is resolved
+// with hysteresis.
+func TestDuration(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+	e := newEngine(t, r, `
+webhooks:
+  - name: oncall
+    url: RECEIVER/json
+rules:
+  - name: Ratxa a Puig Sesolles
+    stations: [xk]
+    variables: [50]
+    above: 25
+    for: 3
+    hysteresis: 3
+`)
+
+	write(t, e, "XK", 50, 26, 27, 20, 26, 27, 28, 30, 24, 23)
+	write(t, e, "D5", 50, 30, 30, 30)
+	write(t, e, "XK", 51, 30, 30, 30)
+
+	alerts := r.alerts(t, "/json")
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %+v", alerts)
+	}
+	a := alerts[0]
+	if a.State != Firing || a.Estacio != "XK" || a.Valor != 28 || a.Data != "2023-03-12T12:30:00Z" || a.Llindar != 25 {
+		t.Errorf("unexpected alert %+v", a)
+	}
+	if a.NomEstacio == "" || a.NomVar != "Ratxa màxima del vent a 10 m" || !strings.HasPrefix(a.Message, "[firing] Ratxa a Puig Sesolles: XK") {
+		t.Errorf("unexpected alert description %+v", a)
+	}
+
+	// Readings seen again are ignored, and 22 is below the threshold minus the hysteresis
+	write(t, e, "XK", 50, 26, 27, 20, 26, 27, 28, 30, 24, 23, 22)
+	alerts = r.alerts(t, "/json")
+	if len(alerts) != 2 || alerts[1].State != Resolved || alerts[1].Valor != 22 {
+		t.Fatalf("expected a resolution, got %+v", alerts)
+	}
+}
+
+// TestSum tests a rule over the sum of the readings of a window on the stations of a comarca.
+func TestSum(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+	e := newEngine(t, r, `
+webhooks:
+  - name: oncall
+    url: RECEIVER/json
+  - name: other
+    url: RECEIVER/other
+rules:
+  - name: Pluja intensa
+    comarques: [osona]
+    variables: [35]
+    above: 30
+    sum: 1h
+    webhooks: [oncall]
+`)
+
+	write(t, e, "CC", 35, 10, 15, 14, 17, 0, 0)
+	write(t, e, "D5", 35, 40, 40)
+
+	alerts := r.alerts(t, "/json")
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", alerts)
+	}
+	if alerts[0].State != Firing || alerts[0].Valor != 31 || alerts[0].Data != "2023-03-12T11:30:00Z" || alerts[0].Comarca != "Osona" {
+		t.Errorf("unexpected alert %+v", alerts[0])
+	}
+	if alerts[1].State != Resolved || alerts[1].Valor != 17 {
+		t.Errorf("unexpected resolution %+v", alerts[1])
+	}
+	if other := r.alerts(t, "/other"); len(other) != 0 {
+		t.Errorf("unexpected alerts on the other webhook %+v", other)
+	}
+}
+
+// TestSlack tests the payload of Slack webhooks, their headers and the errors of the deliveries.
+func TestSlack(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+	e := newEngine(t, r, `
+webhooks:
+  - name: slack
+    url: RECEIVER/slack
+    format: slack
+    headers:
+      Authorization: Bearer secret
+  - name: broken
+    url: RECEIVER/fail
+rules:
+  - name: Glaçada
+    variables: [32]
+    below: 0
+`)
+
+	row := export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T06:00Z", Valor: -1.5}}
+	if err := e.Write(row); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the error of the broken webhook, got %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.bodies["/slack"]) != 1 {
+		t.Fatalf("expected 1 slack message, got %d", len(r.bodies["/slack"]))
+	}
+	var msg slackMessage
+	if err := json.Unmarshal(r.bodies["/slack"][0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg.Text, ":rotating_light: [firing] Glaçada: D5 Barcelona - Observatori Fabra") || !strings.Contains(msg.Text, "-1.5 < 0") {
+		t.Errorf("unexpected text %q", msg.Text)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Color != "danger" {
+		t.Errorf("unexpected attachments %+v", msg.Attachments)
+	}
+	authorized := false
+	for _, h := range r.headers {
+		if h.Get("Authorization") == "Bearer secret" {
+			authorized = true
+		}
+	}
+	if !authorized {
+		t.Error("missing the Authorization header")
+	}
+}
+
+// flaky is a notifier which fails a number of times before delivering the alerts
+type flaky struct {
+	fail   int
+	alerts []Alert
+}
+
+// Notify implements the Notifier interface
+func (f *flaky) Notify(a Alert) error {
+	if f.fail > 0 {
+		f.fail--
+		return errors.New("unavailable")
+	}
+	f.alerts = append(f.alerts, a)
+	return nil
+}
+
+// TestRetry tests that an alert which could not be delivered is sent again with the next reading, and that the
+// webhooks are called with a timeout by default.
+func TestRetry(t *testing.T) {
+	c, err := ParseConfig([]byte("rules: [{name: Calor, variables: [32], above: 25}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &flaky{fail: 1}
+	e, err := NewEngine(c, WithNotifier("flaky", n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.client.Timeout == 0 {
+		t.Error("expected a default client with a timeout")
+	}
+
+	for i, c := range []struct {
+		data  string
+		valor float64
+		fail  bool
+	}{
+		{"10:00", 26, true},
+		{"10:30", 27, false},
+		{"11:00", 28, false},
+		{"11:30", 20, true},
+		{"12:00", 21, false},
+		{"12:30", 22, false},
+	} {
+		if c.fail {
+			n.fail = 1
+		}
+		row := export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T" + c.data + "Z", Valor: c.valor}}
+		if err := e.Write(row); (err != nil) != c.fail {
+			t.Errorf("reading %d: unexpected error %v", i, err)
+		}
+	}
+
+	if len(n.alerts) != 2 || n.alerts[0].State != Firing || n.alerts[0].Valor != 27 || n.alerts[1].State != Resolved || n.alerts[1].Valor != 21 {
+		t.Errorf("expected the alert and its resolution sent again, got %+v", n.alerts)
+	}
+}
+
+// TestParseConfig tests the validation of the configs.
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+webhooks:
+  - name: a
+    url: https://example.org/hook
+rules:
+  - name: r
+    stations: [xk]
+    variables: [50]
+    above: 25
+    sum: 90m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := c.Rules[0]
+	if r.For != 1 || r.Stations[0] != "XK" || r.Sum.Minutes() != 90 || c.Webhooks[0].Format != FormatJSON {
+		t.Errorf("unexpected defaults %+v %+v", r, c.Webhooks[0])
+	}
+
+	for _, config := range []string{
+		"rules: [{name: r, variables: [50]}]",
+		"rules: [{name: r, variables: [50], above: 1, below: 0}]",
+		"rules: [{name: r, above: 1}]",
+		"rules: [{variables: [50], above: 1}]",
+		"rules: [{name: r, variables: [50], above: 1}, {name: r, variables: [50], above: 1}]",
+		"rules: [{name: r, variables: [50], above: 1, for: -1}]",
+		"rules: [{name: r, variables: [50], above: 1, webhooks: [missing]}]",
+		"rules: [{name: r, variables: [50], above: 1, sum: soon}]",
+		"webhooks: [{name: a, url: ftp://example.org}]",
+		"webhooks: [{name: a, url: https://example.org, format: xml}]",
+		"webhooks: [{url: https://example.org}]",
+	} {
+		if _, err := ParseConfig([]byte(config)); err == nil {
+			t.Errorf("%s: expected an error", config)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package alert
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the webhook payloads
+const (
+	FormatJSON  = "json"  // The Alert as a JSON object
+	FormatSlack = "slack" // A Slack incoming webhook message
+)
+
+// Config holds the webhooks and the rules of an Engine
+type Config struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Rules    []Rule          `yaml:"rules"`
+}
+
+// WebhookConfig describes a webhook the alerts are delivered to
+type WebhookConfig struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Format  string            `yaml:"format"` // json or slack, json by default
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Rule fires an alert for a station and variable when its readings cross a threshold. A rule applies to the
+// stations of its codes and comarques, all of them when both are empty, and to the variables of its codes.
+type Rule struct {
+	Name      string   `yaml:"name"`
+	Stations  []string `yaml:"stations"`  // Station codes, e.g XK
+	Comarques []string `yaml:"comarques"` // Comarca names, e.g Osona
+	Variables []int    `yaml:"variables"` // Variable codes, e.g 50
+
+	Above *float64 `yaml:"above"` // Fires when the value is greater
+	Below *float64 `yaml:"below"` // Fires when the value is lower
+
+	// For is the number of consecutive readings which cross the threshold before the alert fires, 1 by default
+	For int `yaml:"for"`
+	// Hysteresis is the margin the value has to go back beyond the threshold to resolve the alert
+	Hysteresis float64 `yaml:"hysteresis"`
+	// Sum compares the sum of the readings within the duration instead of each reading, e.g 1h of precipitation
+	Sum time.Duration `yaml:"sum"`
+
+	Webhooks []string `yaml:"webhooks"` // Names of the webhooks of the alerts, all when empty
+}
+
+// LoadConfig reads and validates a YAML config file
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(b)
+}
+
+// ParseConfig decodes and validates a YAML config
+func ParseConfig(b []byte) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// validate checks the webhooks and the rules and sets their defaults
+func (c *Config) validate() error {
+	webhooks := make(map[string]bool, len(c.Webhooks))
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+		switch {
+		case w.Name == "":
+			return fmt.Errorf("webhook %d: missing name", i+1)
+		case webhooks[w.Name]:
+			return fmt.Errorf("webhook %s: duplicated name", w.Name)
+		case !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://"):
+			return fmt.Errorf("webhook %s: invalid url %q", w.Name, w.URL)
+		}
+		if w.Format == "" {
+			w.Format = FormatJSON
+		}
+		if w.Format != FormatJSON && w.Format != FormatSlack {
+			return fmt.Errorf("webhook %s: unknown format %q, expected json or slack", w.Name, w.Format)
+		}
+		webhooks[w.Name] = true
+	}
+
+	rules := make(map[string]bool, len(c.Rules))
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		switch {
+		case r.Name == "":
+			return fmt.Errorf("rule %d: missing name", i+1)
+		case rules[r.Name]:
+			return fmt.Errorf("rule %s: duplicated name", r.Name)
+		case len(r.Variables) == 0:
+			return fmt.Errorf("rule %s: missing variables", r.Name)
+		case (r.Above == nil) == (r.Below == nil):
+			return fmt.Errorf("rule %s: exactly one of above and below is required", r.Name)
+		case r.For < 0 || r.Hysteresis < 0 || r.Sum < 0:
+			return fmt.Errorf("rule %s: for, hysteresis and sum cannot be negative", r.Name)
+		}
+		if r.For == 0 {
+			r.For = 1
+		}
+		for j, s := range r.Stations {
+			r.Stations[j] = strings.ToUpper(s)
+		}
+		for _, w := range r.Webhooks {
+			if !webhooks[w] {
+				return fmt.Errorf("rule %s: unknown webhook %q", r.Name, w)
+			}
+		}
+		rules[r.Name] = true
+	}
+	return nil
+}
+
+// threshold returns the threshold of the rule
+func (r *Rule) threshold() float64 {
+	if r.Above != nil {
+		return *r.Above
+	}
+	return *r.Below
+}
+
+// crossed reports whether a value crosses the threshold of the rule
+func (r *Rule) crossed(v float64) bool {
+	if r.Above != nil {
+		return v > *r.Above
+	}
+	return v < *r.Below
+}
+
+// cleared reports whether a value is back beyond the threshold by the hysteresis
+func (r *Rule) cleared(v float64) bool {
+	if r.Above != nil {
+		return v <= *r.Above-r.Hysteresis
+	}
+	return v >= *r.Below+r.Hysteresis
+}
@@ -0,0 +1,100 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Webhook posts the alerts to a URL, as JSON objects or as Slack messages
+type Webhook struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhook returns a new Webhook pointer which posts to the URL of c with client
+func NewWebhook(c WebhookConfig, client *http.Client) (*Webhook, error) {
+	cfg := Config{Webhooks: []WebhookConfig{c}}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errInvalidHttpClient
+	}
+	return &Webhook{config: cfg.Webhooks[0], client: client}, nil
+}
+
+// slackMessage is the payload of a Slack incoming webhook
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+// slackAttachment colours a Slack message
+type slackAttachment struct {
+	Color    string       `json:"color"`
+	Fallback string       `json:"fallback"`
+	Fields   []slackField `json:"fields"`
+}
+
+// slackField is a title and value of an attachment
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// payload returns the body of the request of an alert in the format of the webhook
+func (w *Webhook) payload(a Alert) interface{} {
+	if w.config.Format != FormatSlack {
+		return a
+	}
+
+	color, icon := "danger", ":rotating_light:"
+	if a.State == Resolved {
+		color, icon = "good", ":white_check_mark:"
+	}
+	station := a.Estacio
+	if a.NomEstacio != "" {
+		station += " " + a.NomEstacio
+	}
+	return slackMessage{
+		Text: icon + " " + a.Message,
+		Attachments: []slackAttachment{{
+			Color:    color,
+			Fallback: a.Message,
+			Fields: []slackField{
+				{Title: "Estació", Value: station, Short: true},
+				{Title: "Valor", Value: fmt.Sprintf("%v %s", a.Valor, a.Unitats), Short: true},
+			},
+		}},
+	}
+}
+
+// Notify implements the Notifier interface
+func (w *Webhook) Notify(a Alert) error {
+	body, err := json.Marshal(w.payload(a))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
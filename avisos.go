@@ -0,0 +1,187 @@
+package meteocat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // The time slots are local time, also on systems without a time zone database
+)
+
+// pronosticURL is the base URL of the forecast and warnings API
+var pronosticURL = "https://api.meteo.cat/pronostic/v1%s"
+
+// EstatEpisodi struct holds the state of a warning episode, e.g Obert or Tancat
+type EstatEpisodi struct {
+	Nom  string `json:"nom"`  // Name of the state
+	Data string `json:"data"` // Date when the episode changed to this state
+}
+
+// Meteor struct holds the hazard the warning is issued for, e.g Pluja, Vent, Neu, Calor or Estat de la mar
+type Meteor struct {
+	Nom string `json:"nom"` // Name of the hazard
+}
+
+// Afectacio holds the warning severity for a comarca on a given period
+type Afectacio struct {
+	Dia       string `json:"dia"`       // Day of the affectation
+	Llindar   string `json:"llindar"`   // Threshold exceeded, e.g "Intensitat > 20 mm en 30 minuts"
+	Auxiliar  bool   `json:"auxiliar"`  // True when the comarca is affected by an auxiliary threshold
+	Perill    int    `json:"perill"`    // Degree of danger from 1 to 6
+	IDComarca int    `json:"idComarca"` // Comarca identification code
+	Nivell    int    `json:"nivell"`    // Warning level
+}
+
+// Periode holds the affectations of a time slot of the day, e.g 00-06
+type Periode struct {
+	Nom         string      `json:"nom"`         // Time slot expressed as HH-HH
+	Afectacions []Afectacio `json:"afectacions"` // Affected comarques
+}
+
+// Evolucio holds the evolution of a warning over a day
+type Evolucio struct {
+	Dia                   string    `json:"dia"`                   // Day of the evolution
+	Comentari             string    `json:"comentari"`             // Comment of the forecaster
+	Representatiu         int       `json:"representatiu"`         // Representative degree of danger of the day
+	Llindar1              string    `json:"llindar1"`              // Main threshold
+	Llindar2              string    `json:"llindar2"`              // Secondary threshold
+	DistribucioGeografica string    `json:"distribucioGeografica"` // Geographical distribution of the hazard
+	Periodes              []Periode `json:"periodes"`              // Time slots of the day
+}
+
+// Avis holds a warning issued within an episode
+type Avis struct {
+	Tipus      string     `json:"tipus"`      // Type of warning, e.g Avís or Situació de perill
+	DataEmisio string     `json:"dataEmisio"` // Date of issue
+	DataInici  string     `json:"dataInici"`  // Start of the validity period
+	DataFi     string     `json:"dataFi"`     // End of the validity period
+	Evolucions []Evolucio `json:"evolucions"` // Daily evolution of the warning
+}
+
+// Episodi is an aggregate type which represents a warning episode of the Weather Service of Catalonia (SMP)
+type Episodi struct {
+	Estat  EstatEpisodi `json:"estat"`  // State of the episode
+	Meteor Meteor       `json:"meteor"` // Hazard of the episode
+	Avisos []Avis       `json:"avisos"` // Warnings issued within the episode
+}
+
+// Episodis is a slice which holds the warning episodes
+type Episodis []Episodi
+
+// AvisActiu is a flattened view of a warning affecting a comarca at a given time
+type AvisActiu struct {
+	Meteor    string    // Hazard, e.g Pluja
+	Tipus     string    // Type of warning
+	Llindar   string    // Threshold exceeded
+	Perill    int       // Degree of danger from 1 to 6
+	Nivell    int       // Warning level
+	IDComarca int       // Comarca identification code
+	Inici     time.Time // Start of the validity period
+	Fi        time.Time // End of the validity period
+}
+
+// periodBounds returns the time interval covered by a time slot like 06-12 of the given day. The slots of the SMP
+// bulletins are in local time, so a slot is 5 or 7 hours long on the days the clocks change.
+func periodBounds(dia, nom string) (time.Time, time.Time, error) {
+	loc, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	d, err := ParseData(dia)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	hours := strings.SplitN(nom, "-", 2)
+	if len(hours) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period: %q", nom)
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(hours[0]))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(hours[1]))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to <= from {
+		to += 24
+	}
+
+	return time.Date(d.Year(), d.Month(), d.Day(), from, 0, 0, 0, loc), time.Date(d.Year(), d.Month(), d.Day(), to, 0, 0, 0, loc), nil
+}
+
+// Active returns the warnings affecting the comarca with code comarca at time t. Closed episodes are ignored.
+func (e Episodis) Active(comarca int, t time.Time) []AvisActiu {
+	var r []AvisActiu
+	for _, ep := range e {
+		if strings.EqualFold(ep.Estat.Nom, "tancat") {
+			continue
+		}
+		for _, av := range ep.Avisos {
+			for _, ev := range av.Evolucions {
+				for _, pe := range ev.Periodes {
+					for _, af := range pe.Afectacions {
+						if af.IDComarca != comarca {
+							continue
+						}
+						dia := af.Dia
+						if dia == "" {
+							dia = ev.Dia
+						}
+						inici, fi, err := periodBounds(dia, pe.Nom)
+						if err != nil || t.Before(inici) || !t.Before(fi) {
+							continue
+						}
+						r = append(r, AvisActiu{
+							Meteor:    ep.Meteor.Nom,
+							Tipus:     av.Tipus,
+							Llindar:   af.Llindar,
+							Perill:    af.Perill,
+							Nivell:    af.Nivell,
+							IDComarca: af.IDComarca,
+							Inici:     inici,
+							Fi:        fi,
+						})
+					}
+				}
+			}
+		}
+	}
+	return r
+}
+
+// Avisos holds all the data representations to unmarshall the warnings API responses
+type Avisos struct {
+	Episodis
+	Key string
+	*Settings
+}
+
+// NewAvisos returns a new Avisos pointer with the supplied parameters
+func NewAvisos(key string, options ...Option) (*Avisos, error) {
+	a := &Avisos{
+		Settings: NewSettings(),
+	}
+
+	a.Key, _ = setKey(key)
+
+	if err := setOptions(a.Settings, options); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Returns the warning episodes of the Weather Service of Catalonia in force.
+// The API resource is /avisos and there are no parameters. Request example https://api.meteo.cat/pronostic/v1/avisos
+func (a *Avisos) Warnings() error {
+	a.Episodis = nil
+	return a.get(a.Key, fmt.Sprintf(pronosticURL, "/avisos"), &a.Episodis)
+}
+
+// ActiveWarningsForStation returns the warnings active right now for the comarca where the station is located.
+// Warnings must be called first to fetch the episodes.
+func (a *Avisos) ActiveWarningsForStation(e MetadadesEstacions) []AvisActiu {
+	return a.Episodis.Active(e.Comarca.Codi, time.Now())
+}
@@ -0,0 +1,105 @@
+package meteocat
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWarningsActive tests that the warnings active for a comarca are selected by period and episode state.
+func TestWarningsActive(t *testing.T) {
+	defer newFixtureServerFor(t, &pronosticURL, "/avisos", "avisos.json")()
+
+	a, _ := NewAvisos("")
+	if err := a.Warnings(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Episodis) != 2 {
+		t.Fatalf("expected 2 episodes, got %d", len(a.Episodis))
+	}
+
+	at := time.Date(2023, 3, 12, 9, 0, 0, 0, time.UTC)
+
+	// Barcelonès: the rain warning is active, the wind episode is closed. The 06-12 slot is local time, UTC+1.
+	active := a.Episodis.Active(13, at)
+	if len(active) != 1 {
+		t.Fatalf("expected 1 active warning, got %d: %+v", len(active), active)
+	}
+	if w := active[0]; w.Meteor != "Pluja" || w.Perill != 3 || !w.Inici.Equal(at.Add(-4*time.Hour)) || !w.Fi.Equal(at.Add(2*time.Hour)) {
+		t.Errorf("unexpected active warning: %+v", w)
+	}
+
+	// Barcelonès is not affected in the afternoon.
+	if active := a.Episodis.Active(13, at.Add(6*time.Hour)); len(active) != 0 {
+		t.Errorf("expected no active warnings, got %+v", active)
+	}
+
+	// Maresme is affected in the afternoon with a higher degree of danger.
+	if active := a.Episodis.Active(41, at.Add(6*time.Hour)); len(active) != 1 || active[0].Perill != 4 {
+		t.Errorf("unexpected active warnings: %+v", active)
+	}
+}
+
+// TestWarningsSlotBoundaries tests that the time slots are local time, on a winter day and on the day the clocks
+// are put forward.
+func TestWarningsSlotBoundaries(t *testing.T) {
+	episodis := func(dia string) Episodis {
+		return Episodis{{
+			Estat:  EstatEpisodi{Nom: "Obert"},
+			Meteor: Meteor{Nom: "Pluja"},
+			Avisos: []Avis{{Evolucions: []Evolucio{{
+				Dia: dia,
+				Periodes: []Periode{
+					{Nom: "00-06", Afectacions: []Afectacio{{IDComarca: 13, Perill: 2}}},
+					{Nom: "06-12", Afectacions: []Afectacio{{IDComarca: 13, Perill: 3}}},
+				},
+			}}}},
+		}}
+	}
+
+	for _, tc := range []struct {
+		dia    string
+		at     time.Time
+		perill int // 0 when no warning is active
+	}{
+		// CET, UTC+1
+		{"2023-03-12T00:00Z", time.Date(2023, 3, 11, 22, 59, 0, 0, time.UTC), 0},
+		{"2023-03-12T00:00Z", time.Date(2023, 3, 11, 23, 0, 0, 0, time.UTC), 2},
+		{"2023-03-12T00:00Z", time.Date(2023, 3, 12, 4, 59, 0, 0, time.UTC), 2},
+		{"2023-03-12T00:00Z", time.Date(2023, 3, 12, 5, 0, 0, 0, time.UTC), 3},
+		{"2023-03-12T00:00Z", time.Date(2023, 3, 12, 10, 59, 0, 0, time.UTC), 3},
+		{"2023-03-12T00:00Z", time.Date(2023, 3, 12, 11, 0, 0, 0, time.UTC), 0},
+		// The clocks go from 02:00 CET to 03:00 CEST, so the 00-06 slot is 5 hours long
+		{"2023-03-26T00:00Z", time.Date(2023, 3, 25, 23, 0, 0, 0, time.UTC), 2},
+		{"2023-03-26T00:00Z", time.Date(2023, 3, 26, 3, 59, 0, 0, time.UTC), 2},
+		{"2023-03-26T00:00Z", time.Date(2023, 3, 26, 4, 0, 0, 0, time.UTC), 3},
+		{"2023-03-26T00:00Z", time.Date(2023, 3, 26, 9, 59, 0, 0, time.UTC), 3},
+		{"2023-03-26T00:00Z", time.Date(2023, 3, 26, 10, 0, 0, 0, time.UTC), 0},
+	} {
+		active := episodis(tc.dia).Active(13, tc.at)
+		switch {
+		case tc.perill == 0 && len(active) != 0:
+			t.Errorf("%s at %v: expected no warnings, got %+v", tc.dia, tc.at, active)
+		case tc.perill != 0 && (len(active) != 1 || active[0].Perill != tc.perill):
+			t.Errorf("%s at %v: expected a warning of danger %d, got %+v", tc.dia, tc.at, tc.perill, active)
+		}
+	}
+}
+
+// TestParseData tests that ParseData understands the date formats returned by the API.
+func TestParseData(t *testing.T) {
+	want := time.Date(2023, 3, 12, 10, 30, 0, 0, time.UTC)
+
+	for _, s := range []string{"2023-03-12T10:30Z", "2023-03-12T10:30:00Z", "2023-03-12T10:30:00.000Z"} {
+		got, err := ParseData(s)
+		if err != nil {
+			t.Errorf("ParseData(%q): %v", s, err)
+		} else if !got.Equal(want) {
+			t.Errorf("ParseData(%q): expected %v, got %v", s, want, got)
+		}
+	}
+
+	if _, err := ParseData("12/03/2023"); err == nil {
+		t.Error("expected an error on invalid date")
+	}
+}
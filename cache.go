@@ -0,0 +1,346 @@
+package meteocat
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time to live of the cached responses depending on the endpoint
+const (
+	TTLImmutable = time.Duration(math.MaxInt64) // Validated measurements of historical days never change
+	TTLMetadata  = 6 * time.Hour                // Metadata, and measurements of closed days waiting for validation
+	TTLShort     = 5 * time.Minute              // Last measurements, warnings and data of the current day
+)
+
+// ValidationLag is the time after the end of a day until its readings are considered validated. Readings of the
+// days before are provisional and may still be revised.
+const ValidationLag = 7 * 24 * time.Hour
+
+// Cache is the interface implemented by the response cache backends. Values are opaque to the backends, the
+// expiration of the entries is handled by the client.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// dayPath matches the {any}/{mes}/{dia} segments of the endpoints that return data of a given day
+var dayPath = regexp.MustCompile(`/(\d{4})/(\d{2})/(\d{2})(/\d{1,2})?$`)
+
+// CacheTTL returns the time to live of the response of the endpoint u requested at time now. Data of the days closed
+// for longer than ValidationLag is immutable, last measurements are kept for a short time, and metadata and data of
+// the days waiting for validation for a medium one.
+func CacheTTL(u *neturl.URL, now time.Time) time.Duration {
+	switch {
+	case strings.HasSuffix(u.Path, "/ultimes"):
+		return TTLShort
+	case strings.HasSuffix(u.Path, "/metadades") || strings.Contains(u.Path, "/metadades/"):
+		return TTLMetadata
+	}
+
+	if m := dayPath.FindStringSubmatch(u.Path); m != nil {
+		day, err := time.Parse("2006/01/02", m[1]+"/"+m[2]+"/"+m[3])
+		switch end := day.AddDate(0, 0, 1); {
+		case err != nil:
+		case end.Add(ValidationLag).Before(now):
+			return TTLImmutable
+		case end.Before(now):
+			return TTLMetadata
+		}
+	}
+
+	return TTLShort
+}
+
+// cacheKey returns the key of a request built from the endpoint path and query
+func cacheKey(u *neturl.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	return u.Path + "?" + u.RawQuery
+}
+
+// cacheEntry holds a cached response
+type cacheEntry struct {
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"` // Zero for immutable entries
+}
+
+// fresh reports whether the entry can be served without contacting the API
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.Expires.IsZero() || now.Before(e.Expires)
+}
+
+// cacheTransport is an http.RoundTripper which serves GET requests from a Cache
+type cacheTransport struct {
+	cache     Cache
+	transport http.RoundTripper
+	ttl       func(*neturl.URL, time.Time) time.Duration
+	now       func() time.Time
+}
+
+// RoundTrip implements the http.RoundTripper interface. Stale entries with an ETag or a Last-Modified header are
+// revalidated with a conditional request.
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return t.transport.RoundTrip(req)
+	}
+
+	key := cacheKey(req.URL)
+	now := t.now()
+	stats := statsFromRequest(req)
+
+	var cached *cacheEntry
+	if b, ok := t.cache.Get(key); ok {
+		var e cacheEntry
+		if err := json.Unmarshal(b, &e); err == nil {
+			if e.fresh(now) {
+				if stats != nil {
+					stats.FromCache = true
+				}
+				return e.response(req), nil
+			}
+			if e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != "" {
+				cached = &e
+			}
+		}
+		if cached == nil {
+			t.cache.Delete(key)
+		}
+	}
+
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lm := cached.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		t.store(key, req.URL, cached, now)
+		if stats != nil {
+			stats.FromCache = true
+			stats.Revalidated = true
+		}
+		return cached.response(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.store(key, req.URL, &cacheEntry{Header: resp.Header, Body: body}, now)
+
+	return resp, nil
+}
+
+// store saves the entry in the cache setting its expiration according to the endpoint
+func (t *cacheTransport) store(key string, u *neturl.URL, e *cacheEntry, now time.Time) {
+	e.Stored = now
+	e.Expires = time.Time{}
+	if ttl := t.ttl(u, now); ttl != TTLImmutable {
+		e.Expires = now.Add(ttl)
+	}
+	if b, err := json.Marshal(e); err == nil {
+		t.cache.Set(key, b)
+	}
+}
+
+// response builds an http response from the cached entry
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// WithCache sets a response cache under the http client of the Settings, also of the one set by WithHttpClient.
+func WithCache(c Cache) Option {
+	return func(s *Settings) error {
+		if c == nil {
+			return errInvalidOption
+		}
+		s.cache = c
+		return nil
+	}
+}
+
+// NewCacheTransport returns an http.RoundTripper which serves GET requests from the cache c with the expiration of
+// CacheTTL and sends the rest through transport, or http.DefaultTransport when nil. WithCache sets it under the
+// clients of this package; servers relaying the API can use it directly.
+func NewCacheTransport(c Cache, transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &cacheTransport{
+		cache:     c,
+		transport: transport,
+		ttl:       CacheTTL,
+		now:       time.Now,
+	}
+}
+
+// LRUCache is an in-memory Cache which evicts the least recently used entries once it holds more than its size
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// lruItem holds an entry of the LRUCache
+type lruItem struct {
+	key   string
+	value []byte
+}
+
+// NewLRUCache returns a new LRUCache pointer which holds up to size entries
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored for key
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruItem).value, true
+}
+
+// Set stores value for key evicting the least recently used entry if the cache is full
+func (c *LRUCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruItem).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruItem{key: key, value: value})
+	for c.size > 0 && c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruItem).key)
+	}
+}
+
+// Delete removes the value stored for key
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of entries of the cache
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// DiskCache is a Cache which stores each entry in a file of a directory
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a new DiskCache pointer which stores the entries in dir, creating it if needed
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// path returns the file name where the entry of key is stored
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns the value stored for key
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set stores value for key. The file is written to a temporary file first so readers never see partial entries.
+func (c *DiskCache) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), c.path(key)); err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete removes the value stored for key
+func (c *DiskCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
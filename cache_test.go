@@ -0,0 +1,200 @@
+package meteocat
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestCacheTTL tests that the time to live depends on the endpoint.
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2023, 3, 12, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Duration{
+		"/xema/v1/variables/mesurades/32/2023/03/11?codiEstacio=D5": TTLMetadata,
+		"/xema/v1/variables/mesurades/32/2023/03/04?codiEstacio=D5": TTLImmutable,
+		"/xema/v1/variables/mesurades/32/2023/03/05":                TTLMetadata,
+		"/xema/v1/variables/mesurades/32/2023/03/12":                TTLShort,
+		"/xema/v1/variables/mesurades/32/ultimes?codiEstacio=D5":    TTLShort,
+		"/xema/v1/estacions/D5/metadades":                           TTLMetadata,
+		"/xema/v1/estacions/metadades?estat=ope&data=2023-03-11Z":   TTLMetadata,
+		"/xdde/v1/catalunya/2017/03/27/10":                          TTLImmutable,
+		"/pronostic/v1/avisos":                                      TTLShort,
+	}
+
+	for path, want := range tests {
+		u, _ := neturl.Parse(path)
+		if got := CacheTTL(u, now); got != want {
+			t.Errorf("CacheTTL(%s): expected %v, got %v", path, want, got)
+		}
+	}
+}
+
+// TestLRUCache tests that the least recently used entry is evicted.
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Get("a")
+	c.Set("c", []byte("3"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("expected a to be kept, got %q", v)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to be deleted")
+	}
+}
+
+// TestDiskCache tests that entries are stored on disk.
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meteocat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("/estacions/metadades", []byte("[]"))
+	if v, ok := c.Get("/estacions/metadades"); !ok || string(v) != "[]" {
+		t.Errorf("unexpected value %q", v)
+	}
+
+	c.Delete("/estacions/metadades")
+	if _, ok := c.Get("/estacions/metadades"); ok {
+		t.Error("expected the entry to be deleted")
+	}
+}
+
+// TestWithCache tests that the measurements of a closed day are requested only once.
+func TestWithCache(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/mesurades_dia_unica_estacio.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	oldURL := baseURL
+	baseURL = srv.URL + "%s"
+	defer func() { baseURL = oldURL }()
+
+	m, err := NewMesurades("", WithCache(NewLRUCache(10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		p, _ := NewParameters(
+			OptionCodiEstacio("D5"),
+			OptionCodiVariable("32"),
+			OptionData(Data{Any: "2023", Mes: "03", Dia: "12"}),
+		)
+		if err := m.MeasurementByDay(p); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Variable.Lectures) != 2 {
+			t.Fatalf("unexpected variable: %+v", m.Variable)
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("expected 1 request to the API, got %d", hits)
+	}
+
+	if http.DefaultClient.Transport != nil {
+		t.Errorf("WithCache modified the default http client: %T", http.DefaultClient.Transport)
+	}
+}
+
+// TestWithCacheOrder tests that the cache and the gzip support wrap the transport of a custom http client
+// whatever the order of the options.
+func TestWithCacheOrder(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/mesurades_dia_unica_estacio.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("X-Custom") != "yes" || r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	oldURL := baseURL
+	baseURL = srv.URL + "%s"
+	defer func() { baseURL = oldURL }()
+
+	client := &http.Client{Transport: headerTransport{"X-Custom", "yes"}}
+	for _, options := range [][]Option{
+		{WithHttpClient(client), WithCache(NewLRUCache(10))},
+		{WithCache(NewLRUCache(10)), WithHttpClient(client)},
+	} {
+		hits = 0
+		m, err := NewMesurades("", options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			p, _ := NewParameters(
+				OptionCodiEstacio("D5"),
+				OptionCodiVariable("32"),
+				OptionData(Data{Any: "2023", Mes: "03", Dia: "12"}),
+			)
+			if err := m.MeasurementByDay(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if hits != 1 {
+			t.Errorf("expected 1 request to the API, got %d", hits)
+		}
+	}
+
+	if client.Transport != (headerTransport{"X-Custom", "yes"}) {
+		t.Errorf("the options modified the custom http client: %T", client.Transport)
+	}
+}
+
+// headerTransport is an http.RoundTripper which sets a header on the requests
+type headerTransport struct {
+	name, value string
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.name, t.value)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestWithCacheNil tests that a nil cache is rejected.
+func TestWithCacheNil(t *testing.T) {
+	if _, err := NewMesurades("", WithCache(nil)); err != errInvalidOption {
+		t.Errorf("expected %v, got %v", errInvalidOption, err)
+	}
+}
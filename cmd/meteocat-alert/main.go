@@ -0,0 +1,132 @@
+// Command meteocat-alert evaluates threshold rules over the last XEMA readings and delivers the alerts by webhook.
+// It reads the API key from the METEOCAT_API_KEY environment variable and the rules and webhooks from a YAML file,
+// see the alert package for its format, and polls the last measurements of the variables of the rules at all the
+// stations.
+//
+// Usage:
+//
+//	meteocat-alert -config alerts.yaml [-interval 30m]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/alert"
+	"github.com/oscaromeu/meteocat/sink"
+)
+
+// minInterval bounds the polling interval. Stations report every 30 minutes, so polling faster only burns quota.
+const minInterval = 10 * time.Minute
+
+// catalogues fetches the station and variable catalogues used by the selectors and the alerts
+func catalogues(key string, options ...meteocat.Option) (meteocat.MetadadesTotesEstacions, meteocat.MetadadesVariables, error) {
+	e, err := meteocat.NewEstacions(key, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, _ := meteocat.NewParameters()
+	if err := e.StationsAll(p); err != nil {
+		return nil, nil, err
+	}
+	m, err := meteocat.NewMesurades(key, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.MeasurementMetadataAll(); err != nil {
+		return nil, nil, err
+	}
+	return e.MetadadesTotesEstacions, m.MetadadesVariables, nil
+}
+
+// poll writes the last readings of the variables to the engine
+func poll(e *alert.Engine, key string, variables []int, options ...meteocat.Option) {
+	for _, codi := range variables {
+		m, err := meteocat.NewMesurades(key, options...)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		p, _ := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)))
+		if err := m.MeasurementLast(p); err != nil {
+			log.Printf("variable %d: %v", codi, err)
+			continue
+		}
+		for _, st := range m.Measurements {
+			for _, v := range st.Variables {
+				if err := sink.WriteVariable(e, st.Codi, v); err != nil {
+					log.Printf("variable %d at %s: %v", codi, st.Codi, err)
+				}
+			}
+		}
+	}
+}
+
+func main() {
+	config := flag.String("config", "", "YAML file of the rules and webhooks")
+	interval := flag.Duration("interval", 30*time.Minute, "polling interval")
+	flag.Parse()
+
+	if *config == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *interval < minInterval {
+		fmt.Fprintf(os.Stderr, "interval must be at least %v\n", minInterval)
+		os.Exit(2)
+	}
+
+	c, err := alert.LoadConfig(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	key := os.Getenv("METEOCAT_API_KEY")
+	options := []meteocat.Option{meteocat.WithCache(meteocat.NewLRUCache(100))}
+
+	stations, metadata, err := catalogues(key, options...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e, err := alert.NewEngine(c, alert.WithStations(stations), alert.WithVariables(metadata))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var variables []int
+	seen := make(map[int]bool)
+	for _, r := range c.Rules {
+		for _, v := range r.Variables {
+			if !seen[v] {
+				seen[v] = true
+				variables = append(variables, v)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	log.Printf("evaluating %d rules over %d variables every %v", len(c.Rules), len(variables), *interval)
+	for {
+		poll(e, key, variables, options...)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
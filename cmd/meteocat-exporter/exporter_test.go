@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// redirect is a transport which sends every request to a test server
+type redirect struct {
+	host string
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = "http"
+	req.URL.Host = r.host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newAPIServer starts a test server which answers the API paths with testdata fixtures and returns an option
+// which points the clients at it
+func newAPIServer(t *testing.T, routes map[string]string) (*httptest.Server, meteocat.Option) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := ioutil.ReadFile("../../testdata/" + fixture)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	return srv, meteocat.WithHttpClient(&http.Client{Transport: redirect{host}})
+}
+
+// TestPoll tests that a poll exposes the last readings with the station labels and the exporter health.
+func TestPoll(t *testing.T) {
+	srv, option := newAPIServer(t, map[string]string{
+		"/xema/v1/estacions/metadades":            "metadades_totes_estacions.json",
+		"/xema/v1/variables/mesurades/metadades":  "variables_mesurades_metadades.json",
+		"/xema/v1/variables/mesurades/32/ultimes": "mesurades_dia_totes_estacions.json",
+		"/quotes/v1/consum-actual":                "quotes_consum_actual.json",
+	})
+	defer srv.Close()
+
+	e := newExporter("", []string{"32", "33"}, 0, 0, option)
+	e.now = func() time.Time { return time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC) }
+
+	interval := e.poll(context.Background())
+
+	var b strings.Builder
+	if err := e.reg.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE meteocat_lectura gauge\n",
+		`meteocat_lectura{codi_estacio="C6",nom_estacio="Castellnou de Seana",comarca="Pla d'Urgell",codi_variable="32",acronim="T"} 11.3` + "\n",
+		`meteocat_lectura_timestamp_seconds{codi_estacio="C6",nom_estacio="Castellnou de Seana",comarca="Pla d'Urgell",codi_variable="32",acronim="T"} 1.6786638e+09` + "\n",
+		`meteocat_api_errors_total{endpoint="ultimes"} 1` + "\n",
+		`meteocat_api_request_duration_seconds_count{endpoint="ultimes"} 2` + "\n",
+		`meteocat_quota_remaining{plan="XEMA_750"} 612` + "\n",
+		`meteocat_quota_limit{plan="XEMA_750"} 750` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, out)
+		}
+	}
+
+	// 612 remaining requests of 2 per poll spread over the 20 days left of March, the quota call being free
+	if want := 20 * 24 * time.Hour / 306; interval != want {
+		t.Errorf("expected an interval of %v, got %v", want, interval)
+	}
+}
+
+// TestPollAll tests that all the variables of the catalogue are polled with -vars all.
+func TestPollAll(t *testing.T) {
+	srv, option := newAPIServer(t, map[string]string{
+		"/xema/v1/estacions/metadades":           "metadades_totes_estacions.json",
+		"/xema/v1/variables/mesurades/metadades": "variables_mesurades_metadades.json",
+	})
+	defer srv.Close()
+
+	e := newExporter("", []string{"all"}, time.Hour, 0, option)
+	if interval := e.poll(context.Background()); interval != time.Hour {
+		t.Errorf("expected the configured interval, got %v", interval)
+	}
+	if strings.Join(e.variables, ",") != "32,33,35" {
+		t.Errorf("unexpected variables %v", e.variables)
+	}
+}
+
+// TestUpdateRemovedStation tests that the gauges of a station missing from the next response are removed, and that
+// those of the other variables are kept.
+func TestUpdateRemovedStation(t *testing.T) {
+	e := newExporter("", []string{"32", "33"}, time.Hour, 0)
+	reading := func(codi int, valor float64) meteocat.Variable {
+		return meteocat.Variable{Codi: codi, Lectures: []meteocat.Lectura{{Data: "2023-03-12T10:00Z", Valor: valor}}}
+	}
+	e.update(32, meteocat.Measurements{
+		{Codi: "D5", Variables: []meteocat.Variable{reading(32, 12)}},
+		{Codi: "UG", Variables: []meteocat.Variable{reading(32, 9)}},
+	})
+	e.update(33, meteocat.Measurements{{Codi: "UG", Variables: []meteocat.Variable{reading(33, 80)}}})
+	e.update(32, meteocat.Measurements{{Codi: "D5", Variables: []meteocat.Variable{reading(32, 13)}}})
+
+	var b strings.Builder
+	if err := e.reg.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`meteocat_lectura{codi_estacio="D5",nom_estacio="",comarca="",codi_variable="32",acronim=""} 13`,
+		`meteocat_lectura{codi_estacio="UG",nom_estacio="",comarca="",codi_variable="33",acronim=""} 80`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `codi_estacio="UG",nom_estacio="",comarca="",codi_variable="32"`) {
+		t.Errorf("unexpected gauges of the removed station:\n%s", out)
+	}
+}
+
+// TestNextInterval tests the bounds of the interval derived from the quota.
+func TestNextInterval(t *testing.T) {
+	now := time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		remaining int
+		want      time.Duration
+	}{
+		{0, maxInterval},
+		{1, maxInterval},
+		{24, 2 * time.Hour},
+		{10000, minInterval},
+	} {
+		if got := nextInterval(meteocat.Pla{ConsultesRestants: c.remaining}, 2, now); got != c.want {
+			t.Errorf("nextInterval with %d remaining: expected %v, got %v", c.remaining, c.want, got)
+		}
+	}
+	if got := nextInterval(meteocat.Pla{ConsultesRestants: 24}, 0, now); got != maxInterval {
+		t.Errorf("nextInterval without calls: expected %v, got %v", maxInterval, got)
+	}
+}
+
+// TestRegistry tests the text exposition format and the escaping of label values.
+func TestRegistry(t *testing.T) {
+	r := newRegistry()
+	r.describe("a_gauge", "gauge", "A gauge\nwith two lines.")
+	r.describe("b_summary", "summary", "A summary.")
+	r.set("a_gauge", 1.5, "nom", `Sant "Pere"\`)
+	r.observe("b_summary", 0.25)
+	r.observe("b_summary", 0.5)
+
+	var b strings.Builder
+	r.write(&b)
+
+	want := `# HELP a_gauge A gauge\nwith two lines.
+# TYPE a_gauge gauge
+a_gauge{nom="Sant \"Pere\"\\"} 1.5
+# HELP b_summary A summary.
+# TYPE b_summary summary
+b_summary_count 2
+b_summary_sum 0.75
+`
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,320 @@
+// Command meteocat-exporter is a Prometheus exporter of the last XEMA readings. It reads the API key from the
+// METEOCAT_API_KEY environment variable, polls the last measurements of the configured variables at all the
+// stations and serves them as gauges on /metrics.
+//
+// Usage:
+//
+//	meteocat-exporter [-listen :9724] [-vars 32,33,35|all] [-interval 30m] [-spacing 1s]
+//
+// Without -interval the polling interval is derived from the remaining quota of the XEMA plan, spreading the
+// remaining requests until the end of the month. The exporter also serves its health: API latency, errors and
+// remaining quota.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// Bounds of the polling interval. Stations report every 30 minutes, so polling faster than minInterval only burns
+// quota, and the exporter polls at least once a day even if the quota is exhausted.
+const (
+	minInterval     = 10 * time.Minute
+	maxInterval     = 24 * time.Hour
+	defaultInterval = time.Hour
+)
+
+// Metric names
+const (
+	metricReading      = "meteocat_lectura"
+	metricReadingTime  = "meteocat_lectura_timestamp_seconds"
+	metricLatency      = "meteocat_api_request_duration_seconds"
+	metricErrors       = "meteocat_api_errors_total"
+	metricQuota        = "meteocat_quota_remaining"
+	metricQuotaLimit   = "meteocat_quota_limit"
+	metricInterval     = "meteocat_poll_interval_seconds"
+	metricLastPoll     = "meteocat_last_poll_timestamp_seconds"
+	metricPollDuration = "meteocat_poll_duration_seconds"
+)
+
+// exporter polls the last readings and updates the metrics registry
+type exporter struct {
+	key       string
+	options   []meteocat.Option
+	all       bool     // Poll all the variables of the catalogue
+	variables []string // Codes of the polled variables
+	interval  time.Duration
+	spacing   time.Duration // Delay between two API calls
+	stations  map[string]meteocat.MetadadesEstacions
+	metadata  map[int]meteocat.MetadadesVariable
+	reg       *registry
+	now       func() time.Time
+}
+
+// newExporter returns a new exporter with its metrics described. A zero interval is derived from the quota.
+func newExporter(key string, variables []string, interval, spacing time.Duration, options ...meteocat.Option) *exporter {
+	e := &exporter{
+		key:       key,
+		options:   options,
+		all:       len(variables) == 1 && variables[0] == "all",
+		variables: variables,
+		interval:  interval,
+		spacing:   spacing,
+		reg:       newRegistry(),
+		now:       time.Now,
+	}
+	if e.all {
+		e.variables = nil
+	}
+
+	e.reg.describe(metricReading, "gauge", "Last reading of a variable at a station.")
+	e.reg.describe(metricReadingTime, "gauge", "Time of the last reading of a variable at a station.")
+	e.reg.describe(metricLatency, "summary", "Latency of the API requests.")
+	e.reg.describe(metricErrors, "counter", "Failed API requests.")
+	e.reg.describe(metricQuota, "gauge", "Remaining requests of an API plan in the current period.")
+	e.reg.describe(metricQuotaLimit, "gauge", "Maximum requests of an API plan in the current period.")
+	e.reg.describe(metricInterval, "gauge", "Current polling interval.")
+	e.reg.describe(metricLastPoll, "gauge", "Time of the last poll.")
+	e.reg.describe(metricPollDuration, "gauge", "Duration of the last poll.")
+	return e
+}
+
+// call runs an API request recording its latency and errors
+func (e *exporter) call(endpoint string, f func() error) error {
+	start := e.now()
+	err := f()
+	e.reg.observe(metricLatency, e.now().Sub(start).Seconds(), "endpoint", endpoint)
+	if err != nil {
+		e.reg.inc(metricErrors, "endpoint", endpoint)
+		log.Printf("%s: %v", endpoint, err)
+	}
+	return err
+}
+
+// loadMetadata fetches the station and variable catalogues used for the labels
+func (e *exporter) loadMetadata() error {
+	if e.stations == nil {
+		es, err := meteocat.NewEstacions(e.key, e.options...)
+		if err != nil {
+			return err
+		}
+		p, _ := meteocat.NewParameters()
+		if err := e.call("estacions", func() error { return es.StationsAll(p) }); err != nil {
+			return err
+		}
+		e.stations = make(map[string]meteocat.MetadadesEstacions, len(es.MetadadesTotesEstacions))
+		for _, st := range es.MetadadesTotesEstacions {
+			e.stations[st.Codi] = st
+		}
+	}
+
+	if e.metadata == nil {
+		m, err := meteocat.NewMesurades(e.key, e.options...)
+		if err != nil {
+			return err
+		}
+		if err := e.call("metadades", m.MeasurementMetadataAll); err != nil {
+			return err
+		}
+		e.metadata = make(map[int]meteocat.MetadadesVariable, len(m.MetadadesVariables))
+		for _, v := range m.MetadadesVariables {
+			e.metadata[v.Codi] = v.MetadadesVariable
+		}
+		if e.all {
+			for _, v := range m.MetadadesVariables {
+				e.variables = append(e.variables, strconv.Itoa(v.Codi))
+			}
+		}
+	}
+	return nil
+}
+
+// update sets the gauges of the last reading of each station. The gauges of the stations missing from the response,
+// e.g decommissioned, are removed.
+func (e *exporter) update(codi int, m meteocat.Measurements) {
+	meta := e.metadata[codi]
+	var readings, times []gauge
+	for _, st := range m {
+		for _, v := range st.Variables {
+			if v.Codi != codi || len(v.Lectures) == 0 {
+				continue
+			}
+			last := v.Lectures[len(v.Lectures)-1]
+			t, err := meteocat.ParseData(last.Data)
+			if err != nil {
+				continue
+			}
+
+			station := e.stations[st.Codi]
+			labels := []string{
+				"codi_estacio", st.Codi,
+				"nom_estacio", station.Nom,
+				"comarca", station.Comarca.Nom,
+				"codi_variable", strconv.Itoa(codi),
+				"acronim", meta.Acronim,
+			}
+			readings = append(readings, gauge{labels, last.Valor})
+			times = append(times, gauge{labels, float64(t.Unix())})
+		}
+	}
+	e.reg.replace(metricReading, "codi_variable", strconv.Itoa(codi), readings)
+	e.reg.replace(metricReadingTime, "codi_variable", strconv.Itoa(codi), times)
+}
+
+// nextInterval returns the polling interval which spreads the remaining requests of the plan until the end of the
+// month, given the number of requests of a poll
+func nextInterval(p meteocat.Pla, calls int, now time.Time) time.Duration {
+	now = now.UTC()
+	end := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+	if calls <= 0 {
+		return maxInterval
+	}
+	polls := p.ConsultesRestants / calls
+	if polls <= 0 {
+		return maxInterval
+	}
+	interval := end.Sub(now) / time.Duration(polls)
+	switch {
+	case interval < minInterval:
+		return minInterval
+	case interval > maxInterval:
+		return maxInterval
+	}
+	return interval
+}
+
+// wait sleeps for d, returning false if the context is cancelled
+func wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// poll fetches the last readings of every variable and the quota, and returns the interval until the next poll
+func (e *exporter) poll(ctx context.Context) time.Duration {
+	start := e.now()
+	interval := e.interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	if err := e.loadMetadata(); err != nil {
+		return interval
+	}
+
+	m, err := meteocat.NewMesurades(e.key, e.options...)
+	if err != nil {
+		log.Print(err)
+		return interval
+	}
+	for i, v := range e.variables {
+		if i > 0 && !wait(ctx, e.spacing) {
+			return interval
+		}
+		p, err := meteocat.NewParameters(meteocat.OptionCodiVariable(v))
+		if err != nil {
+			log.Printf("variable %s: %v", v, err)
+			continue
+		}
+		m.Measurements = nil
+		if err := e.call("ultimes", func() error { return m.MeasurementLast(p) }); err != nil {
+			continue
+		}
+		codi, _ := strconv.Atoi(v)
+		e.update(codi, m.Measurements)
+	}
+
+	q, err := meteocat.NewQuotes(e.key, e.options...)
+	if err == nil {
+		err = e.call("quotes", q.Consumption)
+	}
+	if err == nil {
+		for _, p := range q.Plans {
+			e.reg.set(metricQuota, float64(p.ConsultesRestants), "plan", p.Nom)
+			e.reg.set(metricQuotaLimit, float64(p.MaxConsultes), "plan", p.Nom)
+		}
+		if p, ok := q.Plan("XEMA"); ok && e.interval == 0 {
+			interval = nextInterval(p, len(e.variables), e.now())
+		}
+	}
+
+	e.reg.set(metricInterval, interval.Seconds())
+	e.reg.set(metricLastPoll, float64(e.now().Unix()))
+	e.reg.set(metricPollDuration, e.now().Sub(start).Seconds())
+	return interval
+}
+
+// run polls until the context is cancelled
+func (e *exporter) run(ctx context.Context) {
+	for {
+		interval := e.poll(ctx)
+		log.Printf("next poll in %v", interval.Round(time.Second))
+		if !wait(ctx, interval) {
+			return
+		}
+	}
+}
+
+func main() {
+	listen := flag.String("listen", ":9724", "address of the metrics server")
+	variables := flag.String("vars", "32,33,35", "comma separated variable codes, or all")
+	interval := flag.Duration("interval", 0, "polling interval, derived from the quota when not set")
+	spacing := flag.Duration("spacing", time.Second, "delay between API calls")
+	flag.Parse()
+
+	if *interval != 0 && *interval < minInterval {
+		fmt.Fprintf(os.Stderr, "interval must be at least %v\n", minInterval)
+		os.Exit(2)
+	}
+
+	var vars []string
+	for _, v := range strings.Split(*variables, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vars = append(vars, v)
+		}
+	}
+	if len(vars) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	e := newExporter(os.Getenv("METEOCAT_API_KEY"), vars, *interval, *spacing)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	go e.run(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e.reg)
+	srv := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("serving metrics on %s/metrics", *listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelEscaper escapes label values of the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// helpEscaper escapes help texts of the text exposition format
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// sample is a sample of a metric family, e.g the _sum or _count of a summary
+type sample struct {
+	suffix string
+	labels string   // Rendered labels, e.g {codi_estacio="D5"}
+	pairs  []string // Label name and value pairs
+	value  float64
+}
+
+// gauge is the value of a gauge with its label name and value pairs
+type gauge struct {
+	labels []string
+	value  float64
+}
+
+// family is a metric family with its samples by suffix and labels
+type family struct {
+	help    string
+	typ     string // gauge, counter or summary
+	samples map[string]*sample
+}
+
+// registry holds the metrics served in the Prometheus text exposition format
+type registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// newRegistry returns a new empty registry
+func newRegistry() *registry {
+	return &registry{families: make(map[string]*family)}
+}
+
+// describe registers a metric family
+func (r *registry) describe(name, typ, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families[name] = &family{help: help, typ: typ, samples: make(map[string]*sample)}
+}
+
+// renderLabels renders label name and value pairs
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// sample returns the sample of a family with the given suffix and labels, creating it if needed
+func (r *registry) sample(name, suffix string, labels []string) *sample {
+	f, ok := r.families[name]
+	if !ok {
+		panic("undescribed metric " + name)
+	}
+	l := renderLabels(labels)
+	s, ok := f.samples[suffix+l]
+	if !ok {
+		s = &sample{suffix: suffix, labels: l, pairs: labels}
+		f.samples[suffix+l] = s
+	}
+	return s
+}
+
+// set sets the value of a gauge. Labels are given as name and value pairs.
+func (r *registry) set(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sample(name, "", labels).value = value
+}
+
+// hasLabel reports whether the label name and value pairs contain the given label value
+func hasLabel(pairs []string, label, value string) bool {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == label && pairs[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// replace replaces the samples of a gauge having the given label value by gs, at once so that a scrape never sees a
+// partial set. Samples missing from gs are removed.
+func (r *registry) replace(name, label, value string, gs []gauge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		panic("undescribed metric " + name)
+	}
+	for k, s := range f.samples {
+		if hasLabel(s.pairs, label, value) {
+			delete(f.samples, k)
+		}
+	}
+	for _, g := range gs {
+		r.sample(name, "", g.labels).value = g.value
+	}
+}
+
+// inc increments a counter. Labels are given as name and value pairs.
+func (r *registry) inc(name string, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sample(name, "", labels).value++
+}
+
+// observe adds an observation to a summary. Labels are given as name and value pairs.
+func (r *registry) observe(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sample(name, "_sum", labels).value += value
+	r.sample(name, "_count", labels).value++
+}
+
+// write writes the metrics in the text exposition format, sorted by name and labels
+func (r *registry) write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for n := range r.families {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, n := range names {
+		f := r.families[n]
+		fmt.Fprintf(&b, "# HELP %s %s\n", n, helpEscaper.Replace(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", n, f.typ)
+
+		keys := make([]string, 0, len(f.samples))
+		for k := range f.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.samples[k]
+			fmt.Fprintf(&b, "%s%s%s %s\n", n, s.suffix, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP implements the http.Handler interface to serve the metrics
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.write(w)
+}
@@ -0,0 +1,90 @@
+// Command meteocat-mqtt publishes the new XEMA readings to an MQTT broker. It reads the API key from the
+// METEOCAT_API_KEY environment variable and the broker password from MQTT_PASSWORD, polls the last measurements of
+// the configured variables at all the stations and publishes the readings newer than the last published ones, with
+// the Home Assistant discovery configs of their sensors.
+//
+// Usage:
+//
+//	meteocat-mqtt [-broker tcp://localhost:1883] [-vars 32,33,35] [-interval 30m] [-prefix meteocat] [-discovery homeassistant]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/mqtt"
+)
+
+// minInterval bounds the polling interval. Stations report every 30 minutes, so polling faster only burns quota.
+const minInterval = 10 * time.Minute
+
+func main() {
+	broker := flag.String("broker", "tcp://localhost:1883", "URL of the MQTT broker")
+	clientID := flag.String("client-id", "meteocat-mqtt", "MQTT client identifier")
+	username := flag.String("username", "", "MQTT user name")
+	variables := flag.String("vars", "32,33,35", "comma separated variable codes")
+	interval := flag.Duration("interval", 30*time.Minute, "polling interval")
+	prefix := flag.String("prefix", mqtt.DefaultTopicPrefix, "prefix of the topics of the readings")
+	discovery := flag.String("discovery", mqtt.DefaultDiscoveryPrefix, "prefix of the Home Assistant discovery topics, empty to disable them")
+	cacheSize := flag.Int("cache-size", 100, "entries of the in-memory response cache")
+	flag.Parse()
+
+	if *interval < minInterval {
+		fmt.Fprintf(os.Stderr, "interval must be at least %v\n", minInterval)
+		os.Exit(2)
+	}
+	var codes []int
+	for _, v := range strings.Split(*variables, ",") {
+		codi, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid variable code %q\n", v)
+			os.Exit(2)
+		}
+		codes = append(codes, codi)
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(*broker).
+		SetClientID(*clientID).
+		SetUsername(*username).
+		SetPassword(os.Getenv("MQTT_PASSWORD")).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	client := paho.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatal(token.Error())
+	}
+	defer client.Disconnect(1000)
+
+	p, err := mqtt.NewPublisher(os.Getenv("METEOCAT_API_KEY"), client,
+		mqtt.WithVariables(codes...),
+		mqtt.WithTopicPrefix(*prefix),
+		mqtt.WithDiscoveryPrefix(*discovery),
+		mqtt.WithClientOptions(meteocat.WithCache(meteocat.NewLRUCache(*cacheSize))),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	log.Printf("publishing to %s every %v", *broker, *interval)
+	p.Run(ctx, *interval)
+}
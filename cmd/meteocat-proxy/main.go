@@ -0,0 +1,81 @@
+// Command meteocat-proxy is a caching proxy of the XEMA endpoints of the Meteocat API, to share one API key across a
+// team. It serves the same /xema/v1/ paths as api.meteo.cat and injects the API key read from the METEOCAT_API_KEY
+// environment variable.
+//
+// Usage:
+//
+//	meteocat-proxy -tokens tokens.txt [-listen :9725] [-rate 60] [-burst 10] [-cache-dir dir] [-cache-size 1000]
+//
+// Clients authenticate with an internal token instead of the API key. The tokens file holds a client name and a
+// token of 40 characters per line, e.g generated with `openssl rand -hex 20`, and each client is limited to -rate
+// requests per minute. Responses are cached with the expiration of meteocat.CacheTTL and identical requests in
+// flight are sent to the API once. Conditional requests matching the ETag or Last-Modified of the cached response are
+// answered with 304, and the counters of the proxy are served on /metrics.
+//
+// The clients of the meteocat package use the proxy with the WithBaseURL option:
+//
+//	m, err := meteocat.NewMesurades(token, meteocat.WithBaseURL("http://localhost:9725/xema/v1"))
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+func main() {
+	listen := flag.String("listen", ":9725", "address of the proxy server")
+	tokensFile := flag.String("tokens", "", "file of client names and internal tokens")
+	rate := flag.Float64("rate", 60, "requests per minute allowed to each client")
+	burst := flag.Int("burst", 10, "burst of requests allowed to each client")
+	cacheDir := flag.String("cache-dir", "", "directory of the response cache, in memory when not set")
+	cacheSize := flag.Int("cache-size", 1000, "entries of the in-memory response cache")
+	upstream := flag.String("upstream", "https://api.meteo.cat", "base URL of the API")
+	flag.Parse()
+
+	if *tokensFile == "" || *rate <= 0 || *burst <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	key := os.Getenv("METEOCAT_API_KEY")
+	if err := meteocat.ValidAPIKey(key); err != nil {
+		fmt.Fprintln(os.Stderr, "METEOCAT_API_KEY must hold a valid API key")
+		os.Exit(2)
+	}
+
+	tokens, err := openTokens(*tokensFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var cache meteocat.Cache = meteocat.NewLRUCache(*cacheSize)
+	if *cacheDir != "" {
+		if cache, err = meteocat.NewDiskCache(*cacheDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	p := newProxy(key, *upstream, tokens, *rate/60, *burst, cache)
+	srv := &http.Server{Addr: *listen, Handler: p, ReadHeaderTimeout: 10 * time.Second}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("proxying %s%s for %d clients on %s", *upstream, xemaPrefix, len(tokens), *listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
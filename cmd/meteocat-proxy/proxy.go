@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// xemaPrefix is the path prefix of the proxied endpoints, the same as on api.meteo.cat
+const xemaPrefix = "/xema/v1/"
+
+// loadTokens reads the internal tokens of the clients from r. Each line holds a client name and its token, blank
+// lines and lines starting with # are ignored. Tokens have the length of an API key so the clients of the meteocat
+// package accept them.
+func loadTokens(r io.Reader) (map[string]string, error) {
+	tokens := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a name and a token", n)
+		}
+		if err := meteocat.ValidAPIKey(fields[1]); err != nil {
+			return nil, fmt.Errorf("line %d: token must have 40 characters", n)
+		}
+		if _, ok := tokens[fields[1]]; ok {
+			return nil, fmt.Errorf("line %d: duplicate token", n)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	return tokens, s.Err()
+}
+
+// limiter is a token bucket which allows rate requests per second with bursts of burst requests
+type limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket at time now, or returns the time until the next one is available
+func (l *limiter) allow(now time.Time) (bool, time.Duration) {
+	if l.last.IsZero() {
+		l.tokens = l.burst
+	} else {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// response is an upstream response shared by the requests of a flight
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// call is an upstream request in flight
+type call struct {
+	done chan struct{}
+	resp response
+}
+
+// group deduplicates identical upstream requests in flight
+type group struct {
+	mu     sync.Mutex
+	calls  map[string]*call
+	shared int64 // Requests served by the call of another one
+}
+
+// do runs fetch once for all the concurrent callers with the same key
+func (g *group) do(key string, fetch func() response) response {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.shared++
+		g.mu.Unlock()
+		<-c.done
+		return c.resp
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.resp = fetch()
+	close(c.done)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.resp
+}
+
+// proxy relays the XEMA endpoints to the API with the key of the server, serving the responses from the cache
+// of its client
+type proxy struct {
+	key      string
+	upstream string // Scheme and host of the API, e.g https://api.meteo.cat
+	client   *http.Client
+	tokens   map[string]string // Client names by internal token
+	rate     float64           // Requests per second allowed to each client
+	burst    int
+	now      func() time.Time
+
+	mu       sync.Mutex
+	limiters map[string]*limiter // Rate limiters by client name
+	flights  group
+}
+
+// newProxy returns a new proxy which caches the responses in cache
+func newProxy(key, upstream string, tokens map[string]string, rate float64, burst int, cache meteocat.Cache) *proxy {
+	return &proxy{
+		key:      key,
+		upstream: strings.TrimSuffix(upstream, "/"),
+		client:   &http.Client{Transport: meteocat.NewCacheTransport(cache, nil), Timeout: 30 * time.Second},
+		tokens:   tokens,
+		rate:     rate,
+		burst:    burst,
+		now:      time.Now,
+		limiters: make(map[string]*limiter),
+	}
+}
+
+// writeError writes an error with the JSON body of the API errors
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(meteocat.APIError{Message: message})
+}
+
+// allow applies the rate limit of a client
+func (p *proxy) allow(name string) (bool, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.limiters[name]
+	if !ok {
+		l = &limiter{rate: p.rate, burst: float64(p.burst)}
+		p.limiters[name] = l
+	}
+	return l.allow(p.now())
+}
+
+// fetch requests an endpoint to the API with the key of the server
+func (p *proxy) fetch(uri string) response {
+	req, err := http.NewRequest("GET", p.upstream+uri, nil)
+	if err != nil {
+		return response{err: err}
+	}
+	req.Header.Set("X-Api-Key", p.key)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return response{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response{err: err}
+	}
+	return response{status: resp.StatusCode, header: resp.Header, body: body}
+}
+
+// writeMetrics writes the counters of the proxy in the Prometheus text exposition format
+func (p *proxy) writeMetrics(w http.ResponseWriter) {
+	p.flights.mu.Lock()
+	shared := p.flights.shared
+	p.flights.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP meteocat_proxy_shared_requests_total Requests served by an identical upstream request in flight.")
+	fmt.Fprintln(w, "# TYPE meteocat_proxy_shared_requests_total counter")
+	fmt.Fprintf(w, "meteocat_proxy_shared_requests_total %d\n", shared)
+}
+
+// notModified reports whether the conditional headers of the request match the validators of the response
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimSpace(t); t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// ServeHTTP implements the http.Handler interface. Clients authenticate with their internal token in the
+// X-Api-Key header, as they would against the API.
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		p.writeMetrics(w)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, xemaPrefix) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	name, ok := p.tokens[r.Header.Get("X-Api-Key")]
+	if !ok {
+		writeError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	if ok, wait := p.allow(name); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+		return
+	}
+
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+	resp := p.flights.do(uri, func() response { return p.fetch(uri) })
+	if resp.err != nil {
+		log.Printf("%s %s: %v", name, uri, resp.err)
+		writeError(w, http.StatusBadGateway, "Bad Gateway")
+		return
+	}
+	if resp.status != http.StatusOK {
+		log.Printf("%s %s: upstream status %d", name, uri, resp.status)
+	}
+
+	for _, h := range []string{"Content-Type", "ETag", "Last-Modified"} {
+		if v := resp.header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	if resp.status == http.StatusOK && notModified(r, resp.header) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// openTokens reads the tokens file at path
+func openTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadTokens(f)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+const (
+	serverKey = "0123456789012345678901234567890123456789"
+	token     = "abcdefabcdefabcdefabcdefabcdefabcdefabcd"
+)
+
+// newUpstream starts a test server which answers every path with a testdata fixture, checking the key of the
+// proxy, and counts the requests. Requests wait for release when it is not nil.
+func newUpstream(t *testing.T, fixture string, release chan struct{}) (*httptest.Server, *int32) {
+	t.Helper()
+
+	body, err := ioutil.ReadFile("../../testdata/" + fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if key := r.Header.Get("X-Api-Key"); key != serverKey {
+			t.Errorf("unexpected upstream key %q", key)
+		}
+		if release != nil {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	return srv, &hits
+}
+
+// TestProxy tests that a client of the package fetches data through the proxy and repeated requests are served
+// from the cache.
+func TestProxy(t *testing.T) {
+	upstream, hits := newUpstream(t, "mesurades_dia_unica_estacio.json", nil)
+	defer upstream.Close()
+
+	p := newProxy(serverKey, upstream.URL, map[string]string{token: "alice"}, 10, 10, meteocat.NewLRUCache(10))
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	m, err := meteocat.NewMesurades(token, meteocat.WithBaseURL(srv.URL+"/xema/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		params, _ := meteocat.NewParameters(
+			meteocat.OptionCodiEstacio("D5"),
+			meteocat.OptionCodiVariable("32"),
+			meteocat.OptionData(meteocat.Data{Any: "2023", Mes: "03", Dia: "12"}),
+		)
+		if err := m.MeasurementByDay(params); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Variable.Lectures) != 2 {
+			t.Fatalf("unexpected variable: %+v", m.Variable)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("expected 1 upstream request, got %d", n)
+	}
+}
+
+// TestProxyErrors tests that unknown tokens, other paths and methods are rejected.
+func TestProxyErrors(t *testing.T) {
+	upstream, hits := newUpstream(t, "mesurades_dia_unica_estacio.json", nil)
+	defer upstream.Close()
+
+	p := newProxy(serverKey, upstream.URL, map[string]string{token: "alice"}, 10, 10, meteocat.NewLRUCache(10))
+
+	for _, tc := range []struct {
+		method, path, key string
+		status            int
+	}{
+		{"GET", "/xema/v1/estacions/metadades", serverKey, http.StatusForbidden},
+		{"GET", "/xema/v1/estacions/metadades", "", http.StatusForbidden},
+		{"GET", "/quotes/v1/consum-actual", token, http.StatusNotFound},
+		{"POST", "/xema/v1/estacions/metadades", token, http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("X-Api-Key", tc.key)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 0 {
+		t.Errorf("expected no upstream requests, got %d", n)
+	}
+}
+
+// TestProxySingleflight tests that identical concurrent requests are sent upstream once.
+func TestProxySingleflight(t *testing.T) {
+	release := make(chan struct{})
+	upstream, hits := newUpstream(t, "mesurades_dia_totes_estacions.json", release)
+	defer upstream.Close()
+
+	p := newProxy(serverKey, upstream.URL, map[string]string{token: "alice"}, 10, 10, meteocat.NewLRUCache(10))
+
+	const n = 5
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/xema/v1/variables/mesurades/32/ultimes", nil)
+			req.Header.Set("X-Api-Key", token)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, req)
+			codes[i] = w.Code
+		}(i)
+	}
+
+	// Wait for all the requests to join the flight before answering
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		p.flights.mu.Lock()
+		joined := p.flights.shared == n-1
+		p.flights.mu.Unlock()
+		if joined {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("requests did not join the flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: unexpected status %d", i, code)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("expected 1 upstream request, got %d", n)
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if want := fmt.Sprintf("meteocat_proxy_shared_requests_total %d\n", n-1); !strings.Contains(w.Body.String(), want) {
+		t.Errorf("expected %q in the metrics:\n%s", want, w.Body.String())
+	}
+}
+
+// TestProxyConditional tests that the proxy answers 304 to the requests whose validators match the cached response.
+func TestProxyConditional(t *testing.T) {
+	lastModified := "Sun, 12 Mar 2023 10:00:00 GMT"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte("[]"))
+	}))
+	defer upstream.Close()
+
+	p := newProxy(serverKey, upstream.URL, map[string]string{token: "alice"}, 10, 10, meteocat.NewLRUCache(10))
+
+	for _, tc := range []struct {
+		header, value string
+		status        int
+	}{
+		{"", "", http.StatusOK},
+		{"If-None-Match", `"v1"`, http.StatusNotModified},
+		{"If-None-Match", `"v0", W/"v1"`, http.StatusNotModified},
+		{"If-None-Match", `"v0"`, http.StatusOK},
+		{"If-Modified-Since", lastModified, http.StatusNotModified},
+		{"If-Modified-Since", "Sun, 12 Mar 2023 09:00:00 GMT", http.StatusOK},
+		{"If-Modified-Since", "yesterday", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/xema/v1/estacions/metadades", nil)
+		req.Header.Set("X-Api-Key", token)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.header, tc.value, tc.status, w.Code)
+		}
+		if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != `"v1"`) {
+			t.Errorf("%s %s: unexpected response %q with ETag %q", tc.header, tc.value, w.Body.String(), w.Header().Get("ETag"))
+		}
+	}
+}
+
+// TestRateLimit tests that each client is limited to its burst and refilled at the rate.
+func TestRateLimit(t *testing.T) {
+	upstream, _ := newUpstream(t, "mesurades_dia_unica_estacio.json", nil)
+	defer upstream.Close()
+
+	other := strings.Repeat("f", 40)
+	p := newProxy(serverKey, upstream.URL, map[string]string{token: "alice", other: "bob"}, 0.5, 2, meteocat.NewLRUCache(10))
+	now := time.Date(2023, 3, 12, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xema/v1/estacions/metadades", nil)
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get(token); w.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d", i, w.Code)
+		}
+	}
+	w := get(token)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected status 429 retrying after 2s, got %d after %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get(other); w.Code != http.StatusOK {
+		t.Errorf("other client: unexpected status %d", w.Code)
+	}
+
+	now = now.Add(2 * time.Second)
+	if w := get(token); w.Code != http.StatusOK {
+		t.Errorf("after refill: unexpected status %d", w.Code)
+	}
+}
+
+// TestLoadTokens tests the parsing of the tokens file.
+func TestLoadTokens(t *testing.T) {
+	tokens, err := loadTokens(strings.NewReader("# team\nalice " + token + "\n\nbob " + serverKey + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[token] != "alice" || tokens[serverKey] != "bob" {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+
+	for _, s := range []string{"alice", "alice short", "alice " + token + "\nbob " + token} {
+		if _, err := loadTokens(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Command meteocat is a command line client for the Meteocat API. It reads the API key from the
+// METEOCAT_API_KEY environment variable and prints the responses as a table, JSON or CSV.
+//
+// Usage:
+//
+//	meteocat stations [-estat ope -date 2023-03-12]
+//	meteocat station D5
+//	meteocat variables
+//	meteocat measure -var 32 -date 2023-03-12 [-station D5]
+//	meteocat last -var 32 [-station D5]
+//	meteocat metadata -station D5 [-var 32]
+//	meteocat plot -var 32 -date 2023-03-12 -station D5 [-sparkline]
+//	meteocat watch -stations D5,UG -vars 32,33 [-interval 10m] [-threshold 32>30]
+//
+// Every subcommand but watch accepts -o table|json|csv. The plot subcommand draws the readings of a day as a chart
+// and the watch subcommand polls the last measurements, one call per variable for all the stations, and redraws a
+// table until it is interrupted with Ctrl-C.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// command is a subcommand of the tool
+type command struct {
+	name  string
+	usage string
+	run   func(key string, fs *flag.FlagSet, args []string) (*result, error)
+}
+
+var commands = []command{
+	{"stations", "stations [-estat ope -date YYYY-MM-DD]", runStations},
+	{"station", "station <codi>", runStation},
+	{"variables", "variables", runVariables},
+	{"measure", "measure -var <codi> -date YYYY-MM-DD [-station <codi>]", runMeasure},
+	{"last", "last -var <codi> [-station <codi>]", runLast},
+	{"metadata", "metadata -station <codi> [-var <codi>]", runMetadata},
+	{"plot", "plot -var <codi> -date YYYY-MM-DD -station <codi> [-sparkline] [-width 72 -height 10]", runPlot},
+	{"watch", "watch -stations <codi,...> [-vars <codi,...>] [-interval 10m] [-threshold 32>30]", runWatch},
+}
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the subcommand given in args and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		format := fs.String("o", formatTable, "output format: table, json or csv")
+		fs.Usage = func() {
+			fmt.Fprintf(stderr, "usage: meteocat %s [-o table|json|csv]\n", c.usage)
+			fs.PrintDefaults()
+		}
+
+		r, err := c.run(os.Getenv("METEOCAT_API_KEY"), fs, args[1:])
+		if err == flag.ErrHelp {
+			return 0
+		}
+		if err == errUsage {
+			fs.Usage()
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "meteocat:", err)
+			return 1
+		}
+
+		if r == nil {
+			return 0
+		}
+		if err := r.write(stdout, *format); err != nil {
+			fmt.Fprintln(stderr, "meteocat:", err)
+			return 1
+		}
+		return 0
+	}
+
+	usage(stderr)
+	return 2
+}
+
+// usage prints the list of subcommands
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: meteocat <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\n", c.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "The API key is read from the METEOCAT_API_KEY environment variable.")
+}
+
+// parse parses the flags of a subcommand allowing them before and after the positional arguments. The output
+// format is validated here, so a command fails before calling the API.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			if o := fs.Lookup("o"); o != nil && !validFormat(o.Value.String()) {
+				return nil, fmt.Errorf("unknown output format %q", o.Value.String())
+			}
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parseData converts a YYYY-MM-DD date into a meteocat.Data
+func parseData(s string) (meteocat.Data, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 3 || len(parts[0]) != 4 || len(parts[1]) != 2 || len(parts[2]) != 2 {
+		return meteocat.Data{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+	}
+	return meteocat.Data{Any: parts[0], Mes: parts[1], Dia: parts[2]}, nil
+}
+
+// formatFloat formats a float without trailing zeros
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+var stationHeader = []string{"codi", "nom", "municipi", "comarca", "provincia", "altitud", "latitud", "longitud"}
+
+// stationRow returns the table row of a station
+func stationRow(e meteocat.MetadadesEstacions) []string {
+	return []string{
+		e.Codi,
+		e.Nom,
+		e.Municipi.Nom,
+		e.Comarca.Nom,
+		e.Provincia.Nom,
+		formatFloat(e.Altitud),
+		formatFloat(e.Coordenades.Latitud),
+		formatFloat(e.Coordenades.Longitud),
+	}
+}
+
+func runStations(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	estat := fs.String("estat", "", "filter by station state: ope, des or bte (requires -date)")
+	date := fs.String("date", "", "date of the state filter, YYYY-MM-DD")
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 || (*estat == "") != (*date == "") {
+		return nil, errUsage
+	}
+
+	options := []func(*meteocat.Parameters) error{}
+	if *estat != "" {
+		d, err := parseData(*date)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, meteocat.OptionCodiEstat(*estat), meteocat.OptionData(d))
+	}
+	p, err := meteocat.NewParameters(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := meteocat.NewEstacions(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.StationsAll(p); err != nil {
+		return nil, err
+	}
+
+	r := &result{value: e.MetadadesTotesEstacions, header: stationHeader}
+	for _, st := range e.MetadadesTotesEstacions {
+		r.rows = append(r.rows, stationRow(st))
+	}
+	return r, nil
+}
+
+func runStation(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	pos, err := parse(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(pos) != 1 {
+		return nil, errUsage
+	}
+
+	p, err := meteocat.NewParameters(meteocat.OptionCodiEstacio(pos[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := meteocat.NewEstacions(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.StationByCode(p); err != nil {
+		return nil, err
+	}
+
+	return &result{
+		value:  e.MetadadesEstacions,
+		header: append(stationHeader, "emplacament"),
+		rows:   [][]string{append(stationRow(e.MetadadesEstacions), e.Emplacament)},
+	}, nil
+}
+
+var variableHeader = []string{"codi", "nom", "unitats", "acronim", "tipus", "decimals"}
+
+// variableRow returns the table row of a variable
+func variableRow(v meteocat.MetadadesVariable) []string {
+	return []string{strconv.Itoa(v.Codi), v.Nom, v.Unitats, v.Acronim, v.Tipus, strconv.Itoa(v.Decimals)}
+}
+
+func runVariables(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 {
+		return nil, errUsage
+	}
+
+	m, err := meteocat.NewMesurades(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.MeasurementMetadataAll(); err != nil {
+		return nil, err
+	}
+
+	r := &result{value: m.MetadadesVariables, header: variableHeader}
+	for _, v := range m.MetadadesVariables {
+		r.rows = append(r.rows, variableRow(v.MetadadesVariable))
+	}
+	return r, nil
+}
+
+var measurementHeader = []string{"estacio", "variable", "data", "valor", "estat", "baseHoraria"}
+
+// measurementResult returns the readings fetched by m as a result. The readings of a single station are held in
+// the Variable field whereas the readings of all stations are held in the Measurements field.
+func measurementResult(m *meteocat.Mesurades, station string) *result {
+	r := &result{header: measurementHeader}
+
+	if station != "" {
+		r.value = m.Variable
+		for _, l := range m.Variable.Lectures {
+			r.rows = append(r.rows, []string{m.CodiEstacio, strconv.Itoa(m.Variable.Codi), l.Data, formatFloat(l.Valor), l.Estat, l.BaseHoraria})
+		}
+		return r
+	}
+
+	r.value = m.Measurements
+	for _, st := range m.Measurements {
+		for _, v := range st.Variables {
+			for _, l := range v.Lectures {
+				r.rows = append(r.rows, []string{st.Codi, strconv.Itoa(v.Codi), l.Data, formatFloat(l.Valor), l.Estat, l.BaseHoraria})
+			}
+		}
+	}
+	return r
+}
+
+func runMeasure(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	variable := fs.String("var", "", "variable code, e.g 32")
+	date := fs.String("date", "", "date of the measurements, YYYY-MM-DD")
+	station := fs.String("station", "", "station code, e.g D5 (optional)")
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 || *variable == "" || *date == "" {
+		return nil, errUsage
+	}
+
+	d, err := parseData(*date)
+	if err != nil {
+		return nil, err
+	}
+	p, err := meteocat.NewParameters(
+		meteocat.OptionCodiVariable(*variable),
+		meteocat.OptionCodiEstacio(*station),
+		meteocat.OptionData(d),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := meteocat.NewMesurades(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.MeasurementByDay(p); err != nil {
+		return nil, err
+	}
+
+	return measurementResult(m, *station), nil
+}
+
+func runLast(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	variable := fs.String("var", "", "variable code, e.g 32")
+	station := fs.String("station", "", "station code, e.g D5 (optional)")
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 || *variable == "" {
+		return nil, errUsage
+	}
+
+	p, err := meteocat.NewParameters(
+		meteocat.OptionCodiVariable(*variable),
+		meteocat.OptionCodiEstacio(*station),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := meteocat.NewMesurades(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.MeasurementLast(p); err != nil {
+		return nil, err
+	}
+
+	return measurementResult(m, *station), nil
+}
+
+func runMetadata(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	station := fs.String("station", "", "station code, e.g D5")
+	variable := fs.String("var", "", "variable code, e.g 32 (optional)")
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 || *station == "" {
+		return nil, errUsage
+	}
+
+	p, err := meteocat.NewParameters(
+		meteocat.OptionCodiEstacio(strings.ToUpper(*station)),
+		meteocat.OptionCodiVariable(*variable),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := meteocat.NewMesurades(key)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &result{header: variableHeader}
+	if *variable != "" {
+		if err := m.MeasurementMetadataByStation(p); err != nil {
+			return nil, err
+		}
+		r.value = m.MetadadesVariableEstacio
+		r.rows = append(r.rows, variableRow(m.MetadadesVariableEstacio.MetadadesVariable))
+		return r, nil
+	}
+
+	if err := m.MeasurementMetadataAllByStation(p); err != nil {
+		return nil, err
+	}
+	r.value = m.MetadadesVariablesEstacio
+	for _, v := range m.MetadadesVariablesEstacio {
+		r.rows = append(r.rows, variableRow(v.MetadadesVariable))
+	}
+	return r, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// Output formats supported by the command line tool
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// result holds the outcome of a command: the raw API value used for the JSON output and its tabular representation
+type result struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+}
+
+// validFormat makes sure the string passed in is a supported output format
+func validFormat(f string) bool {
+	return f == formatTable || f == formatJSON || f == formatCSV
+}
+
+// write prints the result to w in the given format
+func (r *result) write(w io.Writer, format string) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r.value)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(r.header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(r.rows); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return r.writeTable(w)
+	}
+}
+
+// writeTable prints the result as an aligned table with a colored header
+func (r *result) writeTable(w io.Writer) error {
+	widths := make([]int, len(r.header))
+	for i, h := range r.header {
+		widths[i] = visibleLen(h)
+	}
+	for _, row := range r.rows {
+		for i, c := range row {
+			if n := visibleLen(c); i < len(widths) && n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	header := color.New(color.FgCyan, color.Bold)
+	cells := make([]string, len(r.header))
+	for i, h := range r.header {
+		cells[i] = header.Sprint(pad(h, widths[i]))
+	}
+	if _, err := fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, "  "), " ")); err != nil {
+		return err
+	}
+
+	for _, row := range r.rows {
+		cells = cells[:0]
+		for i, c := range row {
+			if i < len(widths) {
+				c = pad(c, widths[i])
+			}
+			cells = append(cells, c)
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, "  "), " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visibleLen returns the number of runes of s shown on a terminal, ignoring the ANSI color sequences
+func visibleLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			if j := strings.IndexByte(s[i:], 'm'); j >= 0 {
+				i += j + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// pad fills s with spaces up to width visible runes
+func pad(s string, width int) string {
+	if n := visibleLen(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+var testResult = &result{
+	value:  []map[string]string{{"codi": "D5"}},
+	header: []string{"codi", "nom"},
+	rows: [][]string{
+		{"D5", "Barcelona - Observatori Fabra"},
+		{"VS", "Lac Redon (2.247 m)"},
+	},
+}
+
+// TestWriteTable tests that the table columns are aligned.
+func TestWriteTable(t *testing.T) {
+	color.NoColor = true
+
+	var b bytes.Buffer
+	if err := testResult.write(&b, formatTable); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "codi  nom\nD5    Barcelona - Observatori Fabra\nVS    Lac Redon (2.247 m)\n"
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+// TestWriteCSV tests the CSV output.
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := testResult.write(&b, formatCSV); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "codi,nom\nD5,Barcelona - Observatori Fabra\nVS,Lac Redon (2.247 m)\n"
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+// TestWriteJSON tests that the JSON output holds the raw value.
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := testResult.write(&b, formatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[\n  {\n    \"codi\": \"D5\"\n  }\n]\n"
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+// TestParseData tests the conversion of YYYY-MM-DD dates.
+func TestParseData(t *testing.T) {
+	d, err := parseData("2023-03-12")
+	if err != nil || d.Any != "2023" || d.Mes != "03" || d.Dia != "12" {
+		t.Errorf("unexpected data %+v, %v", d, err)
+	}
+	if _, err := parseData("12/03/2023"); err == nil {
+		t.Error("expected an error on invalid date")
+	}
+}
+
+// TestRunInvalidFormat tests that an unknown output format is rejected before calling the API.
+func TestRunInvalidFormat(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"stations", "-o", "xml"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), `unknown output format "xml"`) {
+		t.Errorf("unexpected error %q", stderr.String())
+	}
+}
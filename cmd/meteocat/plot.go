@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/render"
+)
+
+func runPlot(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	variable := fs.String("var", "", "variable code, e.g 32")
+	date := fs.String("date", "", "date of the measurements, YYYY-MM-DD")
+	station := fs.String("station", "", "station code, e.g D5")
+	sparkline := fs.Bool("sparkline", false, "draw a sparkline instead of a chart")
+	width := fs.Int("width", 72, "width of the chart in columns")
+	height := fs.Int("height", 10, "height of the chart in rows")
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 || *variable == "" || *date == "" || *station == "" {
+		return nil, errUsage
+	}
+
+	d, err := parseData(*date)
+	if err != nil {
+		return nil, err
+	}
+	p, err := meteocat.NewParameters(
+		meteocat.OptionCodiVariable(*variable),
+		meteocat.OptionCodiEstacio(*station),
+		meteocat.OptionData(d),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := meteocat.NewMesurades(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.MeasurementByDay(p); err != nil {
+		return nil, err
+	}
+
+	if *sparkline {
+		fmt.Fprintln(os.Stdout, render.Sparkline(m.Variable))
+		return nil, nil
+	}
+
+	// The units and decimals of the y axis come from the variable metadata.
+	if err := m.MeasurementMetadataUnique(p); err != nil {
+		return nil, err
+	}
+	o := render.OptionsFor(m.MetadadesVariable)
+	o.Width, o.Height = *width, *height
+
+	chart, err := render.Chart(m.Variable, o)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stdout, "%s %s - %s (%s)\n\n", m.CodiEstacio, meteocat.CodisEstacions[m.CodiEstacio], m.MetadadesVariable.Nom, *date)
+	fmt.Fprint(os.Stdout, chart)
+	return nil, nil
+}
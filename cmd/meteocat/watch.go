@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/oscaromeu/meteocat"
+)
+
+// minWatchInterval is the shortest polling interval allowed. Stations report every 30 minutes and the last
+// measurements are cached by the API, so polling faster only burns quota.
+const minWatchInterval = time.Minute
+
+// threshold highlights the readings of a variable above or below a value, e.g 32>30 or 32<0
+type threshold struct {
+	variable string
+	above    bool
+	value    float64
+}
+
+// exceeded reports whether v crosses the threshold
+func (t threshold) exceeded(v float64) bool {
+	if t.above {
+		return v > t.value
+	}
+	return v < t.value
+}
+
+// thresholds implements flag.Value to collect repeated -threshold flags
+type thresholds []threshold
+
+// String implements the flag.Value interface
+func (ts *thresholds) String() string {
+	s := make([]string, len(*ts))
+	for i, t := range *ts {
+		op := "<"
+		if t.above {
+			op = ">"
+		}
+		s[i] = t.variable + op + formatFloat(t.value)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set implements the flag.Value interface
+func (ts *thresholds) Set(s string) error {
+	i := strings.IndexAny(s, "<>")
+	if i <= 0 {
+		return fmt.Errorf("invalid threshold %q, expected <var>><value> or <var><<value>", s)
+	}
+	v, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil {
+		return fmt.Errorf("invalid threshold %q: %v", s, err)
+	}
+	*ts = append(*ts, threshold{variable: s[:i], above: s[i] == '>', value: v})
+	return nil
+}
+
+// splitList splits a comma separated list dropping the empty items
+func splitList(s string) []string {
+	var r []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			r = append(r, item)
+		}
+	}
+	return r
+}
+
+// watchReading holds the last reading of a variable at a station
+type watchReading struct {
+	lectura  meteocat.Lectura
+	previous *float64
+	err      error
+}
+
+// watcher polls the last measurements of a set of stations and variables and redraws them as a table
+type watcher struct {
+	m          *meteocat.Mesurades
+	stations   []string
+	variables  []string
+	thresholds thresholds
+	spacing    time.Duration // Delay between two API calls
+	out        io.Writer
+	readings   map[string]*watchReading
+}
+
+// key returns the readings map key of a station and variable
+func (w *watcher) key(station, variable string) string {
+	return station + "/" + variable
+}
+
+// poll fetches the last readings of every variable at all the stations, one API call per variable, and keeps those
+// of the watched stations. API calls are spaced to respect the rate limits.
+func (w *watcher) poll(ctx context.Context) error {
+	for i, v := range w.variables {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.spacing):
+			}
+		}
+
+		p, err := meteocat.NewParameters(meteocat.OptionCodiVariable(v))
+		if err != nil {
+			return err
+		}
+		w.m.Measurements = nil
+		err = w.m.MeasurementLast(p)
+		w.update(v, w.m.Measurements, err)
+	}
+	return nil
+}
+
+// update records the last readings of a variable at the watched stations, or the error of the call
+func (w *watcher) update(v string, ms meteocat.Measurements, err error) {
+	lectures := make(map[string][]meteocat.Lectura)
+	for _, st := range ms {
+		for _, mv := range st.Variables {
+			if strconv.Itoa(mv.Codi) == v {
+				lectures[st.Codi] = mv.Lectures
+			}
+		}
+	}
+
+	for _, st := range w.stations {
+		r, ok := w.readings[w.key(st, v)]
+		if !ok {
+			r = &watchReading{}
+			w.readings[w.key(st, v)] = r
+		}
+		if r.err = err; err != nil {
+			continue
+		}
+
+		ls := lectures[st]
+		if n := len(ls); n > 0 {
+			last := ls[n-1]
+			switch {
+			case n > 1:
+				prev := ls[n-2].Valor
+				r.previous = &prev
+			case r.lectura.Data != "" && r.lectura.Data != last.Data:
+				prev := r.lectura.Valor
+				r.previous = &prev
+			}
+			r.lectura = last
+		}
+	}
+}
+
+// trend returns an arrow showing the evolution of the reading
+func trend(r *watchReading) string {
+	switch {
+	case r.previous == nil:
+		return " "
+	case r.lectura.Valor > *r.previous:
+		return "↑"
+	case r.lectura.Valor < *r.previous:
+		return "↓"
+	}
+	return "→"
+}
+
+// draw clears the terminal and prints the readings table
+func (w *watcher) draw(now time.Time) {
+	fmt.Fprint(w.out, "\033[H\033[2J")
+
+	r := &result{header: []string{"estacio", "nom", "variable", "valor", "", "data", "estat"}}
+	alert := make(map[int]bool)
+	for _, st := range w.stations {
+		for _, v := range w.variables {
+			rd := w.readings[w.key(st, v)]
+			row := []string{st, meteocat.CodisEstacions[st], meteocat.CodisVariables[v], "-", " ", "-", ""}
+			switch {
+			case rd == nil:
+			case rd.err != nil:
+				row[3] = "error"
+				row[6] = rd.err.Error()
+			case rd.lectura.Data != "":
+				row[3] = formatFloat(rd.lectura.Valor)
+				row[4] = trend(rd)
+				row[5] = rd.lectura.Data
+				row[6] = rd.lectura.Estat
+				for _, t := range w.thresholds {
+					if t.variable == v && t.exceeded(rd.lectura.Valor) {
+						alert[len(r.rows)] = true
+					}
+				}
+			}
+			r.rows = append(r.rows, row)
+		}
+	}
+
+	high := color.New(color.FgRed, color.Bold)
+	for i := range r.rows {
+		if alert[i] {
+			r.rows[i][3] = high.Sprint(r.rows[i][3])
+		}
+	}
+
+	r.writeTable(w.out)
+	fmt.Fprintf(w.out, "\nUpdated %s. Press Ctrl-C to exit.\n", now.Format("15:04:05"))
+}
+
+// run polls and redraws every interval until the context is cancelled
+func (w *watcher) run(ctx context.Context, interval time.Duration) error {
+	backoff := interval
+	for {
+		err := w.poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.draw(time.Now())
+
+		// Back off while every call fails, which usually means the quota has been exhausted.
+		failed := len(w.readings) > 0
+		for _, r := range w.readings {
+			if r.err == nil {
+				failed = false
+			}
+		}
+		if failed && backoff < 8*interval {
+			backoff *= 2
+		} else if !failed {
+			backoff = interval
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func runWatch(key string, fs *flag.FlagSet, args []string) (*result, error) {
+	stations := fs.String("stations", "", "comma separated station codes, e.g D5,UG")
+	variables := fs.String("vars", "32", "comma separated variable codes")
+	interval := fs.Duration("interval", 10*time.Minute, "polling interval")
+	spacing := fs.Duration("spacing", time.Second, "delay between the API calls of each variable")
+	var ts thresholds
+	fs.Var(&ts, "threshold", "highlight values over a threshold, e.g 32>30 or 32<0 (repeatable)")
+	if pos, err := parse(fs, args); err != nil {
+		return nil, err
+	} else if len(pos) != 0 || *stations == "" {
+		return nil, errUsage
+	}
+	var output bool
+	fs.Visit(func(f *flag.Flag) { output = output || f.Name == "o" })
+	if output {
+		return nil, errors.New("the watch subcommand draws a table and does not support -o")
+	}
+
+	if *interval < minWatchInterval {
+		return nil, fmt.Errorf("interval must be at least %v", minWatchInterval)
+	}
+
+	w := &watcher{
+		stations:   splitList(strings.ToUpper(*stations)),
+		variables:  splitList(*variables),
+		thresholds: ts,
+		spacing:    *spacing,
+		out:        os.Stdout,
+		readings:   make(map[string]*watchReading),
+	}
+	for _, st := range w.stations {
+		if !meteocat.ValidCodiEstacio(st) {
+			return nil, fmt.Errorf("unknown station %q", st)
+		}
+	}
+	for _, v := range w.variables {
+		if !meteocat.ValidCodiVariable(v) {
+			return nil, fmt.Errorf("unknown variable %q", v)
+		}
+	}
+	sort.Strings(w.stations)
+
+	m, err := meteocat.NewMesurades(key)
+	if err != nil {
+		return nil, err
+	}
+	w.m = m
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return nil, w.run(ctx, *interval)
+}
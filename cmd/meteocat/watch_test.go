@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// TestThresholds tests the parsing of the -threshold flags.
+func TestThresholds(t *testing.T) {
+	var ts thresholds
+	for _, s := range []string{"32>30", "32<-2.5"} {
+		if err := ts.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ts.String() != "32>30,32<-2.5" {
+		t.Errorf("unexpected thresholds %s", ts.String())
+	}
+	if !ts[0].exceeded(31) || ts[0].exceeded(30) || !ts[1].exceeded(-3) || ts[1].exceeded(0) {
+		t.Errorf("unexpected threshold evaluation: %+v", ts)
+	}
+
+	for _, s := range []string{"32", ">30", "32>hot"} {
+		if err := ts.Set(s); err == nil {
+			t.Errorf("expected an error on %q", s)
+		}
+	}
+}
+
+// TestTrend tests the trend arrows.
+func TestTrend(t *testing.T) {
+	prev := 10.0
+	r := &watchReading{}
+	r.lectura.Valor = 12
+	if trend(r) != " " {
+		t.Error("expected no arrow without previous reading")
+	}
+
+	r.previous = &prev
+	for v, want := range map[float64]string{12: "↑", 8: "↓", 10: "→"} {
+		r.lectura.Valor = v
+		if got := trend(r); got != want {
+			t.Errorf("trend(%v): expected %s, got %s", v, want, got)
+		}
+	}
+}
+
+// TestVisibleLen tests that color sequences do not count towards the column widths.
+func TestVisibleLen(t *testing.T) {
+	if n := visibleLen("\033[31;1m31.5\033[0m"); n != 4 {
+		t.Errorf("expected 4, got %d", n)
+	}
+	if n := visibleLen("Orís"); n != 4 {
+		t.Errorf("expected 4, got %d", n)
+	}
+}
+
+// TestWatcherUpdate tests that the readings of the watched stations are picked from the response of all the stations.
+func TestWatcherUpdate(t *testing.T) {
+	w := &watcher{stations: []string{"D5", "UG"}, variables: []string{"32"}, readings: make(map[string]*watchReading)}
+	ms := meteocat.Measurements{
+		{Codi: "CC", Variables: []meteocat.Variable{{Codi: 32, Lectures: []meteocat.Lectura{{Data: "2023-03-12T10:00Z", Valor: 5}}}}},
+		{Codi: "D5", Variables: []meteocat.Variable{{Codi: 32, Lectures: []meteocat.Lectura{
+			{Data: "2023-03-12T09:30Z", Valor: 12},
+			{Data: "2023-03-12T10:00Z", Valor: 13.5},
+		}}}},
+	}
+
+	w.update("32", ms, nil)
+	if r := w.readings["D5/32"]; r.err != nil || r.lectura.Valor != 13.5 || trend(r) != "↑" {
+		t.Errorf("unexpected D5 reading %+v", r)
+	}
+	if r := w.readings["UG/32"]; r.err != nil || r.lectura.Data != "" {
+		t.Errorf("unexpected UG reading %+v", r)
+	}
+	if _, ok := w.readings["CC/32"]; ok {
+		t.Error("unexpected reading of a station which is not watched")
+	}
+
+	w.update("32", nil, errors.New("quota exceeded"))
+	if r := w.readings["D5/32"]; r.err == nil {
+		t.Errorf("expected the error of the call, got %+v", r)
+	}
+}
+
+// TestWatchOutput tests that the watch subcommand rejects the -o flag.
+func TestWatchOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"watch", "-stations", "D5", "-o", "json"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "does not support -o") {
+		t.Errorf("unexpected error %q", stderr.String())
+	}
+}
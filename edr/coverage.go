@@ -0,0 +1,164 @@
+package edr
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// CoverageJSON is the media type of the responses of the data queries
+const CoverageJSON = "application/prs.coverage+json"
+
+// crs84 is the identifier of the longitude, latitude reference system of the coordinates
+const crs84 = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+
+// langString is a text by language tag
+type langString map[string]string
+
+// unit is the unit of measurement of a parameter
+type unit struct {
+	Label  langString `json:"label"`
+	Symbol string     `json:"symbol"`
+}
+
+// observedProperty is the phenomenon measured by a parameter
+type observedProperty struct {
+	ID    string     `json:"id,omitempty"`
+	Label langString `json:"label"`
+}
+
+// parameter describes the values of a range
+type parameter struct {
+	Type             string           `json:"type"`
+	ID               string           `json:"id,omitempty"`
+	Description      langString       `json:"description,omitempty"`
+	Unit             unit             `json:"unit"`
+	ObservedProperty observedProperty `json:"observedProperty"`
+}
+
+// axis holds the values of a domain axis
+type axis struct {
+	Values []interface{} `json:"values"`
+}
+
+// referenceSystem is a coordinate or temporal reference system
+type referenceSystem struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"`
+	Calendar string `json:"calendar,omitempty"`
+}
+
+// referenceSystemConnection relates axes to their reference system
+type referenceSystemConnection struct {
+	Coordinates []string        `json:"coordinates"`
+	System      referenceSystem `json:"system"`
+}
+
+// domain is the point and times of a PointSeries coverage
+type domain struct {
+	Type        string                      `json:"type"`
+	DomainType  string                      `json:"domainType,omitempty"`
+	Axes        map[string]axis             `json:"axes"`
+	Referencing []referenceSystemConnection `json:"referencing,omitempty"`
+}
+
+// ndArray holds the values of a parameter along the time axis, null where there is no reading
+type ndArray struct {
+	Type      string        `json:"type"`
+	DataType  string        `json:"dataType"`
+	AxisNames []string      `json:"axisNames"`
+	Shape     []int         `json:"shape"`
+	Values    []interface{} `json:"values"`
+}
+
+// coverage is the series of readings of a station
+type coverage struct {
+	Type       string               `json:"type"`
+	ID         string               `json:"id,omitempty"`
+	Domain     domain               `json:"domain"`
+	Parameters map[string]parameter `json:"parameters,omitempty"`
+	Ranges     map[string]ndArray   `json:"ranges"`
+}
+
+// coverageCollection holds the coverages of several stations sharing their parameters and referencing
+type coverageCollection struct {
+	Type        string                      `json:"type"`
+	DomainType  string                      `json:"domainType"`
+	Parameters  map[string]parameter        `json:"parameters"`
+	Referencing []referenceSystemConnection `json:"referencing"`
+	Coverages   []coverage                  `json:"coverages"`
+}
+
+// referencing returns the reference systems of the x, y and t axes
+func referencing() []referenceSystemConnection {
+	return []referenceSystemConnection{
+		{Coordinates: []string{"x", "y"}, System: referenceSystem{Type: "GeographicCRS", ID: crs84}},
+		{Coordinates: []string{"t"}, System: referenceSystem{Type: "TemporalRS", Calendar: "Gregorian"}},
+	}
+}
+
+// newParameter returns the CoverageJSON parameter of a variable
+func newParameter(id string, v meteocat.MetadadesVariable) parameter {
+	return parameter{
+		Type:             "Parameter",
+		ID:               id,
+		Description:      langString{"ca": v.Nom},
+		Unit:             unit{Label: langString{"ca": v.Unitats}, Symbol: v.Unitats},
+		ObservedProperty: observedProperty{ID: "https://api.meteo.cat/xema/v1/variables/mesurades/" + strconv.Itoa(v.Codi), Label: langString{"ca": v.Nom}},
+	}
+}
+
+// newCoverage returns the PointSeries coverage of a station with the readings of its parameters by name. The time
+// axis is the union of the times of the readings.
+func newCoverage(st meteocat.MetadadesEstacions, readings map[string][]meteocat.Lectura) coverage {
+	values := make(map[string]map[time.Time]float64, len(readings))
+	seen := make(map[time.Time]bool)
+	var times []time.Time
+	for p, lectures := range readings {
+		values[p] = make(map[time.Time]float64, len(lectures))
+		for _, l := range lectures {
+			t, err := meteocat.ParseData(l.Data)
+			if err != nil {
+				continue
+			}
+			values[p][t] = l.Valor
+			if !seen[t] {
+				seen[t] = true
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	t := make([]interface{}, len(times))
+	for i, ts := range times {
+		t[i] = ts.UTC().Format(time.RFC3339)
+	}
+	ranges := make(map[string]ndArray, len(values))
+	for p, byTime := range values {
+		r := ndArray{Type: "NdArray", DataType: "float", AxisNames: []string{"t"}, Shape: []int{len(times)}, Values: make([]interface{}, len(times))}
+		for i, ts := range times {
+			if v, ok := byTime[ts]; ok {
+				r.Values[i] = v
+			}
+		}
+		ranges[p] = r
+	}
+
+	return coverage{
+		Type: "Coverage",
+		ID:   st.Codi,
+		Domain: domain{
+			Type:       "Domain",
+			DomainType: "PointSeries",
+			Axes: map[string]axis{
+				"x": {Values: []interface{}{st.Coordenades.Longitud}},
+				"y": {Values: []interface{}{st.Coordenades.Latitud}},
+				"t": {Values: t},
+			},
+		},
+		Ranges: ranges,
+	}
+}
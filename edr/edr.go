@@ -0,0 +1,653 @@
+// Package edr serves the XEMA through OGC API - Environmental Data Retrieval, so GIS tools can ask for the readings
+// at or around a place, e.g the temperature within 10 km of a point on a date:
+//
+//	/collections/xema/radius?coords=POINT(2.17 41.38)&within=10&within-units=km&parameter-name=T&datetime=2023-03-12
+//
+// The single collection, xema, supports the position, radius, area and locations queries. Stations are selected by
+// the Coordenades of the station catalogue: position returns the nearest station with readings, radius the stations
+// within a distance and area those inside a WKT polygon. Responses are CoverageJSON, one PointSeries coverage per
+// station with a range per variable.
+//
+// Readings are requested to the API for all the stations at once, one call per variable and day of the datetime
+// interval, or the last readings when there is no datetime. Pass meteocat.WithCache with WithClientOptions to share
+// the responses between queries.
+package edr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// Collection is the identifier of the collection of the XEMA stations
+const Collection = "xema"
+
+// maxDays is the longest datetime interval of a query
+const maxDays = 31
+
+var errInvalidOption = errors.New("invalid option")
+
+// units converts the within-units of a radius query to kilometers
+var units = map[string]float64{
+	"km": 1,
+	"m":  0.001,
+	"mi": 1.609344,
+}
+
+// queries are the data queries of the collection
+var queries = []string{"position", "radius", "area", "locations"}
+
+// Server serves the EDR API. It is safe for concurrent use.
+type Server struct {
+	key       string
+	options   []meteocat.Option
+	stations  []meteocat.MetadadesEstacions
+	byStation map[string]int
+	variables []meteocat.MetadadesVariable
+	names     map[int]string // Parameter names of the exposed variables by code
+	byName    map[string]int // Codes of the exposed variables by parameter name and code
+	codes     []int          // Codes of the exposed variables, all when empty
+	root      string         // Landing page URL, derived from the requests when empty
+	now       func() time.Time
+}
+
+// Option configures a Server
+type Option func(s *Server) error
+
+// WithClientOptions sets the options of the clients which request the readings, e.g meteocat.WithCache.
+func WithClientOptions(options ...meteocat.Option) Option {
+	return func(s *Server) error {
+		s.options = options
+		return nil
+	}
+}
+
+// WithVariables restricts the parameters to the variables of the codes, e.g 32, 33 and 35.
+func WithVariables(codes ...int) Option {
+	return func(s *Server) error {
+		if len(codes) == 0 {
+			return errInvalidOption
+		}
+		s.codes = codes
+		return nil
+	}
+}
+
+// WithServiceRoot sets the URL of the landing page used in the links, e.g https://example.org/edr behind a reverse
+// proxy. By default it is derived from the host of the requests.
+func WithServiceRoot(u string) Option {
+	return func(s *Server) error {
+		parsed, err := neturl.Parse(u)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return errInvalidOption
+		}
+		s.root = strings.TrimSuffix(u, "/")
+		return nil
+	}
+}
+
+// NewServer returns a new Server pointer over the stations and variables whose readings are requested with key.
+// Parameters are named by the acronym of their variable, or by its code when the acronym is not unique.
+func NewServer(key string, stations meteocat.MetadadesTotesEstacions, variables meteocat.MetadadesVariables, options ...Option) (*Server, error) {
+	s := &Server{
+		key:       key,
+		byStation: make(map[string]int),
+		names:     make(map[int]string),
+		byName:    make(map[string]int),
+		now:       time.Now,
+	}
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(s); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := meteocat.NewMesurades(key, s.options...); err != nil {
+		return nil, err
+	}
+
+	for _, st := range stations {
+		s.byStation[st.Codi] = len(s.stations)
+		s.stations = append(s.stations, st)
+	}
+
+	exposed := make(map[int]bool)
+	for _, c := range s.codes {
+		exposed[c] = true
+	}
+	acronyms := make(map[string]int)
+	for _, v := range variables {
+		if len(exposed) == 0 || exposed[v.Codi] {
+			s.variables = append(s.variables, v.MetadadesVariable)
+			acronyms[v.Acronim]++
+		}
+	}
+	sort.Slice(s.variables, func(i, j int) bool { return s.variables[i].Codi < s.variables[j].Codi })
+	for _, v := range s.variables {
+		name := strconv.Itoa(v.Codi)
+		if v.Acronim != "" && acronyms[v.Acronim] == 1 {
+			name = v.Acronim
+		}
+		s.names[v.Codi] = name
+		s.byName[name] = v.Codi
+		s.byName[strconv.Itoa(v.Codi)] = v.Codi
+	}
+	return s, nil
+}
+
+// httpError is an error with the status of its response
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &httpError{status: http.StatusBadRequest, message: message}
+}
+
+func notFound(message string) error {
+	return &httpError{status: http.StatusNotFound, message: message}
+}
+
+// writeJSON writes v as the response with a content type
+func writeJSON(w http.ResponseWriter, status int, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the JSON exception of an error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*httpError); ok {
+		status = e.status
+	}
+	writeJSON(w, status, "application/json", map[string]interface{}{"code": strconv.Itoa(status), "description": err.Error()})
+}
+
+// serviceRoot returns the URL of the landing page for a request
+func (s *Server) serviceRoot(r *http.Request) string {
+	if s.root != "" {
+		return s.root
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// ServeHTTP implements the http.Handler interface. The landing page is the root path, mount the server with
+// http.StripPrefix to serve it under a prefix.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, &httpError{status: http.StatusMethodNotAllowed, message: "the API is read only"})
+		return
+	}
+	root := s.serviceRoot(r)
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	values := r.URL.Query()
+
+	var v interface{}
+	var err error
+	contentType := "application/json"
+	switch {
+	case len(segments) == 1 && segments[0] == "":
+		v = s.landing(root)
+	case len(segments) == 1 && segments[0] == "conformance":
+		v = map[string]interface{}{"conformsTo": []string{
+			"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/core",
+			"http://www.opengis.net/spec/ogcapi-common-2/1.0/conf/collections",
+			"http://www.opengis.net/spec/ogcapi-edr-1/1.0/conf/core",
+			"http://www.opengis.net/spec/ogcapi-edr-1/1.0/conf/covjson",
+			"http://www.opengis.net/spec/ogcapi-edr-1/1.0/conf/geojson",
+		}}
+	case len(segments) == 1 && segments[0] == "collections":
+		v = map[string]interface{}{
+			"links":       []interface{}{link(root+"/collections", "self", "application/json", "Collections")},
+			"collections": []interface{}{s.collection(root)},
+		}
+	case segments[0] != "collections" || segments[1] != Collection:
+		err = notFound("not found")
+	case len(segments) == 2:
+		v = s.collection(root)
+	case len(segments) == 3 && segments[2] == "position":
+		v, err = s.position(values)
+		contentType = CoverageJSON
+	case len(segments) == 3 && segments[2] == "radius":
+		v, err = s.radius(values)
+		contentType = CoverageJSON
+	case len(segments) == 3 && segments[2] == "area":
+		v, err = s.area(values)
+		contentType = CoverageJSON
+	case len(segments) == 3 && segments[2] == "locations":
+		v = s.locations(root)
+		contentType = "application/geo+json"
+	case len(segments) == 4 && segments[2] == "locations":
+		v, err = s.location(segments[3], values)
+		contentType = CoverageJSON
+	default:
+		err = notFound("not found")
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, contentType, v)
+}
+
+// link returns a link object
+func link(href, rel, typ, title string) map[string]interface{} {
+	l := map[string]interface{}{"href": href, "rel": rel, "type": typ}
+	if title != "" {
+		l["title"] = title
+	}
+	return l
+}
+
+// landing returns the landing page
+func (s *Server) landing(root string) interface{} {
+	return map[string]interface{}{
+		"title":       "XEMA",
+		"description": "Environmental data retrieval of the automatic weather stations of the Meteorological Service of Catalonia",
+		"links": []interface{}{
+			link(root+"/", "self", "application/json", "Landing page"),
+			link(root+"/conformance", "conformance", "application/json", "Conformance"),
+			link(root+"/collections", "data", "application/json", "Collections"),
+		},
+	}
+}
+
+// collection returns the metadata of the collection with its data queries and parameters
+func (s *Server) collection(root string) interface{} {
+	href := root + "/collections/" + Collection
+
+	bbox := []float64{180, 90, -180, -90}
+	for _, st := range s.stations {
+		bbox[0] = math.Min(bbox[0], st.Coordenades.Longitud)
+		bbox[1] = math.Min(bbox[1], st.Coordenades.Latitud)
+		bbox[2] = math.Max(bbox[2], st.Coordenades.Longitud)
+		bbox[3] = math.Max(bbox[3], st.Coordenades.Latitud)
+	}
+
+	dataQueries := make(map[string]interface{}, len(queries))
+	for _, q := range queries {
+		variables := map[string]interface{}{
+			"query_type":            q,
+			"output_formats":        []string{"CoverageJSON"},
+			"default_output_format": "CoverageJSON",
+			"crs_details":           []interface{}{map[string]string{"crs": "CRS84", "wkt": crs84}},
+		}
+		if q == "radius" {
+			variables["within_units"] = []string{"km", "m", "mi"}
+		}
+		l := link(href+"/"+q, "data", CoverageJSON, "")
+		l["variables"] = variables
+		dataQueries[q] = map[string]interface{}{"link": l}
+	}
+
+	parameters := make(map[string]parameter, len(s.variables))
+	for _, v := range s.variables {
+		parameters[s.names[v.Codi]] = newParameter(s.names[v.Codi], v)
+	}
+
+	return map[string]interface{}{
+		"id":          Collection,
+		"title":       "XEMA",
+		"description": "Readings of the Network of Automatic Meteorological Stations of Catalonia",
+		"links":       []interface{}{link(href, "self", "application/json", "")},
+		"extent": map[string]interface{}{
+			"spatial": map[string]interface{}{"bbox": [][]float64{bbox}, "crs": crs84},
+		},
+		"data_queries":    dataQueries,
+		"crs":             []string{"CRS84"},
+		"output_formats":  []string{"CoverageJSON"},
+		"parameter_names": parameters,
+	}
+}
+
+// locations returns the stations as a GeoJSON feature collection whose ids are the station codes
+func (s *Server) locations(root string) interface{} {
+	features := make([]interface{}, 0, len(s.stations))
+	for _, st := range s.stations {
+		features = append(features, map[string]interface{}{
+			"type": "Feature",
+			"id":   st.Codi,
+			"geometry": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{st.Coordenades.Longitud, st.Coordenades.Latitud, st.Altitud},
+			},
+			"properties": map[string]interface{}{
+				"name":      st.Nom,
+				"municipi":  st.Municipi.Nom,
+				"comarca":   st.Comarca.Nom,
+				"provincia": st.Provincia.Nom,
+				"estat":     st.EstatActual(),
+				"detail":    root + "/collections/" + Collection + "/locations/" + st.Codi,
+			},
+		})
+	}
+	return map[string]interface{}{"type": "FeatureCollection", "features": features}
+}
+
+// interval is the datetime interval [from, to) of a query, the last readings when zero
+type interval struct {
+	from, to time.Time
+}
+
+// parseTime parses an RFC 3339 date-time or a date, reporting whether it was a date
+func parseTime(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), false, nil
+	}
+	if t, err := meteocat.ParseData(s); err == nil {
+		return t.UTC(), false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid datetime %q", s)
+}
+
+// parseDatetime parses the datetime parameter: an instant, a date for the whole day, or a closed interval of
+// instants or dates, e.g 2023-03-12T10:00:00Z/2023-03-12T12:00:00Z. The end date of an interval is included.
+func parseDatetime(s string) (interval, error) {
+	if s == "" {
+		return interval{}, nil
+	}
+
+	var iv interval
+	if parts := strings.Split(s, "/"); len(parts) == 2 {
+		if parts[0] == ".." || parts[1] == ".." || parts[0] == "" || parts[1] == "" {
+			return interval{}, badRequest("open datetime intervals are not supported")
+		}
+		from, _, err := parseTime(parts[0])
+		if err != nil {
+			return interval{}, badRequest(err.Error())
+		}
+		to, date, err := parseTime(parts[1])
+		if err != nil {
+			return interval{}, badRequest(err.Error())
+		}
+		if date {
+			to = to.AddDate(0, 0, 1)
+		} else {
+			to = to.Add(time.Second)
+		}
+		iv = interval{from, to}
+	} else {
+		t, date, err := parseTime(s)
+		if err != nil {
+			return interval{}, badRequest(err.Error())
+		}
+		iv = interval{t, t.Add(time.Second)}
+		if date {
+			iv.to = t.AddDate(0, 0, 1)
+		}
+	}
+
+	if !iv.from.Before(iv.to) {
+		return interval{}, badRequest("the datetime interval ends before its start")
+	}
+	if iv.to.Sub(iv.from.Truncate(24*time.Hour)) > maxDays*24*time.Hour {
+		return interval{}, badRequest(fmt.Sprintf("the datetime interval is limited to %d days", maxDays))
+	}
+	return iv, nil
+}
+
+// parseParameters returns the codes of the variables of the parameter-name parameter, all the exposed ones when
+// empty
+func (s *Server) parseParameters(v string) ([]int, error) {
+	var codes []int
+	if v == "" {
+		for _, variable := range s.variables {
+			codes = append(codes, variable.Codi)
+		}
+		return codes, nil
+	}
+	for _, name := range strings.Split(v, ",") {
+		codi, ok := s.byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, badRequest(fmt.Sprintf("unknown parameter %q", name))
+		}
+		codes = append(codes, codi)
+	}
+	return codes, nil
+}
+
+// request is a parsed data query
+type request struct {
+	interval
+	codes []int
+}
+
+// parseRequest parses the datetime and parameter-name parameters of a data query
+func (s *Server) parseRequest(values neturl.Values) (*request, error) {
+	iv, err := parseDatetime(values.Get("datetime"))
+	if err != nil {
+		return nil, err
+	}
+	codes, err := s.parseParameters(values.Get("parameter-name"))
+	if err != nil {
+		return nil, err
+	}
+	return &request{interval: iv, codes: codes}, nil
+}
+
+// readings returns the readings of the query by station code and parameter name, one API call per variable and
+// day
+func (s *Server) readings(req *request) (map[string]map[string][]meteocat.Lectura, error) {
+	byStation := make(map[string]map[string][]meteocat.Lectura)
+	add := func(codi int, m meteocat.Measurements) {
+		for _, st := range m {
+			for _, v := range st.Variables {
+				if v.Codi != codi {
+					continue
+				}
+				for _, l := range v.Lectures {
+					if !req.from.IsZero() {
+						t, err := meteocat.ParseData(l.Data)
+						if err != nil || t.Before(req.from) || !t.Before(req.to) {
+							continue
+						}
+					}
+					if byStation[st.Codi] == nil {
+						byStation[st.Codi] = make(map[string][]meteocat.Lectura)
+					}
+					byStation[st.Codi][s.names[codi]] = append(byStation[st.Codi][s.names[codi]], l)
+				}
+			}
+		}
+	}
+
+	for _, codi := range req.codes {
+		if req.from.IsZero() {
+			m, err := s.fetch(codi, nil)
+			if err != nil {
+				return nil, err
+			}
+			add(codi, m)
+			continue
+		}
+		for day := req.from.Truncate(24 * time.Hour); day.Before(req.to) && !day.After(s.now()); day = day.AddDate(0, 0, 1) {
+			m, err := s.fetch(codi, &meteocat.Data{Any: day.Format("2006"), Mes: day.Format("01"), Dia: day.Format("02")})
+			if err != nil {
+				return nil, err
+			}
+			add(codi, m)
+		}
+	}
+	return byStation, nil
+}
+
+// fetch requests the readings of a variable at all the stations on a day, the last ones when data is nil
+func (s *Server) fetch(codi int, data *meteocat.Data) (meteocat.Measurements, error) {
+	m, err := meteocat.NewMesurades(s.key, s.options...)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		p, _ := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)))
+		err = m.MeasurementLast(p)
+	} else {
+		p, _ := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)), meteocat.OptionData(*data))
+		err = m.MeasurementByDay(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.Measurements, nil
+}
+
+// collect returns the coverages of the stations with readings, in the order of the stations
+func (s *Server) collect(stations []meteocat.MetadadesEstacions, req *request) (*coverageCollection, error) {
+	readings, err := s.readings(req)
+	if err != nil {
+		return nil, err
+	}
+	c := &coverageCollection{
+		Type:        "CoverageCollection",
+		DomainType:  "PointSeries",
+		Parameters:  make(map[string]parameter, len(req.codes)),
+		Referencing: referencing(),
+		Coverages:   []coverage{},
+	}
+	for _, codi := range req.codes {
+		for _, v := range s.variables {
+			if v.Codi == codi {
+				c.Parameters[s.names[codi]] = newParameter(s.names[codi], v)
+			}
+		}
+	}
+	for _, st := range stations {
+		if r, ok := readings[st.Codi]; ok {
+			c.Coverages = append(c.Coverages, newCoverage(st, r))
+		}
+	}
+	return c, nil
+}
+
+// single returns the first coverage of a collection as a standalone coverage
+func single(c *coverageCollection) coverage {
+	cov := c.Coverages[0]
+	cov.Parameters = c.Parameters
+	cov.Domain.Referencing = c.Referencing
+	return cov
+}
+
+// position returns the coverage of the nearest station with readings to the coords point
+func (s *Server) position(values neturl.Values) (interface{}, error) {
+	point, err := parsePoint(values.Get("coords"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	req, err := s.parseRequest(values)
+	if err != nil {
+		return nil, err
+	}
+
+	stations := make([]meteocat.MetadadesEstacions, len(s.stations))
+	copy(stations, s.stations)
+	sort.SliceStable(stations, func(i, j int) bool {
+		return stations[i].Coordenades.Distance(point) < stations[j].Coordenades.Distance(point)
+	})
+	c, err := s.collect(stations, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Coverages) == 0 {
+		return nil, notFound("no readings for the query")
+	}
+	return single(c), nil
+}
+
+// radius returns the coverages of the stations within a distance of the coords point, nearest first
+func (s *Server) radius(values neturl.Values) (interface{}, error) {
+	point, err := parsePoint(values.Get("coords"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	within, err := strconv.ParseFloat(values.Get("within"), 64)
+	if err != nil || within <= 0 {
+		return nil, badRequest("within must be a positive distance")
+	}
+	u := values.Get("within-units")
+	if u == "" {
+		u = "km"
+	}
+	factor, ok := units[strings.ToLower(u)]
+	if !ok {
+		return nil, badRequest(fmt.Sprintf("unsupported within-units %q, expected km, m or mi", u))
+	}
+	req, err := s.parseRequest(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var stations []meteocat.MetadadesEstacions
+	for _, st := range s.stations {
+		if st.Coordenades.Distance(point) <= within*factor {
+			stations = append(stations, st)
+		}
+	}
+	sort.SliceStable(stations, func(i, j int) bool {
+		return stations[i].Coordenades.Distance(point) < stations[j].Coordenades.Distance(point)
+	})
+	return s.collect(stations, req)
+}
+
+// area returns the coverages of the stations inside the coords polygon
+func (s *Server) area(values neturl.Values) (interface{}, error) {
+	p, err := parsePolygon(values.Get("coords"))
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	req, err := s.parseRequest(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var stations []meteocat.MetadadesEstacions
+	for _, st := range s.stations {
+		if p.contains(st.Coordenades) {
+			stations = append(stations, st)
+		}
+	}
+	return s.collect(stations, req)
+}
+
+// location returns the coverage of the station of a code
+func (s *Server) location(codi string, values neturl.Values) (interface{}, error) {
+	i, ok := s.byStation[strings.ToUpper(codi)]
+	if !ok {
+		return nil, notFound(fmt.Sprintf("unknown location %q", codi))
+	}
+	req, err := s.parseRequest(values)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.collect([]meteocat.MetadadesEstacions{s.stations[i]}, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Coverages) == 0 {
+		return nil, notFound("no readings for the query")
+	}
+	return single(c), nil
+}
@@ -0,0 +1,362 @@
+package edr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+)
+
+// d5 is the position of the Barcelona - Observatori Fabra station
+var d5 = meteocat.Coordenades{Latitud: 41.41864, Longitud: 2.12379}
+
+// newTestServer returns a server over the catalogue fixtures whose readings of temperature are served by a test
+// API server, and the count of the requests to it by path
+func newTestServer(t *testing.T) (*Server, *httptest.Server, map[string]int) {
+	t.Helper()
+
+	var stations meteocat.MetadadesTotesEstacions
+	var variables meteocat.MetadadesVariables
+	testutil.Load(t, "metadades_totes_estacions.json", &stations)
+	testutil.Load(t, "variables_mesurades_metadades.json", &variables)
+
+	body, err := ioutil.ReadFile("../testdata/mesurades_dia_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := make(map[string]int)
+	var mu sync.Mutex
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/xema/v1/variables/mesurades/32/2023/03/12", "/xema/v1/variables/mesurades/32/ultimes":
+			w.Write(body)
+		default:
+			w.Write([]byte("[]"))
+		}
+	}))
+
+	s, err := NewServer("", stations, variables, WithClientOptions(meteocat.WithBaseURL(api.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Date(2023, 3, 13, 12, 0, 0, 0, time.UTC) }
+	return s, api, hits
+}
+
+// get sends a request to the server and decodes its JSON response
+func get(t *testing.T, s *Server, path string, query url.Values) (int, string, map[string]interface{}) {
+	t.Helper()
+
+	target := path
+	if query != nil {
+		target += "?" + query.Encode()
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org"+target, nil))
+
+	var v map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, w.Header().Get("Content-Type"), v
+}
+
+// TestMetadata tests the landing page, the conformance and the description of the collection.
+func TestMetadata(t *testing.T) {
+	s, api, _ := newTestServer(t)
+	defer api.Close()
+
+	code, _, v := get(t, s, "/", nil)
+	if code != http.StatusOK || len(v["links"].([]interface{})) != 3 {
+		t.Errorf("unexpected landing page %d: %v", code, v)
+	}
+	if code, _, v = get(t, s, "/conformance", nil); code != http.StatusOK || len(v["conformsTo"].([]interface{})) == 0 {
+		t.Errorf("unexpected conformance %d: %v", code, v)
+	}
+
+	code, _, v = get(t, s, "/collections", nil)
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	c := v["collections"].([]interface{})[0].(map[string]interface{})
+	if c["id"] != "xema" {
+		t.Errorf("unexpected collection %v", c["id"])
+	}
+	queries := c["data_queries"].(map[string]interface{})
+	for _, q := range []string{"position", "radius", "area", "locations"} {
+		l := queries[q].(map[string]interface{})["link"].(map[string]interface{})
+		if l["href"] != "http://example.org/collections/xema/"+q {
+			t.Errorf("unexpected %s link %v", q, l["href"])
+		}
+	}
+	p := c["parameter_names"].(map[string]interface{})
+	if len(p) != 3 {
+		t.Fatalf("expected 3 parameters, got %d", len(p))
+	}
+	if unit := p["T"].(map[string]interface{})["unit"].(map[string]interface{}); unit["symbol"] != "°C" {
+		t.Errorf("unexpected unit of T %v", unit)
+	}
+	bbox := c["extent"].(map[string]interface{})["spatial"].(map[string]interface{})["bbox"].([]interface{})[0].([]interface{})
+	if bbox[0].(float64) > d5.Longitud || bbox[3].(float64) < d5.Latitud {
+		t.Errorf("unexpected bbox %v", bbox)
+	}
+}
+
+// TestRadius tests the temperature of the stations within 10 km of a point on a date.
+func TestRadius(t *testing.T) {
+	s, api, hits := newTestServer(t)
+	defer api.Close()
+
+	code, contentType, v := get(t, s, "/collections/xema/radius", url.Values{
+		"coords":         {"POINT(2.12379 41.41864)"},
+		"within":         {"10"},
+		"within-units":   {"km"},
+		"parameter-name": {"T"},
+		"datetime":       {"2023-03-12"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	if contentType != CoverageJSON || v["type"] != "CoverageCollection" {
+		t.Errorf("unexpected response %s %v", contentType, v["type"])
+	}
+	if len(hits) != 1 || hits["/xema/v1/variables/mesurades/32/2023/03/12"] != 1 {
+		t.Errorf("unexpected API requests %v", hits)
+	}
+
+	var want int
+	for _, st := range s.stations {
+		if st.Coordenades.Distance(d5) <= 10 && st.Codi != "KE" {
+			want++
+		}
+	}
+	coverages := v["coverages"].([]interface{})
+	if want < 2 || len(coverages) > want {
+		t.Fatalf("expected at most %d coverages, got %d", want, len(coverages))
+	}
+
+	first := coverages[0].(map[string]interface{})
+	if first["id"] != "D5" {
+		t.Errorf("expected the nearest station first, got %v", first["id"])
+	}
+	axes := first["domain"].(map[string]interface{})["axes"].(map[string]interface{})
+	times := axes["t"].(map[string]interface{})["values"].([]interface{})
+	if len(times) != 48 || times[0] != "2023-03-12T00:00:00Z" {
+		t.Errorf("unexpected time axis %v", times)
+	}
+	r := first["ranges"].(map[string]interface{})["T"].(map[string]interface{})
+	if len(r["values"].([]interface{})) != 48 || r["shape"].([]interface{})[0] != 48.0 {
+		t.Errorf("unexpected range %v", r)
+	}
+	if _, ok := v["parameters"].(map[string]interface{})["T"]; !ok {
+		t.Errorf("missing parameter T in %v", v["parameters"])
+	}
+
+	code, _, v = get(t, s, "/collections/xema/radius", url.Values{
+		"coords":         {"POINT(2.12379 41.41864)"},
+		"within":         {"1000"},
+		"within-units":   {"m"},
+		"parameter-name": {"32"},
+		"datetime":       {"2023-03-12"},
+	})
+	if code != http.StatusOK || len(v["coverages"].([]interface{})) != 1 {
+		t.Errorf("expected only D5 within 1000 m, got %d: %v", code, v["coverages"])
+	}
+}
+
+// TestParallel tests that concurrent requests of the day and of the last readings get their own readings. Run it with
+// -race.
+func TestParallel(t *testing.T) {
+	s, api, _ := newTestServer(t)
+	defer api.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(day bool) {
+			defer wg.Done()
+			query := url.Values{
+				"coords":         {"POINT(2.12379 41.41864)"},
+				"within":         {"1000"},
+				"within-units":   {"m"},
+				"parameter-name": {"T"},
+			}
+			if day {
+				query.Set("datetime", "2023-03-12")
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/collections/xema/radius?"+query.Encode(), nil))
+
+			var v struct {
+				Coverages []struct{ ID string }
+			}
+			if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+				t.Error(err)
+				return
+			}
+			if w.Code != http.StatusOK || len(v.Coverages) != 1 || v.Coverages[0].ID != "D5" {
+				t.Errorf("day %v: unexpected response %d: %+v", day, w.Code, v)
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
+// TestPosition tests the reading of the nearest station at an instant.
+func TestPosition(t *testing.T) {
+	s, api, _ := newTestServer(t)
+	defer api.Close()
+
+	code, _, v := get(t, s, "/collections/xema/position", url.Values{
+		"coords":         {"POINT(2.124 41.418)"},
+		"parameter-name": {"T"},
+		"datetime":       {"2023-03-12T10:00:00Z"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	if v["type"] != "Coverage" || v["id"] != "D5" {
+		t.Errorf("unexpected coverage %v %v", v["type"], v["id"])
+	}
+	d := v["domain"].(map[string]interface{})
+	axes := d["axes"].(map[string]interface{})
+	if x := axes["x"].(map[string]interface{})["values"].([]interface{}); x[0] != d5.Longitud {
+		t.Errorf("unexpected x axis %v", x)
+	}
+	if times := axes["t"].(map[string]interface{})["values"].([]interface{}); len(times) != 1 || times[0] != "2023-03-12T10:00:00Z" {
+		t.Errorf("unexpected time axis %v", times)
+	}
+	if len(d["referencing"].([]interface{})) != 2 {
+		t.Errorf("unexpected referencing %v", d["referencing"])
+	}
+	if _, ok := v["parameters"].(map[string]interface{})["T"]; !ok {
+		t.Errorf("missing parameter T in %v", v["parameters"])
+	}
+}
+
+// TestArea tests the latest readings of the stations inside a polygon.
+func TestArea(t *testing.T) {
+	s, api, hits := newTestServer(t)
+	defer api.Close()
+
+	code, _, v := get(t, s, "/collections/xema/area", url.Values{
+		"coords":         {"POLYGON((2.2 42.07, 2.22 42.07, 2.22 42.08, 2.2 42.08, 2.2 42.07))"},
+		"parameter-name": {"T"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	coverages := v["coverages"].([]interface{})
+	if len(coverages) != 1 || coverages[0].(map[string]interface{})["id"] != "CC" {
+		t.Errorf("expected the CC station, got %v", coverages)
+	}
+	if hits["/xema/v1/variables/mesurades/32/ultimes"] != 1 {
+		t.Errorf("unexpected API requests %v", hits)
+	}
+}
+
+// TestLocations tests the list of the stations and the readings of one of them.
+func TestLocations(t *testing.T) {
+	s, api, hits := newTestServer(t)
+	defer api.Close()
+
+	code, contentType, v := get(t, s, "/collections/xema/locations", nil)
+	if code != http.StatusOK || contentType != "application/geo+json" {
+		t.Fatalf("unexpected response %d %s", code, contentType)
+	}
+	features := v["features"].([]interface{})
+	if len(features) != len(s.stations) {
+		t.Errorf("expected %d features, got %d", len(s.stations), len(features))
+	}
+
+	code, _, v = get(t, s, "/collections/xema/locations/d5", url.Values{
+		"datetime": {"2023-03-12T10:00:00Z/2023-03-13T12:00:00Z"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	times := v["domain"].(map[string]interface{})["axes"].(map[string]interface{})["t"].(map[string]interface{})["values"].([]interface{})
+	if len(times) != 28 {
+		t.Errorf("expected 28 readings since 10:00, got %d", len(times))
+	}
+	if len(v["ranges"].(map[string]interface{})) != 1 {
+		t.Errorf("expected the range of T only, got %v", v["ranges"])
+	}
+	// Every variable is requested for the two days
+	if len(hits) != 6 {
+		t.Errorf("unexpected API requests %v", hits)
+	}
+}
+
+// TestErrors tests the responses of invalid requests.
+func TestErrors(t *testing.T) {
+	s, api, _ := newTestServer(t)
+	defer api.Close()
+
+	tests := []struct {
+		path   string
+		query  url.Values
+		status int
+	}{
+		{"/collections/xema/position", url.Values{"coords": {"POINT(2.1)"}}, http.StatusBadRequest},
+		{"/collections/xema/position", url.Values{"coords": {"POLYGON((2 41, 3 41, 3 42, 2 41))"}}, http.StatusBadRequest},
+		{"/collections/xema/position", url.Values{"coords": {"POINT(2.1 41.4)"}, "parameter-name": {"XX"}}, http.StatusBadRequest},
+		{"/collections/xema/position", url.Values{"coords": {"POINT(2.1 41.4)"}, "parameter-name": {"HR"}, "datetime": {"2023-03-12"}}, http.StatusNotFound},
+		{"/collections/xema/radius", url.Values{"coords": {"POINT(2.1 41.4)"}}, http.StatusBadRequest},
+		{"/collections/xema/radius", url.Values{"coords": {"POINT(2.1 41.4)"}, "within": {"10"}, "within-units": {"ft"}}, http.StatusBadRequest},
+		{"/collections/xema/area", url.Values{"coords": {"POLYGON((2 41, 3 41, 3 42))"}}, http.StatusBadRequest},
+		{"/collections/xema/locations/D5", url.Values{"datetime": {"2023-01-01/2023-03-12"}}, http.StatusBadRequest},
+		{"/collections/xema/locations/D5", url.Values{"datetime": {"../2023-03-12"}}, http.StatusBadRequest},
+		{"/collections/xema/locations/D5", url.Values{"datetime": {"2023-03-12/2023-03-11"}}, http.StatusBadRequest},
+		{"/collections/xema/locations/D5", url.Values{"datetime": {"yesterday"}}, http.StatusBadRequest},
+		{"/collections/xema/locations/XX0", nil, http.StatusNotFound},
+		{"/collections/other/position", nil, http.StatusNotFound},
+		{"/collections/xema/cube", nil, http.StatusNotFound},
+		{"/unknown", nil, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		if code, _, v := get(t, s, tc.path, tc.query); code != tc.status {
+			t.Errorf("%s %v: expected status %d, got %d: %v", tc.path, tc.query, tc.status, code, v)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/collections", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
+
+// TestPolygon tests the point in polygon test with holes.
+func TestPolygon(t *testing.T) {
+	p, err := parsePolygon("POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		lon, lat float64
+		want     bool
+	}{
+		{1, 1, true},
+		{5, 5, false},
+		{9, 5, true},
+		{11, 5, false},
+		{5, -1, false},
+	}
+	for _, tc := range tests {
+		if got := p.contains(meteocat.Coordenades{Longitud: tc.lon, Latitud: tc.lat}); got != tc.want {
+			t.Errorf("(%v %v): expected %v, got %v", tc.lon, tc.lat, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package edr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// ring is a closed line of a polygon as longitude, latitude pairs
+type ring [][2]float64
+
+// polygon is an outer ring with optional holes
+type polygon []ring
+
+// parsePairs parses a list of coordinates separated by commas, e.g 2.1 41.3, 2.2 41.4
+func parsePairs(s string) ([][2]float64, error) {
+	var pairs [][2]float64
+	for _, p := range strings.Split(s, ",") {
+		fields := strings.Fields(p)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid coordinates %q", strings.TrimSpace(p))
+		}
+		var pair [2]float64
+		for i := 0; i < 2; i++ {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid coordinate %q", fields[i])
+			}
+			pair[i] = v
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// wktBody returns the text within the parentheses of a WKT geometry of a type
+func wktBody(s, typ string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < len(typ) || !strings.EqualFold(s[:len(typ)], typ) {
+		return "", false
+	}
+	s = strings.TrimSpace(s[len(typ):])
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return "", false
+	}
+	return s[1 : len(s)-1], true
+}
+
+// parsePoint parses a WKT point, e.g POINT(2.1 41.3), into its coordinates
+func parsePoint(s string) (meteocat.Coordenades, error) {
+	body, ok := wktBody(s, "POINT")
+	if !ok {
+		return meteocat.Coordenades{}, fmt.Errorf("coords must be a WKT POINT, e.g POINT(2.17 41.38)")
+	}
+	pairs, err := parsePairs(body)
+	if err != nil {
+		return meteocat.Coordenades{}, err
+	}
+	if len(pairs) != 1 {
+		return meteocat.Coordenades{}, fmt.Errorf("a POINT has a single position")
+	}
+	return meteocat.Coordenades{Longitud: pairs[0][0], Latitud: pairs[0][1]}, nil
+}
+
+// parsePolygon parses a WKT polygon, e.g POLYGON((2 41, 3 41, 3 42, 2 42, 2 41)), whose first ring is the outer one
+func parsePolygon(s string) (polygon, error) {
+	body, ok := wktBody(s, "POLYGON")
+	if !ok {
+		return nil, fmt.Errorf("coords must be a WKT POLYGON, e.g POLYGON((2 41, 3 41, 3 42, 2 42, 2 41))")
+	}
+
+	var p polygon
+	for body = strings.TrimSpace(body); body != ""; {
+		if body[0] != '(' {
+			return nil, fmt.Errorf("invalid POLYGON ring")
+		}
+		end := strings.IndexByte(body, ')')
+		if end < 0 {
+			return nil, fmt.Errorf("missing closing parenthesis in POLYGON")
+		}
+		pairs, err := parsePairs(body[1:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs) < 4 || pairs[0] != pairs[len(pairs)-1] {
+			return nil, fmt.Errorf("a POLYGON ring needs 4 positions at least, the last equal to the first")
+		}
+		p = append(p, ring(pairs))
+
+		body = strings.TrimSpace(body[end+1:])
+		body = strings.TrimSpace(strings.TrimPrefix(body, ","))
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("empty POLYGON")
+	}
+	return p, nil
+}
+
+// contains reports whether a position is inside the ring, by the even-odd rule
+func (r ring) contains(c meteocat.Coordenades) bool {
+	in := false
+	x, y := c.Longitud, c.Latitud
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi, xj, yj := r[i][0], r[i][1], r[j][0], r[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// contains reports whether a position is inside the outer ring and outside the holes
+func (p polygon) contains(c meteocat.Coordenades) bool {
+	if !p[0].contains(c) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.contains(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -3,16 +3,31 @@ package meteocat
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 )
 
+// earthRadius is the mean radius of the Earth in kilometers
+const earthRadius = 6371.0
+
 // Coordenades struct holds georeference information of the stations
 type Coordenades struct {
 	Latitud  float64 `json:"latitud"`  // Latitude expressed in decimal degrees. WSG84 reference system
 	Longitud float64 `json:"Longitud"` // Longitude expressed in decimal degrees. WSG84 reference system
 }
 
+// Distance returns the great-circle distance in kilometers between c and o using the haversine formula.
+func (c Coordenades) Distance(o Coordenades) float64 {
+	lat1 := c.Latitud * math.Pi / 180
+	lat2 := o.Latitud * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (o.Longitud - c.Longitud) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
 // Municipi struct holds information on which municipi is located the station.
 type Municipi struct {
 	Codi string `json:"codi"` // INE code of the municipi
@@ -53,23 +68,49 @@ type MetadadesEstacions struct {
 	Estats      Estats      `json:"estats"`
 }
 
-// Mesurades holds all the data representations to unmarshall the API responses
+// codisEstatNumerics maps the numeric codes of the Estats field to the keys of the CodisEstat map
+var codisEstatNumerics = map[int]string{
+	1: "des",
+	2: "ope",
+	3: "bte",
+}
+
+// EstatActual returns the key in the CodisEstat map of the current state of the station, that is the state without
+// end date. It returns an empty string when the state is unknown.
+func (m MetadadesEstacions) EstatActual() string {
+	for _, e := range m.Estats {
+		if e.DataFi == nil {
+			return codisEstatNumerics[e.Codi]
+		}
+	}
+	return ""
+}
+
+// MetadadesTotesEstacions is a slice which holds the metadata of all stations
+type MetadadesTotesEstacions []MetadadesEstacions
+
+// Estacions holds all the data representations to unmarshall the API responses
 type Estacions struct {
 	MetadadesEstacions
+	MetadadesTotesEstacions
 	Key          string
 	CodiEstacio  string // ?
 	CodiVariable string // ?
 	*Settings
 }
 
-// NewMesurades returns a new MesuradesData pointer with the supplied parameters
-func NewEstacions(key string) (*Estacions, error) {
+// NewEstacions returns a new Estacions pointer with the supplied parameters
+func NewEstacions(key string, options ...Option) (*Estacions, error) {
 	e := &Estacions{
 		Settings: NewSettings(),
 	}
 
 	e.Key, _ = setKey(key)
 
+	if err := setOptions(e.Settings, options); err != nil {
+		return nil, err
+	}
+
 	return e, nil
 }
 
@@ -79,20 +120,20 @@ func NewEstacions(key string) (*Estacions, error) {
 // Request example: https://api.meteo.cat/xema/v1/estacions/metadades?estat=ope&data=2017-03-27Z
 func (e *Estacions) StationsAll(p *Parameters) error {
 
+	var url string
 	dataOk := ValidData(p.Data)
 
 	if p.codiEstat != "" && dataOk == true {
 		p.codiEstat = strings.ToLower(p.codiEstat)
 
 		if ValidCodiEstat(p.codiEstat) {
-			sFlag = true
-			url = fmt.Sprintf(baseURL, fmt.Sprintf("/estacions/metadades?estat=%s&data=%s-%s-%sZ", p.codiEstat, p.Any, p.Mes, p.Dia))
+			url = e.endpoint(fmt.Sprintf("/estacions/metadades?estat=%s&data=%s-%s-%sZ", p.codiEstat, p.Any, p.Mes, p.Dia))
 		} else {
 			return errEstacioUnavailable
 		}
 
 	} else {
-		url = fmt.Sprintf(baseURL, fmt.Sprintf("/estacions/metadades"))
+		url = e.endpoint(fmt.Sprintf("/estacions/metadades"))
 	}
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -102,23 +143,52 @@ func (e *Estacions) StationsAll(p *Parameters) error {
 
 	req.Header.Add("X-Api-Key", e.Key)
 
-	resp, err := e.client.Do(req)
+	resp, err := e.do(req)
 
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 	defer resp.Body.Close()
-	//if sFlag == true {
-	if sFlag {
-		if err = json.NewDecoder(resp.Body).Decode(&e.MetadadesEstacions); err != nil {
-			return err
-		}
+
+	if err = json.NewDecoder(resp.Body).Decode(&e.MetadadesTotesEstacions); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StationByCode returns the metadata of the station with the code specified in the URL.
+// The API resource is /estacions/{codiEstacio}/metadades where the parameter `codiEstacio` is mandatory.
+// Request example: https://api.meteo.cat/xema/v1/estacions/D5/metadades
+func (e *Estacions) StationByCode(p *Parameters) error {
+
+	p.codiEstacio = strings.ToUpper(p.codiEstacio)
+
+	if ValidCodiEstacio(p.codiEstacio) {
+		e.CodiEstacio = p.codiEstacio
 	} else {
-		if err = json.NewDecoder(resp.Body).Decode(&e.MetadadesEstacions); err != nil {
-			return err
-		}
+		return errEstacioUnavailable
+	}
+
+	req, err := http.NewRequest("GET", e.endpoint(fmt.Sprintf("/estacions/%s/metadades", p.codiEstacio)), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("X-Api-Key", e.Key)
+
+	resp, err := e.do(req)
+
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
-	// print status line and headers
+	defer resp.Body.Close()
+
+	if err = json.NewDecoder(resp.Body).Decode(&e.MetadadesEstacions); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,132 @@
+package meteocat
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFixtureServer starts a test server which answers every request to path with the
+// content of the given testdata fixture and points baseURL at it. The returned function
+// restores baseURL and closes the server.
+func newFixtureServer(t *testing.T, path, fixture string) func() {
+	t.Helper()
+	return newFixtureServerFor(t, &baseURL, path, fixture)
+}
+
+// newFixtureServerFor is like newFixtureServer but points the given base URL variable at the server.
+func newFixtureServerFor(t *testing.T, base *string, path, fixture string) func() {
+	t.Helper()
+	return newFixturesServerFor(t, base, map[string]string{path: fixture})
+}
+
+// newFixturesServerFor starts a test server which answers each path of routes with the content of
+// its testdata fixture and points the given base URL variable at it.
+func newFixturesServerFor(t *testing.T, base *string, routes map[string]string) func() {
+	t.Helper()
+
+	bodies := make(map[string][]byte, len(routes))
+	for path, fixture := range routes {
+		body, err := ioutil.ReadFile("testdata/" + fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[path] = body
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+
+	oldURL := *base
+	*base = srv.URL + "%s"
+
+	return func() {
+		*base = oldURL
+		srv.Close()
+	}
+}
+
+// TestStationsAll tests that StationsAll decodes the metadata of every station.
+func TestStationsAll(t *testing.T) {
+	defer newFixtureServer(t, "/estacions/metadades", "metadades_totes_estacions.json")()
+
+	e, _ := NewEstacions("")
+	p, _ := NewParameters()
+
+	if err := e.StationsAll(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.MetadadesTotesEstacions) < 2 {
+		t.Fatalf("expected the full station list, got %d stations", len(e.MetadadesTotesEstacions))
+	}
+
+	first := e.MetadadesTotesEstacions[0]
+	if first.Codi != "CC" || first.Nom != "Orís" || first.Comarca.Nom != "Osona" {
+		t.Errorf("unexpected first station: %+v", first)
+	}
+}
+
+// TestStationByCode tests that StationByCode decodes the metadata of a single station.
+func TestStationByCode(t *testing.T) {
+	defer newFixtureServer(t, "/estacions/D5/metadades", "metadades_estacio_D5.json")()
+
+	e, _ := NewEstacions("")
+	p, _ := NewParameters(OptionCodiEstacio("d5"))
+
+	if err := e.StationByCode(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.CodiEstacio != "D5" {
+		t.Errorf("expected CodiEstacio D5, got %s", e.CodiEstacio)
+	}
+	if e.Codi != "D5" || e.Nom != "Barcelona - Observatori Fabra" {
+		t.Errorf("unexpected station: %+v", e.MetadadesEstacions)
+	}
+	if e.Coordenades.Latitud != 41.41864 || e.Coordenades.Longitud != 2.12379 {
+		t.Errorf("unexpected coordinates: %+v", e.Coordenades)
+	}
+	if e.Altitud != 411 || e.Municipi.Codi != "080193" || e.Comarca.Codi != 13 {
+		t.Errorf("unexpected location data: %+v", e.MetadadesEstacions)
+	}
+	if len(e.Estats) != 1 || e.Estats[0].Codi != 2 {
+		t.Errorf("unexpected estats: %+v", e.Estats)
+	}
+}
+
+// TestStationByCodeInvalid tests that StationByCode rejects unknown station codes.
+func TestStationByCodeInvalid(t *testing.T) {
+	e, _ := NewEstacions("")
+	p, _ := NewParameters(OptionCodiEstacio("anything"))
+
+	if err := e.StationByCode(p); err != errEstacioUnavailable {
+		t.Errorf("expected %v, got %v", errEstacioUnavailable, err)
+	}
+}
+
+// TestEstatActual tests that EstatActual returns the state without end date.
+func TestEstatActual(t *testing.T) {
+	e, _ := NewEstacions("")
+	p, _ := NewParameters(OptionCodiEstacio("D5"))
+
+	defer newFixtureServer(t, "/estacions/D5/metadades", "metadades_estacio_D5.json")()
+	if err := e.StationByCode(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := e.EstatActual(); s != "ope" {
+		t.Errorf("expected ope, got %q", s)
+	}
+	if s := (MetadadesEstacions{}).EstatActual(); s != "" {
+		t.Errorf("expected an empty state, got %q", s)
+	}
+}
@@ -0,0 +1,249 @@
+// Package export writes measurements and station metadata as tidy CSV or TSV, one row per station, variable and
+// timestamp. Rows are written as they are passed in, so long exports can be streamed day by day without holding
+// the whole period in memory. The station network can also be exported as GeoJSON or KML for maps.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+var errUnknownColumn = errors.New("unknown column")
+var errNoColumns = errors.New("no columns")
+var errInvalidOption = errors.New("invalid option")
+
+// Row holds a reading of a variable at a station
+type Row struct {
+	Estacio  string           // Station code
+	Variable int              // Variable code
+	Lectura  meteocat.Lectura // Reading
+}
+
+// column returns the value of a column for a row, using the station and variable catalogues of the Writer
+type column func(w *Writer, r Row) string
+
+// columns holds the columns available to the exports
+var columns = map[string]column{
+	"estacio":  func(w *Writer, r Row) string { return r.Estacio },
+	"variable": func(w *Writer, r Row) string { return strconv.Itoa(r.Variable) },
+	"data":     func(w *Writer, r Row) string { return r.Lectura.Data },
+	"valor": func(w *Writer, r Row) string {
+		return strconv.FormatFloat(r.Lectura.Valor, 'f', -1, 64)
+	},
+	"estat":        func(w *Writer, r Row) string { return r.Lectura.Estat },
+	"base_horaria": func(w *Writer, r Row) string { return r.Lectura.BaseHoraria },
+	"nom_estacio": func(w *Writer, r Row) string {
+		if e, ok := w.stations[r.Estacio]; ok {
+			return e.Nom
+		}
+		return meteocat.CodisEstacions[r.Estacio]
+	},
+	"municipi":  func(w *Writer, r Row) string { return w.stations[r.Estacio].Municipi.Nom },
+	"comarca":   func(w *Writer, r Row) string { return w.stations[r.Estacio].Comarca.Nom },
+	"provincia": func(w *Writer, r Row) string { return w.stations[r.Estacio].Provincia.Nom },
+	"latitud": func(w *Writer, r Row) string {
+		if e, ok := w.stations[r.Estacio]; ok {
+			return strconv.FormatFloat(e.Coordenades.Latitud, 'f', -1, 64)
+		}
+		return ""
+	},
+	"longitud": func(w *Writer, r Row) string {
+		if e, ok := w.stations[r.Estacio]; ok {
+			return strconv.FormatFloat(e.Coordenades.Longitud, 'f', -1, 64)
+		}
+		return ""
+	},
+	"altitud": func(w *Writer, r Row) string {
+		if e, ok := w.stations[r.Estacio]; ok {
+			return strconv.FormatFloat(e.Altitud, 'f', -1, 64)
+		}
+		return ""
+	},
+	"nom_variable": func(w *Writer, r Row) string {
+		if v, ok := w.variables[r.Variable]; ok {
+			return v.Nom
+		}
+		return meteocat.CodisVariables[strconv.Itoa(r.Variable)]
+	},
+	"acronim": func(w *Writer, r Row) string { return w.variables[r.Variable].Acronim },
+	"unitats": func(w *Writer, r Row) string { return w.variables[r.Variable].Unitats },
+}
+
+// DefaultColumns holds the columns written when no columns are configured
+var DefaultColumns = []string{"estacio", "variable", "data", "valor", "estat", "base_horaria"}
+
+// Columns returns the names of all the available columns
+func Columns() []string {
+	return []string{
+		"estacio", "nom_estacio", "municipi", "comarca", "provincia", "latitud", "longitud", "altitud",
+		"variable", "nom_variable", "acronim", "unitats", "data", "valor", "estat", "base_horaria",
+	}
+}
+
+// Writer writes readings as delimited text with a header row
+type Writer struct {
+	csv       *csv.Writer
+	columns   []string
+	stations  map[string]meteocat.MetadadesEstacions
+	variables map[int]meteocat.MetadadesVariable
+	header    bool
+	record    []string
+}
+
+// Option configures a Writer
+type Option func(w *Writer) error
+
+// WithColumns sets the columns written and their order. See Columns for the available ones.
+func WithColumns(names ...string) Option {
+	return func(w *Writer) error {
+		if len(names) == 0 {
+			return errNoColumns
+		}
+		for _, n := range names {
+			if _, ok := columns[n]; !ok {
+				return fmt.Errorf("%w: %s", errUnknownColumn, n)
+			}
+		}
+		w.columns = names
+		return nil
+	}
+}
+
+// TSV writes tab separated values instead of comma separated ones.
+func TSV() Option {
+	return func(w *Writer) error {
+		w.csv.Comma = '\t'
+		return nil
+	}
+}
+
+// WithStations joins the station metadata, used by the nom_estacio, municipi, comarca, provincia, latitud,
+// longitud and altitud columns.
+func WithStations(stations meteocat.MetadadesTotesEstacions) Option {
+	return func(w *Writer) error {
+		for _, e := range stations {
+			w.stations[e.Codi] = e
+		}
+		return nil
+	}
+}
+
+// WithVariables joins the variable catalogue, used by the nom_variable, acronim and unitats columns.
+func WithVariables(variables meteocat.MetadadesVariables) Option {
+	return func(w *Writer) error {
+		for _, v := range variables {
+			w.variables[v.Codi] = v.MetadadesVariable
+		}
+		return nil
+	}
+}
+
+// NewWriter returns a new Writer pointer which writes to out
+func NewWriter(out io.Writer, options ...Option) (*Writer, error) {
+	w := &Writer{
+		csv:       csv.NewWriter(out),
+		columns:   DefaultColumns,
+		stations:  make(map[string]meteocat.MetadadesEstacions),
+		variables: make(map[int]meteocat.MetadadesVariable),
+	}
+
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(w); err != nil {
+			return nil, err
+		}
+	}
+	w.record = make([]string, len(w.columns))
+
+	return w, nil
+}
+
+// Write writes a row, preceded by the header if it is the first one
+func (w *Writer) Write(r Row) error {
+	if !w.header {
+		if err := w.csv.Write(w.columns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	for i, c := range w.columns {
+		w.record[i] = columns[c](w, r)
+	}
+	return w.csv.Write(w.record)
+}
+
+// WriteVariable writes the readings of a variable measured at a station
+func (w *Writer) WriteVariable(estacio string, v meteocat.Variable) error {
+	for _, l := range v.Lectures {
+		if err := w.Write(Row{Estacio: estacio, Variable: v.Codi, Lectura: l}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMeasurements writes the readings of all the stations and variables of m
+func (w *Writer) WriteMeasurements(m meteocat.Measurements) error {
+	for _, st := range m {
+		for _, v := range st.Variables {
+			if err := w.WriteVariable(st.Codi, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writer
+func (w *Writer) Flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// StationsHeader holds the columns written by WriteStations
+var StationsHeader = []string{
+	"codi", "nom", "tipus", "emplacament", "latitud", "longitud", "altitud",
+	"municipi_codi", "municipi", "comarca_codi", "comarca", "provincia_codi", "provincia", "xarxa",
+}
+
+// WriteStations writes the station metadata, one row per station. Use comma ',' for CSV or '\t' for TSV.
+func WriteStations(out io.Writer, stations meteocat.MetadadesTotesEstacions, comma rune) error {
+	cw := csv.NewWriter(out)
+	cw.Comma = comma
+
+	if err := cw.Write(StationsHeader); err != nil {
+		return err
+	}
+	for _, e := range stations {
+		err := cw.Write([]string{
+			e.Codi,
+			e.Nom,
+			e.Tipus,
+			e.Emplacament,
+			strconv.FormatFloat(e.Coordenades.Latitud, 'f', -1, 64),
+			strconv.FormatFloat(e.Coordenades.Longitud, 'f', -1, 64),
+			strconv.FormatFloat(e.Altitud, 'f', -1, 64),
+			e.Municipi.Codi,
+			e.Municipi.Nom,
+			strconv.Itoa(e.Comarca.Codi),
+			e.Comarca.Nom,
+			strconv.Itoa(e.Provincia.Codi),
+			e.Provincia.Nom,
+			e.Xarxa.Nom,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+)
+
+// TestWriteMeasurements tests that every reading of every station becomes a row with the joined metadata.
+func TestWriteMeasurements(t *testing.T) {
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+	var stations meteocat.MetadadesTotesEstacions
+	testutil.Load(t, "metadades_totes_estacions.json", &stations)
+
+	variables := meteocat.MetadadesVariables{
+		{meteocat.MetadadesVariable{Codi: 32, Nom: "Temperatura", Unitats: "°C", Acronim: "T", Decimals: 1}},
+	}
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b,
+		WithColumns("estacio", "nom_estacio", "comarca", "acronim", "unitats", "data", "valor", "estat"),
+		WithStations(stations),
+		WithVariables(variables),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMeasurements(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := 0
+	for _, st := range m {
+		for _, v := range st.Variables {
+			want += len(v.Lectures)
+		}
+	}
+	if len(records) != want+1 {
+		t.Fatalf("expected %d rows, got %d", want+1, len(records))
+	}
+
+	if h := strings.Join(records[0], ","); h != "estacio,nom_estacio,comarca,acronim,unitats,data,valor,estat" {
+		t.Errorf("unexpected header %s", h)
+	}
+	if r := strings.Join(records[1], ","); r != "C6,Castellnou de Seana,Pla d'Urgell,T,°C,2023-03-12T00:00Z,14.6,V" {
+		t.Errorf("unexpected first row %s", r)
+	}
+}
+
+// TestWriteVariableTSV tests the tab separated output with the default columns.
+func TestWriteVariableTSV(t *testing.T) {
+	var v meteocat.Variable
+	testutil.Load(t, "mesurades_dia_unica_estacio.json", &v)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, TSV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteVariable("D5", v); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+
+	want := "estacio\tvariable\tdata\tvalor\testat\tbase_horaria\n" +
+		"D5\t32\t2023-03-12T00:00Z\t15.2\tV\tSH\n" +
+		"D5\t32\t2023-03-12T00:30Z\t15.7\tV\tSH\n"
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+// TestWithColumnsUnknown tests that unknown columns are rejected.
+func TestWithColumnsUnknown(t *testing.T) {
+	if _, err := NewWriter(ioutil.Discard, WithColumns("estacio", "humitat")); !errors.Is(err, errUnknownColumn) {
+		t.Errorf("expected %v, got %v", errUnknownColumn, err)
+	}
+}
+
+// TestWriteStations tests the export of the station metadata.
+func TestWriteStations(t *testing.T) {
+	var e meteocat.MetadadesEstacions
+	testutil.Load(t, "metadades_estacio_D5.json", &e)
+
+	var b bytes.Buffer
+	if err := WriteStations(&b, meteocat.MetadadesTotesEstacions{e}, ','); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if lines[1] != "D5,Barcelona - Observatori Fabra,A,Observatori Fabra,41.41864,2.12379,411,080193,Barcelona,13,Barcelonès,8,Barcelona,XEMA" {
+		t.Errorf("unexpected row %s", lines[1])
+	}
+}
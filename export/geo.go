@@ -0,0 +1,199 @@
+package export
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// latestReading holds the last reading of the chosen variable at a station
+type latestReading struct {
+	variable int
+	lectura  meteocat.Lectura
+}
+
+// geoConfig holds the settings of the GeoJSON and KML exports
+type geoConfig struct {
+	readings map[string]latestReading
+}
+
+// GeoOption configures the GeoJSON and KML exports
+type GeoOption func(c *geoConfig) error
+
+// WithLatestReading attaches the latest reading of the variable with code codiVariable to each station, as
+// returned for all stations by MeasurementLast. Stations without readings of the variable get no value.
+func WithLatestReading(codiVariable int, m meteocat.Measurements) GeoOption {
+	return func(c *geoConfig) error {
+		for _, st := range m {
+			for _, v := range st.Variables {
+				if v.Codi != codiVariable {
+					continue
+				}
+				for _, l := range v.Lectures {
+					if r, ok := c.readings[st.Codi]; !ok || l.Data > r.lectura.Data {
+						c.readings[st.Codi] = latestReading{variable: codiVariable, lectura: l}
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// newGeoConfig applies the options to a new geoConfig
+func newGeoConfig(options []GeoOption) (*geoConfig, error) {
+	c := &geoConfig{readings: make(map[string]latestReading)}
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// property holds a named property of a station
+type property struct {
+	name  string
+	value interface{}
+}
+
+// properties returns the properties of a station in a stable order
+func (c *geoConfig) properties(e meteocat.MetadadesEstacions) []property {
+	props := []property{
+		{"codi", e.Codi},
+		{"nom", e.Nom},
+		{"tipus", e.Tipus},
+		{"emplacament", e.Emplacament},
+		{"altitud", e.Altitud},
+		{"municipi", e.Municipi.Nom},
+		{"comarca", e.Comarca.Nom},
+		{"comarca_codi", e.Comarca.Codi},
+		{"provincia", e.Provincia.Nom},
+		{"xarxa", e.Xarxa.Nom},
+		{"estat", e.EstatActual()},
+	}
+	if r, ok := c.readings[e.Codi]; ok {
+		props = append(props,
+			property{"variable", r.variable},
+			property{"valor", r.lectura.Valor},
+			property{"data", r.lectura.Data},
+			property{"estat_lectura", r.lectura.Estat},
+		)
+	}
+	return props
+}
+
+// geoJSONFeature is a GeoJSON Feature with a Point geometry
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   geoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoJSONPoint is a GeoJSON Point geometry
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"` // Longitude, latitude and altitude
+}
+
+// GeoJSON writes the stations as a GeoJSON FeatureCollection of points. The coordinates hold the longitude, the
+// latitude and the altitude of each station.
+func GeoJSON(out io.Writer, stations meteocat.MetadadesTotesEstacions, options ...GeoOption) error {
+	c, err := newGeoConfig(options)
+	if err != nil {
+		return err
+	}
+
+	fc := struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+
+	for _, e := range stations {
+		f := geoJSONFeature{
+			Type: "Feature",
+			ID:   e.Codi,
+			Geometry: geoJSONPoint{
+				Type:        "Point",
+				Coordinates: []float64{e.Coordenades.Longitud, e.Coordenades.Latitud, e.Altitud},
+			},
+			Properties: make(map[string]interface{}),
+		}
+		for _, p := range c.properties(e) {
+			f.Properties[p.name] = p.value
+		}
+		fc.Features = append(fc.Features, f)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
+
+// kmlData is a KML ExtendedData entry
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// kmlPlacemark is a KML Placemark with a Point geometry
+type kmlPlacemark struct {
+	ID          string    `xml:"id,attr"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"description"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Coordinates string    `xml:"Point>coordinates"`
+}
+
+// kmlDocument is the root of a KML file
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Namespace  string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// KML writes the stations as a KML document with a Placemark per station. The station properties are written as
+// ExtendedData.
+func KML(out io.Writer, stations meteocat.MetadadesTotesEstacions, options ...GeoOption) error {
+	c, err := newGeoConfig(options)
+	if err != nil {
+		return err
+	}
+
+	doc := kmlDocument{Namespace: "http://www.opengis.net/kml/2.2", Name: "XEMA"}
+	for _, e := range stations {
+		pm := kmlPlacemark{
+			ID:          e.Codi,
+			Name:        e.Nom,
+			Description: fmt.Sprintf("%s, %s (%s)", e.Emplacament, e.Municipi.Nom, e.Comarca.Nom),
+			Coordinates: fmt.Sprintf("%s,%s,%s",
+				strconv.FormatFloat(e.Coordenades.Longitud, 'f', -1, 64),
+				strconv.FormatFloat(e.Coordenades.Latitud, 'f', -1, 64),
+				strconv.FormatFloat(e.Altitud, 'f', -1, 64)),
+		}
+		for _, p := range c.properties(e) {
+			pm.Data = append(pm.Data, kmlData{Name: p.name, Value: fmt.Sprint(p.value)})
+		}
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "\n")
+	return err
+}
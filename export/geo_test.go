@@ -0,0 +1,91 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+)
+
+// TestGeoJSON tests the FeatureCollection of the station network with the latest temperature attached.
+func TestGeoJSON(t *testing.T) {
+	var stations meteocat.MetadadesTotesEstacions
+	testutil.Load(t, "metadades_totes_estacions.json", &stations)
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	var b bytes.Buffer
+	if err := GeoJSON(&b, stations, WithLatestReading(32, m)); err != nil {
+		t.Fatal(err)
+	}
+
+	var fc struct {
+		Type     string
+		Features []struct {
+			ID       string
+			Geometry struct {
+				Type        string
+				Coordinates []float64
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+
+	if fc.Type != "FeatureCollection" || len(fc.Features) != len(stations) {
+		t.Fatalf("expected a FeatureCollection of %d features, got %s of %d", len(stations), fc.Type, len(fc.Features))
+	}
+
+	var found bool
+	for _, f := range fc.Features {
+		if f.ID != "C6" {
+			continue
+		}
+		found = true
+		if c := f.Geometry.Coordinates; f.Geometry.Type != "Point" || c[0] != 0.95172 || c[1] != 41.6566 || c[2] != 264 {
+			t.Errorf("unexpected geometry: %+v", f.Geometry)
+		}
+		p := f.Properties
+		if p["comarca"] != "Pla d'Urgell" || p["estat"] != "ope" || p["emplacament"] == "" {
+			t.Errorf("unexpected properties: %v", p)
+		}
+		if p["variable"] != float64(32) || p["data"] != "2023-03-12T23:30Z" {
+			t.Errorf("expected the last reading of the day, got %v", p)
+		}
+	}
+	if !found {
+		t.Error("station C6 not found")
+	}
+}
+
+// TestKML tests the KML document of a station.
+func TestKML(t *testing.T) {
+	var e meteocat.MetadadesEstacions
+	testutil.Load(t, "metadades_estacio_D5.json", &e)
+
+	var b bytes.Buffer
+	if err := KML(&b, meteocat.MetadadesTotesEstacions{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc kmlDocument
+	if err := xml.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Placemarks) != 1 {
+		t.Fatalf("expected 1 placemark, got %d", len(doc.Placemarks))
+	}
+	pm := doc.Placemarks[0]
+	if pm.Name != "Barcelona - Observatori Fabra" || pm.Coordinates != "2.12379,41.41864,411" {
+		t.Errorf("unexpected placemark: %+v", pm)
+	}
+	if !strings.Contains(b.String(), `<Data name="comarca">`) || !strings.Contains(b.String(), "<value>Barcelonès</value>") {
+		t.Errorf("expected the comarca in the extended data:\n%s", b.String())
+	}
+}
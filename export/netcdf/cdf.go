@@ -0,0 +1,178 @@
+package netcdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Tags of the classic format header lists
+const (
+	tagDimension = 0x0a
+	tagVariable  = 0x0b
+	tagAttribute = 0x0c
+)
+
+// ncType is the external type of an attribute or a variable
+type ncType int32
+
+// Classic format external types
+const (
+	ncChar   ncType = 2
+	ncInt    ncType = 4
+	ncDouble ncType = 6
+)
+
+// dimension is a named dimension of the file
+type dimension struct {
+	name   string
+	length int
+}
+
+// attribute is a named attribute of the file or a variable. The value is a string, an int32 or a float64.
+type attribute struct {
+	name  string
+	value interface{}
+}
+
+// variable is a named variable of the file, whose data is already encoded in big endian order
+type variable struct {
+	name  string
+	dims  []int // Dimension ids
+	attrs []attribute
+	typ   ncType
+	data  []byte
+}
+
+// file holds the dimensions, attributes and variables of a netCDF file with the 64-bit offset classic format
+type file struct {
+	dims  []dimension
+	attrs []attribute
+	vars  []variable
+}
+
+// padding returns the number of zero bytes needed to align n to 4 bytes
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
+
+// header is a buffer which encodes the classic format header
+type header struct {
+	bytes.Buffer
+}
+
+// int32 writes a big endian 32-bit integer
+func (h *header) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	h.Write(b[:])
+}
+
+// int64 writes a big endian 64-bit integer
+func (h *header) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	h.Write(b[:])
+}
+
+// name writes a length prefixed name padded to 4 bytes
+func (h *header) name(s string) {
+	h.int32(int32(len(s)))
+	h.WriteString(s)
+	h.Write(make([]byte, padding(len(s))))
+}
+
+// attrs writes an attribute list
+func (h *header) attrs(attrs []attribute) {
+	if len(attrs) == 0 {
+		h.int64(0) // ABSENT
+		return
+	}
+	h.int32(tagAttribute)
+	h.int32(int32(len(attrs)))
+	for _, a := range attrs {
+		h.name(a.name)
+		switch v := a.value.(type) {
+		case string:
+			h.int32(int32(ncChar))
+			h.name(v)
+		case int32:
+			h.int32(int32(ncInt))
+			h.int32(1)
+			h.int32(v)
+		case float64:
+			h.int32(int32(ncDouble))
+			h.int32(1)
+			h.int64(int64(math.Float64bits(v)))
+		}
+	}
+}
+
+// header returns the encoded header of the file, with the data of each variable starting at the given offsets
+func (f *file) header(begins []int64) []byte {
+	var h header
+	h.WriteString("CDF\x02")
+	h.int32(0) // No record variables
+
+	if len(f.dims) == 0 {
+		h.int64(0)
+	} else {
+		h.int32(tagDimension)
+		h.int32(int32(len(f.dims)))
+		for _, d := range f.dims {
+			h.name(d.name)
+			h.int32(int32(d.length))
+		}
+	}
+
+	h.attrs(f.attrs)
+
+	if len(f.vars) == 0 {
+		h.int64(0)
+	} else {
+		h.int32(tagVariable)
+		h.int32(int32(len(f.vars)))
+		for i, v := range f.vars {
+			h.name(v.name)
+			h.int32(int32(len(v.dims)))
+			for _, id := range v.dims {
+				h.int32(int32(id))
+			}
+			h.attrs(v.attrs)
+			h.int32(int32(v.typ))
+			vsize := int64(len(v.data) + padding(len(v.data)))
+			if vsize > math.MaxUint32-1 {
+				vsize = math.MaxUint32
+			}
+			h.int32(int32(uint32(vsize)))
+			h.int64(begins[i])
+		}
+	}
+
+	return h.Bytes()
+}
+
+// encode writes the file to out
+func (f *file) encode(out io.Writer) error {
+	// The header size does not depend on the offsets, so it is computed first to place the data after it.
+	begins := make([]int64, len(f.vars))
+	offset := int64(len(f.header(begins)))
+	for i, v := range f.vars {
+		begins[i] = offset
+		offset += int64(len(v.data) + padding(len(v.data)))
+	}
+
+	if _, err := out.Write(f.header(begins)); err != nil {
+		return err
+	}
+	for _, v := range f.vars {
+		if _, err := out.Write(v.data); err != nil {
+			return err
+		}
+		if _, err := out.Write(make([]byte, padding(len(v.data)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+// Package netcdf writes measurement series of several stations as netCDF files following the CF "timeSeries"
+// discrete sampling geometry, so they can be opened directly with xarray. The writer is pure Go, writes the 64-bit
+// offset classic format and has no dependencies besides the standard library.
+//
+// The files use the orthogonal multidimensional representation: a station dimension with the station_id,
+// station_name, lat, lon and alt variables, a time dimension shared by all the stations, and one variable per XEMA
+// variable code with dimensions (station, time). Missing readings hold the fill value.
+package netcdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"unicode"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// FillValue is the value of the missing readings and unknown coordinates, the netCDF default for doubles
+const FillValue = 9.969209968386869e36
+
+var errClosed = errors.New("writer closed")
+var errInvalidOption = errors.New("invalid option")
+var errNoReadings = errors.New("no readings")
+
+// units maps the units used by the API to their UDUNITS spelling
+var units = map[string]string{
+	"°C":   "degree_Celsius",
+	"ºC":   "degree_Celsius",
+	"°":    "degree",
+	"º":    "degree",
+	"m/s":  "m s-1",
+	"W/m2": "W m-2",
+	"W/m²": "W m-2",
+}
+
+// Writer collects readings and writes them as a netCDF file on Close. The classic format stores the size of every
+// variable in the header, so the readings are held in memory until then.
+type Writer struct {
+	out       io.Writer
+	title     string
+	stations  map[string]meteocat.MetadadesEstacions
+	variables map[int]meteocat.MetadadesVariable
+	values    map[int]map[string]map[int64]float64 // Values by variable code, station code and Unix time
+	closed    bool
+}
+
+// Option configures a Writer
+type Option func(w *Writer) error
+
+// WithStations sets the station catalogue, used for the names and coordinates of the stations.
+func WithStations(stations meteocat.MetadadesTotesEstacions) Option {
+	return func(w *Writer) error {
+		for _, e := range stations {
+			w.stations[e.Codi] = e
+		}
+		return nil
+	}
+}
+
+// WithVariables sets the variable catalogue, used for the names, long names and units of the variables.
+func WithVariables(variables meteocat.MetadadesVariables) Option {
+	return func(w *Writer) error {
+		for _, v := range variables {
+			w.variables[v.Codi] = v.MetadadesVariable
+		}
+		return nil
+	}
+}
+
+// WithTitle sets the title global attribute.
+func WithTitle(title string) Option {
+	return func(w *Writer) error {
+		w.title = title
+		return nil
+	}
+}
+
+// NewWriter returns a new Writer pointer which writes a netCDF file to out when closed
+func NewWriter(out io.Writer, options ...Option) (*Writer, error) {
+	w := &Writer{
+		out:       out,
+		title:     "XEMA station measurements",
+		stations:  make(map[string]meteocat.MetadadesEstacions),
+		variables: make(map[int]meteocat.MetadadesVariable),
+		values:    make(map[int]map[string]map[int64]float64),
+	}
+
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(w); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// Write adds a reading. A later reading of the same station, variable and time replaces the earlier one.
+func (w *Writer) Write(r export.Row) error {
+	if w.closed {
+		return errClosed
+	}
+
+	t, err := meteocat.ParseData(r.Lectura.Data)
+	if err != nil {
+		return err
+	}
+
+	byStation, ok := w.values[r.Variable]
+	if !ok {
+		byStation = make(map[string]map[int64]float64)
+		w.values[r.Variable] = byStation
+	}
+	byTime, ok := byStation[r.Estacio]
+	if !ok {
+		byTime = make(map[int64]float64)
+		byStation[r.Estacio] = byTime
+	}
+	byTime[t.Unix()] = r.Lectura.Valor
+	return nil
+}
+
+// WriteVariable adds the readings of a variable measured at a station
+func (w *Writer) WriteVariable(estacio string, v meteocat.Variable) error {
+	for _, l := range v.Lectures {
+		if err := w.Write(export.Row{Estacio: estacio, Variable: v.Codi, Lectura: l}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMeasurements adds the readings of all the stations and variables of m
+func (w *Writer) WriteMeasurements(m meteocat.Measurements) error {
+	for _, st := range m {
+		for _, v := range st.Variables {
+			if err := w.WriteVariable(st.Codi, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validName reports whether s can be used as a variable name
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// doubles encodes float64 values in big endian order
+func doubles(v []float64) []byte {
+	b := make([]byte, 8*len(v))
+	for i, f := range v {
+		binary.BigEndian.PutUint64(b[8*i:], math.Float64bits(f))
+	}
+	return b
+}
+
+// chars encodes strings as a fixed width character array
+func chars(s []string, width int) []byte {
+	b := make([]byte, width*len(s))
+	for i, v := range s {
+		copy(b[width*i:width*(i+1)], v)
+	}
+	return b
+}
+
+// file builds the netCDF file of the collected readings
+func (w *Writer) file() (*file, error) {
+	if len(w.values) == 0 {
+		return nil, errNoReadings
+	}
+
+	// Stations, times and variables are sorted so the output does not depend on the order of the readings
+	seenStation := make(map[string]bool)
+	seenTime := make(map[int64]bool)
+	var codes []string
+	var times []int64
+	var variables []int
+	for v, byStation := range w.values {
+		variables = append(variables, v)
+		for st, byTime := range byStation {
+			if !seenStation[st] {
+				seenStation[st] = true
+				codes = append(codes, st)
+			}
+			for t := range byTime {
+				if !seenTime[t] {
+					seenTime[t] = true
+					times = append(times, t)
+				}
+			}
+		}
+	}
+	sort.Strings(codes)
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	sort.Ints(variables)
+
+	idLen, nameLen := 1, 1
+	names := make([]string, len(codes))
+	lat := make([]float64, len(codes))
+	lon := make([]float64, len(codes))
+	alt := make([]float64, len(codes))
+	for i, c := range codes {
+		e, ok := w.stations[c]
+		names[i] = meteocat.CodisEstacions[c]
+		lat[i], lon[i], alt[i] = FillValue, FillValue, FillValue
+		if ok {
+			names[i] = e.Nom
+			lat[i], lon[i], alt[i] = e.Coordenades.Latitud, e.Coordenades.Longitud, e.Altitud
+		}
+		if len(c) > idLen {
+			idLen = len(c)
+		}
+		if len(names[i]) > nameLen {
+			nameLen = len(names[i])
+		}
+	}
+
+	seconds := make([]float64, len(times))
+	for i, t := range times {
+		seconds[i] = float64(t)
+	}
+
+	const (
+		dimStation = iota
+		dimTime
+		dimIDLen
+		dimNameLen
+	)
+	f := &file{
+		dims: []dimension{
+			{"station", len(codes)},
+			{"time", len(times)},
+			{"id_strlen", idLen},
+			{"name_strlen", nameLen},
+		},
+		attrs: []attribute{
+			{"Conventions", "CF-1.8"},
+			{"featureType", "timeSeries"},
+			{"title", w.title},
+			{"institution", "Servei Meteorològic de Catalunya"},
+			{"source", "XEMA, Xarxa d'Estacions Meteorològiques Automàtiques"},
+		},
+		vars: []variable{
+			{
+				name: "station_id",
+				dims: []int{dimStation, dimIDLen},
+				attrs: []attribute{
+					{"long_name", "station code"},
+					{"cf_role", "timeseries_id"},
+				},
+				typ:  ncChar,
+				data: chars(codes, idLen),
+			},
+			{
+				name: "station_name",
+				dims: []int{dimStation, dimNameLen},
+				attrs: []attribute{
+					{"long_name", "station name"},
+					{"_Encoding", "utf-8"},
+				},
+				typ:  ncChar,
+				data: chars(names, nameLen),
+			},
+			{
+				name: "lat",
+				dims: []int{dimStation},
+				attrs: []attribute{
+					{"standard_name", "latitude"},
+					{"long_name", "station latitude"},
+					{"units", "degrees_north"},
+					{"_FillValue", FillValue},
+				},
+				typ:  ncDouble,
+				data: doubles(lat),
+			},
+			{
+				name: "lon",
+				dims: []int{dimStation},
+				attrs: []attribute{
+					{"standard_name", "longitude"},
+					{"long_name", "station longitude"},
+					{"units", "degrees_east"},
+					{"_FillValue", FillValue},
+				},
+				typ:  ncDouble,
+				data: doubles(lon),
+			},
+			{
+				name: "alt",
+				dims: []int{dimStation},
+				attrs: []attribute{
+					{"standard_name", "altitude"},
+					{"long_name", "station altitude"},
+					{"units", "m"},
+					{"positive", "up"},
+					{"axis", "Z"},
+					{"_FillValue", FillValue},
+				},
+				typ:  ncDouble,
+				data: doubles(alt),
+			},
+			{
+				name: "time",
+				dims: []int{dimTime},
+				attrs: []attribute{
+					{"standard_name", "time"},
+					{"long_name", "time"},
+					{"units", "seconds since 1970-01-01 00:00:00 UTC"},
+					{"calendar", "standard"},
+					{"axis", "T"},
+				},
+				typ:  ncDouble,
+				data: doubles(seconds),
+			},
+		},
+	}
+
+	used := make(map[string]bool)
+	for _, v := range f.vars {
+		used[v.name] = true
+	}
+	for _, v := range variables {
+		meta, ok := w.variables[v]
+		longName := meteocat.CodisVariables[strconv.Itoa(v)]
+		name := meta.Acronim
+		if ok {
+			longName = meta.Nom
+		}
+		if !validName(name) || used[name] {
+			name = "var_" + strconv.Itoa(v)
+		}
+		used[name] = true
+
+		attrs := []attribute{{"long_name", longName}}
+		if u, ok := units[meta.Unitats]; ok {
+			attrs = append(attrs, attribute{"units", u})
+		} else if meta.Unitats != "" {
+			attrs = append(attrs, attribute{"units", meta.Unitats})
+		}
+		attrs = append(attrs,
+			attribute{"codi_variable", int32(v)},
+			attribute{"coordinates", "time lat lon alt station_id"},
+			attribute{"_FillValue", FillValue},
+		)
+
+		values := make([]float64, 0, len(codes)*len(times))
+		for _, c := range codes {
+			byTime := w.values[v][c]
+			for _, t := range times {
+				if val, ok := byTime[t]; ok {
+					values = append(values, val)
+				} else {
+					values = append(values, FillValue)
+				}
+			}
+		}
+
+		f.vars = append(f.vars, variable{
+			name:  name,
+			dims:  []int{dimStation, dimTime},
+			attrs: attrs,
+			typ:   ncDouble,
+			data:  doubles(values),
+		})
+	}
+
+	return f, nil
+}
+
+// Close writes the netCDF file with all the collected readings. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errClosed
+	}
+	w.closed = true
+
+	f, err := w.file()
+	if err != nil {
+		return err
+	}
+	return f.encode(w.out)
+}
@@ -0,0 +1,281 @@
+package netcdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+)
+
+// decoded holds a variable read back from a file
+type decoded struct {
+	dims  []int
+	attrs map[string]interface{}
+	typ   ncType
+	data  []byte
+}
+
+// reader decodes the classic format header written by file.header
+type reader struct {
+	b   []byte
+	pos int
+}
+
+// int32 reads a big endian 32-bit integer
+func (r *reader) int32() int32 {
+	v := int32(binary.BigEndian.Uint32(r.b[r.pos:]))
+	r.pos += 4
+	return v
+}
+
+// int64 reads a big endian 64-bit integer
+func (r *reader) int64() int64 {
+	v := int64(binary.BigEndian.Uint64(r.b[r.pos:]))
+	r.pos += 8
+	return v
+}
+
+// name reads a length prefixed name
+func (r *reader) name() string {
+	n := int(r.int32())
+	s := string(r.b[r.pos : r.pos+n])
+	r.pos += n + padding(n)
+	return s
+}
+
+// attrs reads an attribute list
+func (r *reader) attrs() map[string]interface{} {
+	attrs := make(map[string]interface{})
+	if r.int32() == 0 {
+		r.int32()
+		return attrs
+	}
+	n := int(r.int32())
+	for i := 0; i < n; i++ {
+		name := r.name()
+		switch ncType(r.int32()) {
+		case ncChar:
+			attrs[name] = r.name()
+		case ncInt:
+			r.int32()
+			attrs[name] = r.int32()
+		case ncDouble:
+			r.int32()
+			attrs[name] = math.Float64frombits(uint64(r.int64()))
+		}
+	}
+	return attrs
+}
+
+// decode reads back the dimensions, global attributes and variables of a file
+func decode(t *testing.T, b []byte) ([]dimension, map[string]interface{}, map[string]decoded) {
+	t.Helper()
+
+	if string(b[:4]) != "CDF\x02" {
+		t.Fatalf("unexpected magic % x", b[:4])
+	}
+	r := &reader{b: b, pos: 8}
+
+	var dims []dimension
+	if r.int32() != tagDimension {
+		t.Fatal("expected a dimension list")
+	}
+	for n := r.int32(); n > 0; n-- {
+		dims = append(dims, dimension{r.name(), int(r.int32())})
+	}
+
+	global := r.attrs()
+
+	vars := make(map[string]decoded)
+	if r.int32() != tagVariable {
+		t.Fatal("expected a variable list")
+	}
+	for n := r.int32(); n > 0; n-- {
+		name := r.name()
+		var v decoded
+		for d := r.int32(); d > 0; d-- {
+			v.dims = append(v.dims, int(r.int32()))
+		}
+		v.attrs = r.attrs()
+		v.typ = ncType(r.int32())
+		vsize := int(r.int32())
+		begin := int(r.int64())
+		v.data = b[begin : begin+vsize]
+		vars[name] = v
+	}
+	return dims, global, vars
+}
+
+// float returns the i-th double of the data of a variable
+func (v decoded) float(i int) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(v.data[8*i:]))
+}
+
+// TestWriter tests the CF timeSeries layout of a day of temperatures at all the stations.
+func TestWriter(t *testing.T) {
+	var stations meteocat.MetadadesTotesEstacions
+	testutil.Load(t, "metadades_totes_estacions.json", &stations)
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	variables := meteocat.MetadadesVariables{
+		{meteocat.MetadadesVariable{Codi: 32, Nom: "Temperatura", Unitats: "°C", Acronim: "T", Decimals: 1}},
+	}
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, WithStations(stations), WithVariables(variables))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMeasurements(m); err != nil {
+		t.Fatal(err)
+	}
+	// A variable without metadata measured at a single station
+	err = w.Write(export.Row{Estacio: "C6", Variable: 35, Lectura: meteocat.Lectura{Data: "2023-03-12T00:00Z", Valor: 0.2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != errClosed {
+		t.Errorf("expected %v, got %v", errClosed, err)
+	}
+
+	dims, global, vars := decode(t, b.Bytes())
+
+	if dims[0].name != "station" || dims[0].length != len(m) || dims[1].name != "time" || dims[1].length != 48 {
+		t.Errorf("unexpected dimensions: %+v", dims)
+	}
+	if global["featureType"] != "timeSeries" || global["Conventions"] != "CF-1.8" {
+		t.Errorf("unexpected global attributes: %v", global)
+	}
+
+	id := vars["station_id"]
+	if id.attrs["cf_role"] != "timeseries_id" || string(bytes.TrimRight(id.data[:dims[2].length], "\x00")) != "C6" {
+		t.Errorf("unexpected station_id: %v %q", id.attrs, id.data[:dims[2].length])
+	}
+	name := string(bytes.TrimRight(vars["station_name"].data[:dims[3].length], "\x00"))
+	if name != "Castellnou de Seana" {
+		t.Errorf("unexpected first station name %q", name)
+	}
+	if lat, lon := vars["lat"].float(0), vars["lon"].float(0); lat != 41.6566 || lon != 0.95172 {
+		t.Errorf("unexpected coordinates of C6: %v, %v", lat, lon)
+	}
+	if tm := vars["time"]; tm.float(0) != 1678579200 || tm.float(47) != 1678663800 {
+		t.Errorf("unexpected time axis: %v .. %v", tm.float(0), tm.float(47))
+	}
+
+	temp, ok := vars["T"]
+	if !ok {
+		t.Fatal("expected the variable T")
+	}
+	if temp.attrs["units"] != "degree_Celsius" || temp.attrs["long_name"] != "Temperatura" || temp.attrs["codi_variable"] != int32(32) {
+		t.Errorf("unexpected attributes of T: %v", temp.attrs)
+	}
+	if !strings.Contains(temp.attrs["coordinates"].(string), "station_id") {
+		t.Errorf("expected the station_id coordinate, got %v", temp.attrs["coordinates"])
+	}
+	if len(temp.dims) != 2 || temp.dims[0] != 0 || temp.dims[1] != 1 {
+		t.Errorf("expected dimensions (station, time), got %v", temp.dims)
+	}
+	if temp.float(0) != 14.6 || temp.float(47) != 11.3 {
+		t.Errorf("unexpected readings of C6: %v .. %v", temp.float(0), temp.float(47))
+	}
+
+	ppt, ok := vars["var_35"]
+	if !ok {
+		t.Fatal("expected the variable var_35")
+	}
+	if ppt.float(0) != 0.2 || ppt.float(1) != FillValue || ppt.float(48) != FillValue {
+		t.Errorf("expected the fill value for missing readings, got %v %v %v", ppt.float(0), ppt.float(1), ppt.float(48))
+	}
+}
+
+// TestEncodeGolden tests the encoding of a small file against the bytes of the 64-bit offset format specification
+// (https://docs.unidata.ucar.edu/netcdf-c/current/file_format_specifications.html), so the test does not depend on
+// the reader of this file.
+func TestEncodeGolden(t *testing.T) {
+	f := &file{
+		dims:  []dimension{{"station", 2}, {"name_strlen", 3}},
+		attrs: []attribute{{"title", "ab"}},
+		vars: []variable{
+			{name: "id", dims: []int{0, 1}, typ: ncChar, data: chars([]string{"D5", "UG"}, 3)},
+			{
+				name:  "t",
+				dims:  []int{0},
+				attrs: []attribute{{"codi", int32(32)}, {"_FillValue", FillValue}},
+				typ:   ncDouble,
+				data:  doubles([]float64{1.5, -2}),
+			},
+		},
+	}
+
+	want := strings.Join([]string{
+		"CDF\x02",          // magic, 64-bit offset format
+		"\x00\x00\x00\x00", // numrecs
+		// dim_list
+		"\x00\x00\x00\x0a\x00\x00\x00\x02", // NC_DIMENSION, 2 dimensions
+		"\x00\x00\x00\x07station\x00",      // name padded to 4 bytes
+		"\x00\x00\x00\x02",                 // dim_length
+		"\x00\x00\x00\x0bname_strlen\x00",  // name
+		"\x00\x00\x00\x03",                 // dim_length
+		// gatt_list
+		"\x00\x00\x00\x0c\x00\x00\x00\x01",           // NC_ATTRIBUTE, 1 attribute
+		"\x00\x00\x00\x05title\x00\x00\x00",          // name
+		"\x00\x00\x00\x02\x00\x00\x00\x02ab\x00\x00", // NC_CHAR, 2 values padded
+		// var_list
+		"\x00\x00\x00\x0b\x00\x00\x00\x02",                                 // NC_VARIABLE, 2 variables
+		"\x00\x00\x00\x02id\x00\x00",                                       // name
+		"\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x01",                 // 2 dimensions, ids 0 and 1
+		"\x00\x00\x00\x00\x00\x00\x00\x00",                                 // vatt_list ABSENT
+		"\x00\x00\x00\x02",                                                 // NC_CHAR
+		"\x00\x00\x00\x08",                                                 // vsize, 6 bytes padded to 8
+		"\x00\x00\x00\x00\x00\x00\x00\xe4",                                 // begin at 228, the header size
+		"\x00\x00\x00\x01t\x00\x00\x00",                                    // name
+		"\x00\x00\x00\x01\x00\x00\x00\x00",                                 // 1 dimension, id 0
+		"\x00\x00\x00\x0c\x00\x00\x00\x02",                                 // NC_ATTRIBUTE, 2 attributes
+		"\x00\x00\x00\x04codi",                                             // name
+		"\x00\x00\x00\x04\x00\x00\x00\x01\x00\x00\x00\x20",                 // NC_INT, 1 value, 32
+		"\x00\x00\x00\x0a_FillValue\x00\x00",                               // name
+		"\x00\x00\x00\x06\x00\x00\x00\x01\x47\x9e\x00\x00\x00\x00\x00\x00", // NC_DOUBLE, 1 value, NC_FILL_DOUBLE
+		"\x00\x00\x00\x06",                                                 // NC_DOUBLE
+		"\x00\x00\x00\x10",                                                 // vsize
+		"\x00\x00\x00\x00\x00\x00\x00\xec",                                 // begin at 236
+		// data
+		"D5\x00UG\x00\x00\x00",             // id padded to 4 bytes
+		"\x3f\xf8\x00\x00\x00\x00\x00\x00", // 1.5
+		"\xc0\x00\x00\x00\x00\x00\x00\x00", // -2
+	}, "")
+
+	var b bytes.Buffer
+	if err := f.encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("expected\n% x\ngot\n% x", want, got)
+	}
+}
+
+// TestWriterNoReadings tests that an empty file is rejected.
+func TestWriterNoReadings(t *testing.T) {
+	w, _ := NewWriter(ioutil.Discard)
+	if err := w.Close(); err != errNoReadings {
+		t.Errorf("expected %v, got %v", errNoReadings, err)
+	}
+}
+
+// TestValidName tests the variable names accepted from the acronyms.
+func TestValidName(t *testing.T) {
+	for s, want := range map[string]bool{"T": true, "VV10": true, "HR_max": true, "": false, "10VV": false, "PPT%": false} {
+		if validName(s) != want {
+			t.Errorf("validName(%q) = %v, want %v", s, !want, want)
+		}
+	}
+}
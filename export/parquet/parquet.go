@@ -0,0 +1,212 @@
+// Package parquet writes measurement series as Apache Parquet files with a stable schema, so the archive can be
+// queried directly with DuckDB or Spark. Files are encoded with github.com/parquet-go/parquet-go.
+//
+// Every file holds the columns codi_estacio, codi_variable, data (UTC timestamp in milliseconds), valor, estat and
+// base_horaria. The station metadata columns nom_estacio, municipi, comarca, provincia, latitud, longitud and
+// altitud are added when the writer is given the station catalogue with WithStations.
+package parquet
+
+import (
+	"errors"
+	"io"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// Codec is the compression codec of the column chunks
+type Codec int32
+
+// Supported compression codecs
+const (
+	Uncompressed Codec = 0
+	Gzip         Codec = 2
+)
+
+// DefaultRowGroupSize is the number of rows of a row group when no size is configured
+const DefaultRowGroupSize = 128 * 1024
+
+var errClosed = errors.New("writer closed")
+var errInvalidOption = errors.New("invalid option")
+
+// measurementRow holds the columns always present in the files
+type measurementRow struct {
+	CodiEstacio  string  `parquet:"codi_estacio"`
+	CodiVariable int32   `parquet:"codi_variable"`
+	Data         int64   `parquet:"data,timestamp(millisecond)"`
+	Valor        float64 `parquet:"valor"`
+	Estat        string  `parquet:"estat"`
+	BaseHoraria  string  `parquet:"base_horaria"`
+}
+
+// stationRow holds the columns of the files written with the station metadata
+type stationRow struct {
+	CodiEstacio  string  `parquet:"codi_estacio"`
+	CodiVariable int32   `parquet:"codi_variable"`
+	Data         int64   `parquet:"data,timestamp(millisecond)"`
+	Valor        float64 `parquet:"valor"`
+	Estat        string  `parquet:"estat"`
+	BaseHoraria  string  `parquet:"base_horaria"`
+	NomEstacio   string  `parquet:"nom_estacio"`
+	Municipi     string  `parquet:"municipi"`
+	Comarca      string  `parquet:"comarca"`
+	Provincia    string  `parquet:"provincia"`
+	Latitud      float64 `parquet:"latitud"`
+	Longitud     float64 `parquet:"longitud"`
+	Altitud      float64 `parquet:"altitud"`
+}
+
+// Writer writes readings to a Parquet file. Rows are buffered until a row group is full.
+type Writer struct {
+	pw           *parquet.Writer
+	codec        Codec
+	rowGroupSize int
+	stations     map[string]meteocat.MetadadesEstacions
+	closed       bool
+}
+
+// Option configures a Writer
+type Option func(w *Writer) error
+
+// WithRowGroupSize sets the number of rows of each row group.
+func WithRowGroupSize(rows int) Option {
+	return func(w *Writer) error {
+		if rows <= 0 {
+			return errInvalidOption
+		}
+		w.rowGroupSize = rows
+		return nil
+	}
+}
+
+// WithCodec sets the compression codec of the column chunks, Gzip by default.
+func WithCodec(c Codec) Option {
+	return func(w *Writer) error {
+		if c != Uncompressed && c != Gzip {
+			return errInvalidOption
+		}
+		w.codec = c
+		return nil
+	}
+}
+
+// WithStations adds the station metadata columns, joined by station code.
+func WithStations(stations meteocat.MetadadesTotesEstacions) Option {
+	return func(w *Writer) error {
+		w.stations = make(map[string]meteocat.MetadadesEstacions, len(stations))
+		for _, e := range stations {
+			w.stations[e.Codi] = e
+		}
+		return nil
+	}
+}
+
+// NewWriter returns a new Writer pointer which writes a Parquet file to out. Close must be called to write the
+// file footer.
+func NewWriter(out io.Writer, options ...Option) (*Writer, error) {
+	w := &Writer{
+		codec:        Gzip,
+		rowGroupSize: DefaultRowGroupSize,
+	}
+
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(w); err != nil {
+			return nil, err
+		}
+	}
+
+	var codec compress.Codec = &parquet.Gzip
+	if w.codec == Uncompressed {
+		codec = &parquet.Uncompressed
+	}
+	var schema *parquet.Schema
+	if w.stations != nil {
+		schema = parquet.SchemaOf(stationRow{})
+	} else {
+		schema = parquet.SchemaOf(measurementRow{})
+	}
+	w.pw = parquet.NewWriter(out,
+		schema,
+		parquet.Compression(codec),
+		parquet.MaxRowsPerRowGroup(int64(w.rowGroupSize)),
+		parquet.CreatedBy("github.com/oscaromeu/meteocat", "", ""),
+	)
+	return w, nil
+}
+
+// Write adds a reading to the current row group, flushing it once it is full
+func (w *Writer) Write(r export.Row) error {
+	if w.closed {
+		return errClosed
+	}
+
+	t, err := meteocat.ParseData(r.Lectura.Data)
+	if err != nil {
+		return err
+	}
+
+	m := measurementRow{
+		CodiEstacio:  r.Estacio,
+		CodiVariable: int32(r.Variable),
+		Data:         t.UnixNano() / 1e6,
+		Valor:        r.Lectura.Valor,
+		Estat:        r.Lectura.Estat,
+		BaseHoraria:  r.Lectura.BaseHoraria,
+	}
+	if w.stations == nil {
+		return w.pw.Write(&m)
+	}
+
+	e := w.stations[r.Estacio]
+	return w.pw.Write(&stationRow{
+		CodiEstacio:  m.CodiEstacio,
+		CodiVariable: m.CodiVariable,
+		Data:         m.Data,
+		Valor:        m.Valor,
+		Estat:        m.Estat,
+		BaseHoraria:  m.BaseHoraria,
+		NomEstacio:   e.Nom,
+		Municipi:     e.Municipi.Nom,
+		Comarca:      e.Comarca.Nom,
+		Provincia:    e.Provincia.Nom,
+		Latitud:      e.Coordenades.Latitud,
+		Longitud:     e.Coordenades.Longitud,
+		Altitud:      e.Altitud,
+	})
+}
+
+// WriteVariable writes the readings of a variable measured at a station
+func (w *Writer) WriteVariable(estacio string, v meteocat.Variable) error {
+	for _, l := range v.Lectures {
+		if err := w.Write(export.Row{Estacio: estacio, Variable: v.Codi, Lectura: l}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMeasurements writes the readings of all the stations and variables of m
+func (w *Writer) WriteMeasurements(m meteocat.Measurements) error {
+	for _, st := range m {
+		for _, v := range st.Variables {
+			if err := w.WriteVariable(st.Codi, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close flushes the buffered rows and writes the file footer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errClosed
+	}
+	w.closed = true
+	return w.pw.Close()
+}
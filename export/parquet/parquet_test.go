@@ -0,0 +1,249 @@
+package parquet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+	"github.com/parquet-go/parquet-go"
+)
+
+// readBack decodes a Parquet file with an independent reader and returns its column names and its rows
+func readBack(t *testing.T, data []byte) ([]string, []export.Row) {
+	t.Helper()
+
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range f.Schema().Columns() {
+		names = append(names, strings.Join(c, "."))
+	}
+	index := func(name string) int {
+		c, ok := f.Schema().Lookup(name)
+		if !ok {
+			t.Fatalf("column %s missing", name)
+		}
+		return c.ColumnIndex
+	}
+	estacio, variable, ts, valor, estat, base := index("codi_estacio"), index("codi_variable"), index("data"), index("valor"), index("estat"), index("base_horaria")
+
+	var rows []export.Row
+	r := parquet.NewReader(f)
+	defer r.Close()
+	buf := make([]parquet.Row, 16)
+	for {
+		n, err := r.ReadRows(buf)
+		for _, row := range buf[:n] {
+			rows = append(rows, export.Row{
+				Estacio:  string(row[estacio].ByteArray()),
+				Variable: int(row[variable].Int32()),
+				Lectura: meteocat.Lectura{
+					Data:        time.UnixMilli(row[ts].Int64()).UTC().Format("2006-01-02T15:04Z"),
+					Valor:       row[valor].Double(),
+					Estat:       string(row[estat].ByteArray()),
+					BaseHoraria: string(row[base].ByteArray()),
+				},
+			})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if int64(len(rows)) != f.NumRows() {
+		t.Errorf("expected %d rows, read %d", f.NumRows(), len(rows))
+	}
+	return names, rows
+}
+
+// TestWriter tests the layout of the file: magic bytes, row groups and the station metadata columns.
+func TestWriter(t *testing.T) {
+	var v meteocat.Variable
+	testutil.Load(t, "dades_variable_32_estacio_D5.json", &v)
+	var e meteocat.MetadadesEstacions
+	testutil.Load(t, "metadades_estacio_D5.json", &e)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, WithCodec(Uncompressed), WithRowGroupSize(20), WithStations(meteocat.MetadadesTotesEstacions{e}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteVariable("D5", v); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(export.Row{}); err != errClosed {
+		t.Errorf("expected %v, got %v", errClosed, err)
+	}
+
+	data := b.Bytes()
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatal("missing magic bytes")
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.RowGroups()); n != 3 || f.NumRows() != int64(len(v.Lectures)) {
+		t.Errorf("expected 3 row groups and %d rows, got %d and %d", len(v.Lectures), n, f.NumRows())
+	}
+
+	_, rows := readBack(t, data)
+	if len(rows) != len(v.Lectures) {
+		t.Fatalf("expected %d rows, got %d", len(v.Lectures), len(rows))
+	}
+	column, _ := f.Schema().Lookup("nom_estacio")
+	r := parquet.NewReader(f)
+	defer r.Close()
+	row := make([]parquet.Row, 1)
+	if n, _ := r.ReadRows(row); n != 1 || string(row[0][column.ColumnIndex].ByteArray()) != "Barcelona - Observatori Fabra" {
+		t.Errorf("expected the station name in the rows, got %v", row[0])
+	}
+}
+
+// TestReadBack tests that an independent reader decodes the schema and the values of the files, with both codecs.
+func TestReadBack(t *testing.T) {
+	var v meteocat.Variable
+	testutil.Load(t, "dades_variable_32_estacio_D5.json", &v)
+	var e meteocat.MetadadesEstacions
+	testutil.Load(t, "metadades_estacio_D5.json", &e)
+
+	for _, codec := range []Codec{Uncompressed, Gzip} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, WithCodec(codec), WithRowGroupSize(20), WithStations(meteocat.MetadadesTotesEstacions{e}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteVariable("D5", v); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		names, rows := readBack(t, b.Bytes())
+		want := "codi_estacio codi_variable data valor estat base_horaria nom_estacio municipi comarca provincia latitud longitud altitud"
+		if strings.Join(names, " ") != want {
+			t.Errorf("codec %d: unexpected schema %v", codec, names)
+		}
+		if len(rows) != len(v.Lectures) {
+			t.Fatalf("codec %d: expected %d rows, got %d", codec, len(v.Lectures), len(rows))
+		}
+		for i, r := range rows {
+			l := v.Lectures[i]
+			if r.Estacio != "D5" || r.Variable != 32 || r.Lectura != l {
+				t.Errorf("codec %d: row %d: expected %+v, got %+v", codec, i, l, r)
+				break
+			}
+		}
+	}
+}
+
+// TestPartitionedWriter tests the Hive layout of the partitioned archive.
+func TestPartitionedWriter(t *testing.T) {
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	dir, err := ioutil.TempDir("", "meteocat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pw, err := NewPartitionedWriter(dir, "part", []Partition{PartitionYear, PartitionMonth, PartitionStation}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WriteMeasurements(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := pw.Files()
+	if len(files) != len(m) {
+		t.Fatalf("expected a file per station, got %d", len(files))
+	}
+	want := filepath.Join(dir, "any=2023", "mes=03", "estacio=C6", "part-0.parquet")
+	if _, err := os.Stat(want); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPartitionedWriterMaxOpen tests that the open files are bounded, new files being started for the partitions
+// written again, and that no reading is lost.
+func TestPartitionedWriterMaxOpen(t *testing.T) {
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+	m = m[:3]
+
+	dir, err := ioutil.TempDir("", "meteocat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pw, err := NewPartitionedWriter(dir, "part", []Partition{PartitionStation}, 2, WithCodec(Uncompressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Interleave the stations, so every reading of the third station closes the least recently used file
+	total := 0
+	for i := 0; i < 3; i++ {
+		for _, st := range m {
+			for _, v := range st.Variables {
+				for _, l := range v.Lectures[i*2 : i*2+2] {
+					if err := pw.Write(export.Row{Estacio: st.Codi, Variable: v.Codi, Lectura: l}); err != nil {
+						t.Fatal(err)
+					}
+					total++
+				}
+			}
+			if len(pw.open) > 2 {
+				t.Fatalf("expected at most 2 open files, got %d", len(pw.open))
+			}
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := pw.Files()
+	if len(files) != 9 {
+		t.Fatalf("expected 3 files per station, got %v", files)
+	}
+	if want := filepath.Join(dir, "estacio="+m[0].Codi, "part-2.parquet"); files[2] != want {
+		t.Errorf("expected %s, got %s", want, files[2])
+	}
+	n := 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rows := readBack(t, data)
+		n += len(rows)
+	}
+	if n != total {
+		t.Errorf("expected %d readings, read %d", total, n)
+	}
+
+	if _, err := NewPartitionedWriter(dir, "part", nil, -1); err != errInvalidOption {
+		t.Errorf("expected %v, got %v", errInvalidOption, err)
+	}
+}
@@ -0,0 +1,193 @@
+package parquet
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// Partition is a key used to split the archive in directories
+type Partition int
+
+// Supported partition keys. The directories follow the Hive layout, e.g any=2023/mes=03/estacio=D5, which DuckDB
+// and Spark use to prune the files of a query.
+const (
+	PartitionYear Partition = iota
+	PartitionMonth
+	PartitionStation
+)
+
+// dir returns the directory name of the partition for a reading
+func (p Partition) dir(r export.Row) (string, error) {
+	switch p {
+	case PartitionYear, PartitionMonth:
+		t, err := meteocat.ParseData(r.Lectura.Data)
+		if err != nil {
+			return "", err
+		}
+		if p == PartitionYear {
+			return fmt.Sprintf("any=%04d", t.Year()), nil
+		}
+		return fmt.Sprintf("mes=%02d", t.Month()), nil
+	case PartitionStation:
+		return "estacio=" + r.Estacio, nil
+	}
+	return "", errInvalidOption
+}
+
+// DefaultMaxOpenFiles is the number of partition files a PartitionedWriter keeps open when no limit is given
+const DefaultMaxOpenFiles = 32
+
+// partitionFile holds an open file of a partition
+type partitionFile struct {
+	path string // Directory of the partition
+	f    *os.File
+	w    *Writer
+	elem *list.Element // Element of the file in the recently used list
+}
+
+// PartitionedWriter writes readings to a directory tree of Parquet files. At most maxOpen files are open at once:
+// when a reading belongs to a partition without an open file, the least recently used file is closed and the
+// partition gets a new file. Readings sorted by partition, e.g by station and time, give a file per partition.
+type PartitionedWriter struct {
+	dir        string
+	prefix     string
+	partitions []Partition
+	options    []Option
+	maxOpen    int
+	open       map[string]*partitionFile // Open files by partition directory
+	lru        *list.List                // Open files from the most to the least recently used
+	parts      map[string]int            // Number of files started in each partition directory
+	files      []string                  // Paths of the files started so far
+}
+
+// NewPartitionedWriter returns a new PartitionedWriter pointer which writes to dir, splitting the readings by the
+// given partitions in order. Each partition directory holds files called prefix-N.parquet, e.g part-0.parquet,
+// written with the given options. At most maxOpen files, each buffering a row group, are open at once, or
+// DefaultMaxOpenFiles when maxOpen is zero.
+func NewPartitionedWriter(dir, prefix string, partitions []Partition, maxOpen int, options ...Option) (*PartitionedWriter, error) {
+	for _, p := range partitions {
+		if p < PartitionYear || p > PartitionStation {
+			return nil, errInvalidOption
+		}
+	}
+	if maxOpen < 0 || prefix == "" {
+		return nil, errInvalidOption
+	}
+	if maxOpen == 0 {
+		maxOpen = DefaultMaxOpenFiles
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &PartitionedWriter{
+		dir:        dir,
+		prefix:     prefix,
+		partitions: partitions,
+		options:    options,
+		maxOpen:    maxOpen,
+		open:       make(map[string]*partitionFile),
+		lru:        list.New(),
+		parts:      make(map[string]int),
+	}, nil
+}
+
+// closeFile writes the footer of an open file and closes it
+func (pw *PartitionedWriter) closeFile(pf *partitionFile) error {
+	pw.lru.Remove(pf.elem)
+	delete(pw.open, pf.path)
+	err := pf.w.Close()
+	if cerr := pf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Write adds a reading to the open file of its partition, starting a new file if there is none
+func (pw *PartitionedWriter) Write(r export.Row) error {
+	path := pw.dir
+	for _, p := range pw.partitions {
+		d, err := p.dir(r)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(path, d)
+	}
+
+	pf, ok := pw.open[path]
+	if ok {
+		pw.lru.MoveToFront(pf.elem)
+		return pf.w.Write(r)
+	}
+
+	if pw.lru.Len() >= pw.maxOpen {
+		if err := pw.closeFile(pw.lru.Back().Value.(*partitionFile)); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(path, fmt.Sprintf("%s-%d.parquet", pw.prefix, pw.parts[path]))
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(f, pw.options...)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	pw.parts[path]++
+	pw.files = append(pw.files, name)
+	pf = &partitionFile{path: path, f: f, w: w}
+	pf.elem = pw.lru.PushFront(pf)
+	pw.open[path] = pf
+
+	return pf.w.Write(r)
+}
+
+// WriteVariable writes the readings of a variable measured at a station
+func (pw *PartitionedWriter) WriteVariable(estacio string, v meteocat.Variable) error {
+	for _, l := range v.Lectures {
+		if err := pw.Write(export.Row{Estacio: estacio, Variable: v.Codi, Lectura: l}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMeasurements writes the readings of all the stations and variables of m
+func (pw *PartitionedWriter) WriteMeasurements(m meteocat.Measurements) error {
+	for _, st := range m {
+		for _, v := range st.Variables {
+			if err := pw.WriteVariable(st.Codi, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Files returns the paths of the files written so far
+func (pw *PartitionedWriter) Files() []string {
+	files := append([]string{}, pw.files...)
+	sort.Strings(files)
+	return files
+}
+
+// Close writes the footer of every open file and closes them
+func (pw *PartitionedWriter) Close() error {
+	var first error
+	for pw.lru.Len() > 0 {
+		if err := pw.closeFile(pw.lru.Front().Value.(*partitionFile)); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,219 @@
+// Package graphql serves a GraphQL API over the XEMA stations, variables and measurements, so a frontend can ask for
+// e.g the stations of a comarca with their latest temperature and humidity in a single query:
+//
+//	{
+//	  stations(comarca: "Osona") {
+//	    codi nom
+//	    latest(variables: [32, 33]) { valor data variable { acronim unitats } }
+//	  }
+//	}
+//
+// Resolvers are backed by the clients of the meteocat package. Every API response is loaded once per query and the
+// readings are requested for all the stations at once, so a query over N stations and M variables sends M calls
+// instead of N×M. Pass meteocat.WithCache to NewServer to share the responses between queries.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	gql "github.com/graphql-go/graphql"
+	"github.com/oscaromeu/meteocat"
+)
+
+// Server executes GraphQL queries against the Meteocat API. It is safe for concurrent use.
+type Server struct {
+	key     string
+	options []meteocat.Option
+	schema  gql.Schema
+}
+
+// NewServer returns a new Server pointer which calls the API with key and the client options
+func NewServer(key string, options ...meteocat.Option) (*Server, error) {
+	schema, err := newSchema()
+	if err != nil {
+		return nil, err
+	}
+	return &Server{key: key, options: options, schema: schema}, nil
+}
+
+// Do executes a query with its variables
+func (s *Server) Do(ctx context.Context, query string, variables map[string]interface{}) *gql.Result {
+	return s.do(ctx, request{Query: query, Variables: variables})
+}
+
+// request is the body of a GraphQL request over HTTP
+type request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// do executes a request with a new loader
+func (s *Server) do(ctx context.Context, req request) *gql.Result {
+	l := &loader{key: s.key, options: s.options, results: make(map[string]*result)}
+	return gql.Do(gql.Params{
+		Schema:         s.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        context.WithValue(ctx, loaderKey{}, l),
+	})
+}
+
+// ServeHTTP implements the http.Handler interface. Queries are accepted as a JSON body on POST requests and in
+// the query parameter on GET requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	switch r.Method {
+	case "GET":
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case "POST":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.do(r.Context(), req))
+}
+
+// loaderKey is the context key of the loader of a query
+type loaderKey struct{}
+
+// result is an API response loaded once per query
+type result struct {
+	once sync.Once
+	v    interface{}
+	err  error
+}
+
+// loader loads the API responses needed by the resolvers of a query, each of them once
+type loader struct {
+	key     string
+	options []meteocat.Option
+
+	mu      sync.Mutex
+	results map[string]*result
+}
+
+// loaderFrom returns the loader of the query of ctx
+func loaderFrom(ctx context.Context) *loader {
+	return ctx.Value(loaderKey{}).(*loader)
+}
+
+// load returns the value of key, calling fetch the first time it is requested
+func (l *loader) load(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	l.mu.Lock()
+	r, ok := l.results[key]
+	if !ok {
+		r = &result{}
+		l.results[key] = r
+	}
+	l.mu.Unlock()
+
+	r.once.Do(func() { r.v, r.err = fetch() })
+	return r.v, r.err
+}
+
+// stations returns the metadata of all the stations
+func (l *loader) stations() (meteocat.MetadadesTotesEstacions, error) {
+	v, err := l.load("estacions", func() (interface{}, error) {
+		e, err := meteocat.NewEstacions(l.key, l.options...)
+		if err != nil {
+			return nil, err
+		}
+		p, _ := meteocat.NewParameters()
+		if err := e.StationsAll(p); err != nil {
+			return nil, err
+		}
+		return e.MetadadesTotesEstacions, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(meteocat.MetadadesTotesEstacions), nil
+}
+
+// variables returns the metadata of all the variables by code
+func (l *loader) variables() (map[int]meteocat.MetadadesVariable, error) {
+	v, err := l.load("variables", func() (interface{}, error) {
+		m, err := meteocat.NewMesurades(l.key, l.options...)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.MeasurementMetadataAll(); err != nil {
+			return nil, err
+		}
+		variables := make(map[int]meteocat.MetadadesVariable, len(m.MetadadesVariables))
+		for _, v := range m.MetadadesVariables {
+			variables[v.Codi] = v.MetadadesVariable
+		}
+		return variables, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[int]meteocat.MetadadesVariable), nil
+}
+
+// readings returns the readings of a variable at all the stations by station code, those of the day date
+// (YYYY-MM-DD) or the last ones when date is empty
+func (l *loader) readings(codi int, date string) (map[string][]meteocat.Lectura, error) {
+	var data meteocat.Data
+	if date != "" {
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+		}
+		data = meteocat.Data{Any: d.Format("2006"), Mes: d.Format("01"), Dia: d.Format("02")}
+	}
+
+	v, err := l.load(fmt.Sprintf("lectures/%d/%s", codi, date), func() (interface{}, error) {
+		m, err := meteocat.NewMesurades(l.key, l.options...)
+		if err != nil {
+			return nil, err
+		}
+		if date == "" {
+			p, _ := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)))
+			err = m.MeasurementLast(p)
+		} else {
+			p, _ := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)), meteocat.OptionData(data))
+			err = m.MeasurementByDay(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		readings := make(map[string][]meteocat.Lectura, len(m.Measurements))
+		for _, st := range m.Measurements {
+			for _, v := range st.Variables {
+				if v.Codi == codi {
+					readings[st.Codi] = append(readings[st.Codi], v.Lectures...)
+				}
+			}
+		}
+		return readings, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string][]meteocat.Lectura), nil
+}
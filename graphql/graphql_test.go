@@ -0,0 +1,226 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// newAPIServer starts a test server which answers the XEMA paths with testdata fixtures, counting the requests by
+// path. The readings of humidity are those of temperature relabelled.
+func newAPIServer(t *testing.T) (*httptest.Server, map[string]int) {
+	t.Helper()
+
+	routes := map[string]string{
+		"/xema/v1/estacions/metadades":               "metadades_totes_estacions.json",
+		"/xema/v1/variables/mesurades/metadades":     "variables_mesurades_metadades.json",
+		"/xema/v1/variables/mesurades/32/ultimes":    "mesurades_dia_totes_estacions.json",
+		"/xema/v1/variables/mesurades/33/ultimes":    "mesurades_dia_totes_estacions.json",
+		"/xema/v1/variables/mesurades/32/2023/03/12": "mesurades_dia_totes_estacions.json",
+	}
+	hits := make(map[string]int)
+	var mu sync.Mutex
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+
+		fixture, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := ioutil.ReadFile("../testdata/" + fixture)
+		if err != nil {
+			t.Error(err)
+		}
+		if strings.Contains(r.URL.Path, "/33/") {
+			body = bytes.ReplaceAll(body, []byte(`"codi": 32,`), []byte(`"codi": 33,`))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	return srv, hits
+}
+
+// TestStationsLatest tests that the latest readings of the stations of a comarca are resolved with one API call
+// per variable.
+func TestStationsLatest(t *testing.T) {
+	srv, hits := newAPIServer(t)
+	defer srv.Close()
+
+	s, err := NewServer("", meteocat.WithBaseURL(srv.URL+"/xema/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := s.Do(context.Background(), `{
+		stations(comarca: "osona") {
+			codi
+			comarca
+			latest(variables: [32, 33]) { valor data variable { acronim unitats } }
+		}
+	}`, nil)
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	b, _ := json.Marshal(res.Data)
+	var data struct {
+		Stations []struct {
+			Codi    string
+			Comarca string
+			Latest  []struct {
+				Valor    float64
+				Data     string
+				Variable struct{ Acronim, Unitats string }
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data.Stations) != 7 {
+		t.Fatalf("expected 7 stations in Osona, got %d", len(data.Stations))
+	}
+	cc := data.Stations[0]
+	if cc.Codi != "CC" || len(cc.Latest) != 2 {
+		t.Fatalf("unexpected station %+v", cc)
+	}
+	if cc.Latest[0].Data != "2023-03-12T23:30Z" || cc.Latest[0].Variable.Acronim != "T" || cc.Latest[1].Variable.Acronim != "HR" {
+		t.Errorf("unexpected readings %+v", cc.Latest)
+	}
+	for _, st := range data.Stations {
+		if st.Codi == "KE" && len(st.Latest) != 0 {
+			t.Errorf("expected no readings at KE, got %+v", st.Latest)
+		}
+	}
+
+	for path, n := range hits {
+		if n != 1 {
+			t.Errorf("%s: expected 1 request, got %d", path, n)
+		}
+	}
+	if len(hits) != 4 {
+		t.Errorf("expected 4 API calls, got %v", hits)
+	}
+}
+
+// TestDoParallel tests that concurrent queries get the readings of their own variables. Run it with -race.
+func TestDoParallel(t *testing.T) {
+	srv, _ := newAPIServer(t)
+	defer srv.Close()
+
+	s, err := NewServer("", meteocat.WithBaseURL(srv.URL+"/xema/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(variable int, acronim string) {
+			defer wg.Done()
+			res := s.Do(context.Background(), `query($v: Int!) {
+				stations(comarca: "osona") { codi latest(variables: [$v]) { variable { acronim } } }
+			}`, map[string]interface{}{"v": variable})
+			if len(res.Errors) > 0 {
+				t.Error(res.Errors)
+				return
+			}
+
+			b, _ := json.Marshal(res.Data)
+			var data struct {
+				Stations []struct {
+					Codi   string
+					Latest []struct{ Variable struct{ Acronim string } }
+				}
+			}
+			if err := json.Unmarshal(b, &data); err != nil {
+				t.Error(err)
+				return
+			}
+			if len(data.Stations) == 0 || len(data.Stations[0].Latest) != 1 {
+				t.Errorf("variable %d: unexpected stations %+v", variable, data.Stations)
+				return
+			}
+			for _, st := range data.Stations {
+				for _, l := range st.Latest {
+					if l.Variable.Acronim != acronim {
+						t.Errorf("variable %d: unexpected reading of %s at %s", variable, l.Variable.Acronim, st.Codi)
+					}
+				}
+			}
+		}(32+i%2, []string{"T", "HR"}[i%2])
+	}
+	wg.Wait()
+}
+
+// TestHTTP tests the GET and POST requests of the handler.
+func TestHTTP(t *testing.T) {
+	srv, _ := newAPIServer(t)
+	defer srv.Close()
+
+	s, err := NewServer("", meteocat.WithBaseURL(srv.URL+"/xema/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type response struct {
+		Data struct {
+			Station *struct {
+				Nom      string
+				Readings []struct{ Valor float64 }
+			}
+			Variable *struct{ Nom string }
+		}
+		Errors []struct{ Message string }
+	}
+	decode := func(w *httptest.ResponseRecorder) response {
+		var r response
+		if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	body := `{"query": "query($codi: String!) { station(codi: $codi) { nom readings(variables: [32], date: \"2023-03-12\") { valor } } }", "variables": {"codi": "d5"}}`
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	r := decode(w)
+	if len(r.Errors) > 0 || r.Data.Station == nil {
+		t.Fatalf("unexpected response %+v", r)
+	}
+	if r.Data.Station.Nom != "Barcelona - Observatori Fabra" || len(r.Data.Station.Readings) != 48 {
+		t.Errorf("unexpected station %s with %d readings", r.Data.Station.Nom, len(r.Data.Station.Readings))
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/?query="+url.QueryEscape("{ variable(codi: 33) { nom } }"), nil))
+	if r := decode(w); r.Data.Variable == nil || r.Data.Variable.Nom != "Humitat relativa" {
+		t.Errorf("unexpected response %+v", r)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/?query="+url.QueryEscape(`{ station(codi: "D5") { readings(variables: [32], date: "12/03/2023") { valor } } }`), nil))
+	if r := decode(w); len(r.Errors) != 1 || !strings.Contains(r.Errors[0].Message, "invalid date") {
+		t.Errorf("expected an invalid date error, got %+v", r)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("DELETE", "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
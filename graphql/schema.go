@@ -0,0 +1,280 @@
+package graphql
+
+import (
+	"sort"
+	"strings"
+
+	gql "github.com/graphql-go/graphql"
+	"github.com/oscaromeu/meteocat"
+)
+
+// reading is the source of the Reading type, a Lectura with its station and variable
+type reading struct {
+	estacio  string
+	variable int
+	meteocat.Lectura
+}
+
+// stationField returns a field of type t resolved by f from a station
+func stationField(t gql.Output, description string, f func(s meteocat.MetadadesEstacions) interface{}) *gql.Field {
+	return &gql.Field{
+		Type:        t,
+		Description: description,
+		Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return f(p.Source.(meteocat.MetadadesEstacions)), nil
+		},
+	}
+}
+
+// variableField returns a field of type t resolved by f from a variable
+func variableField(t gql.Output, description string, f func(v meteocat.MetadadesVariable) interface{}) *gql.Field {
+	return &gql.Field{
+		Type:        t,
+		Description: description,
+		Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return f(p.Source.(meteocat.MetadadesVariable)), nil
+		},
+	}
+}
+
+// readingField returns a field of type t resolved by f from a reading
+func readingField(t gql.Output, description string, f func(r reading) interface{}) *gql.Field {
+	return &gql.Field{
+		Type:        t,
+		Description: description,
+		Resolve: func(p gql.ResolveParams) (interface{}, error) {
+			return f(p.Source.(reading)), nil
+		},
+	}
+}
+
+// nonNull wraps t as a non null type
+func nonNull(t gql.Type) gql.Output {
+	return gql.NewNonNull(t)
+}
+
+// codes returns the integers of a list argument
+func codes(arg interface{}) []int {
+	var c []int
+	list, _ := arg.([]interface{})
+	for _, v := range list {
+		if n, ok := v.(int); ok {
+			c = append(c, n)
+		}
+	}
+	return c
+}
+
+// resolveReadings resolves the readings of a station of the variables of the field arguments, the last ones or
+// those of the date argument
+func resolveReadings(p gql.ResolveParams) (interface{}, error) {
+	st := p.Source.(meteocat.MetadadesEstacions)
+	date, _ := p.Args["date"].(string)
+	l := loaderFrom(p.Context)
+
+	readings := []reading{}
+	for _, codi := range codes(p.Args["variables"]) {
+		byStation, err := l.readings(codi, date)
+		if err != nil {
+			return nil, err
+		}
+		lectures := byStation[st.Codi]
+		if date == "" && len(lectures) > 0 {
+			lectures = lectures[len(lectures)-1:]
+		}
+		for _, lectura := range lectures {
+			readings = append(readings, reading{estacio: st.Codi, variable: codi, Lectura: lectura})
+		}
+	}
+	return readings, nil
+}
+
+// matches reports whether the station matches the filters of the stations field arguments
+func matches(st meteocat.MetadadesEstacions, args map[string]interface{}) bool {
+	filters := []struct {
+		arg   string
+		value string
+	}{
+		{"comarca", st.Comarca.Nom},
+		{"municipi", st.Municipi.Nom},
+		{"provincia", st.Provincia.Nom},
+		{"estat", st.EstatActual()},
+	}
+	for _, f := range filters {
+		if v, ok := args[f.arg].(string); ok && !strings.EqualFold(v, f.value) {
+			return false
+		}
+	}
+	if list, ok := args["codis"].([]interface{}); ok {
+		for _, c := range list {
+			if s, _ := c.(string); strings.EqualFold(s, st.Codi) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// newSchema returns the schema of the API
+func newSchema() (gql.Schema, error) {
+	variable := gql.NewObject(gql.ObjectConfig{
+		Name:        "Variable",
+		Description: "A variable measured by the stations of the XEMA",
+		Fields: gql.Fields{
+			"codi":     variableField(nonNull(gql.Int), "Code of the variable", func(v meteocat.MetadadesVariable) interface{} { return v.Codi }),
+			"nom":      variableField(nonNull(gql.String), "Name of the variable", func(v meteocat.MetadadesVariable) interface{} { return v.Nom }),
+			"unitats":  variableField(gql.String, "Unit of measurement", func(v meteocat.MetadadesVariable) interface{} { return v.Unitats }),
+			"acronim":  variableField(gql.String, "Acronym of the variable", func(v meteocat.MetadadesVariable) interface{} { return v.Acronim }),
+			"tipus":    variableField(gql.String, "Type of the variable", func(v meteocat.MetadadesVariable) interface{} { return v.Tipus }),
+			"decimals": variableField(gql.Int, "Number of decimals of the readings", func(v meteocat.MetadadesVariable) interface{} { return v.Decimals }),
+		},
+	})
+
+	readingType := gql.NewObject(gql.ObjectConfig{
+		Name:        "Reading",
+		Description: "A reading of a variable at a station",
+		Fields: gql.Fields{
+			"estacio":      readingField(nonNull(gql.String), "Code of the station", func(r reading) interface{} { return r.estacio }),
+			"codiVariable": readingField(nonNull(gql.Int), "Code of the variable", func(r reading) interface{} { return r.variable }),
+			"data":         readingField(nonNull(gql.String), "Time of the reading, e.g 2023-03-12T10:00Z", func(r reading) interface{} { return r.Data }),
+			"valor":        readingField(nonNull(gql.Float), "Value of the reading", func(r reading) interface{} { return r.Valor }),
+			"estat":        readingField(gql.String, "Validation state of the reading", func(r reading) interface{} { return r.Estat }),
+			"baseHoraria":  readingField(gql.String, "Time base of the reading, e.g SH", func(r reading) interface{} { return r.BaseHoraria }),
+			"variable": &gql.Field{
+				Type:        variable,
+				Description: "Metadata of the variable",
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					variables, err := loaderFrom(p.Context).variables()
+					if err != nil {
+						return nil, err
+					}
+					if v, ok := variables[p.Source.(reading).variable]; ok {
+						return v, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	readings := &gql.Field{
+		Type: nonNull(gql.NewList(nonNull(readingType))),
+		Args: gql.FieldConfigArgument{
+			"variables": &gql.ArgumentConfig{Type: nonNull(gql.NewList(nonNull(gql.Int))), Description: "Codes of the variables"},
+			"date":      &gql.ArgumentConfig{Type: nonNull(gql.String), Description: "Day of the readings, e.g 2023-03-12"},
+		},
+		Description: "Readings of the variables during a day",
+		Resolve:     resolveReadings,
+	}
+	latest := &gql.Field{
+		Type: nonNull(gql.NewList(nonNull(readingType))),
+		Args: gql.FieldConfigArgument{
+			"variables": &gql.ArgumentConfig{Type: nonNull(gql.NewList(nonNull(gql.Int))), Description: "Codes of the variables"},
+		},
+		Description: "Last reading of the variables during the last 4 hours",
+		Resolve:     resolveReadings,
+	}
+
+	station := gql.NewObject(gql.ObjectConfig{
+		Name:        "Station",
+		Description: "An automatic weather station of the XEMA",
+		Fields: gql.Fields{
+			"codi":        stationField(nonNull(gql.String), "Code of the station", func(s meteocat.MetadadesEstacions) interface{} { return s.Codi }),
+			"nom":         stationField(nonNull(gql.String), "Name of the station", func(s meteocat.MetadadesEstacions) interface{} { return s.Nom }),
+			"tipus":       stationField(gql.String, "Type of the station", func(s meteocat.MetadadesEstacions) interface{} { return s.Tipus }),
+			"emplacament": stationField(gql.String, "Description of the location", func(s meteocat.MetadadesEstacions) interface{} { return s.Emplacament }),
+			"latitud":     stationField(nonNull(gql.Float), "Latitude in decimal degrees, WGS84", func(s meteocat.MetadadesEstacions) interface{} { return s.Coordenades.Latitud }),
+			"longitud":    stationField(nonNull(gql.Float), "Longitude in decimal degrees, WGS84", func(s meteocat.MetadadesEstacions) interface{} { return s.Coordenades.Longitud }),
+			"altitud":     stationField(gql.Float, "Altitude in meters", func(s meteocat.MetadadesEstacions) interface{} { return s.Altitud }),
+			"municipi":    stationField(gql.String, "Name of the municipi", func(s meteocat.MetadadesEstacions) interface{} { return s.Municipi.Nom }),
+			"comarca":     stationField(gql.String, "Name of the comarca", func(s meteocat.MetadadesEstacions) interface{} { return s.Comarca.Nom }),
+			"provincia":   stationField(gql.String, "Name of the provincia", func(s meteocat.MetadadesEstacions) interface{} { return s.Provincia.Nom }),
+			"xarxa":       stationField(gql.String, "Name of the network", func(s meteocat.MetadadesEstacions) interface{} { return s.Xarxa.Nom }),
+			"estat":       stationField(gql.String, "Current state: ope, des or bte", func(s meteocat.MetadadesEstacions) interface{} { return s.EstatActual() }),
+			"latest":      latest,
+			"readings":    readings,
+		},
+	})
+
+	query := gql.NewObject(gql.ObjectConfig{
+		Name: "Query",
+		Fields: gql.Fields{
+			"stations": &gql.Field{
+				Type:        nonNull(gql.NewList(nonNull(station))),
+				Description: "Stations matching all the given filters, compared case insensitively",
+				Args: gql.FieldConfigArgument{
+					"comarca":   &gql.ArgumentConfig{Type: gql.String},
+					"municipi":  &gql.ArgumentConfig{Type: gql.String},
+					"provincia": &gql.ArgumentConfig{Type: gql.String},
+					"estat":     &gql.ArgumentConfig{Type: gql.String},
+					"codis":     &gql.ArgumentConfig{Type: gql.NewList(nonNull(gql.String))},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					all, err := loaderFrom(p.Context).stations()
+					if err != nil {
+						return nil, err
+					}
+					stations := []meteocat.MetadadesEstacions{}
+					for _, st := range all {
+						if matches(st, p.Args) {
+							stations = append(stations, st)
+						}
+					}
+					return stations, nil
+				},
+			},
+			"station": &gql.Field{
+				Type: station,
+				Args: gql.FieldConfigArgument{
+					"codi": &gql.ArgumentConfig{Type: nonNull(gql.String)},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					all, err := loaderFrom(p.Context).stations()
+					if err != nil {
+						return nil, err
+					}
+					for _, st := range all {
+						if strings.EqualFold(st.Codi, p.Args["codi"].(string)) {
+							return st, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+			"variables": &gql.Field{
+				Type: nonNull(gql.NewList(nonNull(variable))),
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					all, err := loaderFrom(p.Context).variables()
+					if err != nil {
+						return nil, err
+					}
+					variables := make([]meteocat.MetadadesVariable, 0, len(all))
+					for _, v := range all {
+						variables = append(variables, v)
+					}
+					sort.Slice(variables, func(i, j int) bool { return variables[i].Codi < variables[j].Codi })
+					return variables, nil
+				},
+			},
+			"variable": &gql.Field{
+				Type: variable,
+				Args: gql.FieldConfigArgument{
+					"codi": &gql.ArgumentConfig{Type: nonNull(gql.Int)},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					all, err := loaderFrom(p.Context).variables()
+					if err != nil {
+						return nil, err
+					}
+					if v, ok := all[p.Args["codi"].(int)]; ok {
+						return v, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	return gql.NewSchema(gql.SchemaConfig{Query: query})
+}
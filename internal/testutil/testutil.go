@@ -0,0 +1,41 @@
+// Package testutil holds the helpers shared by the tests of the packages of the module.
+package testutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// path returns the path of a file of the testdata directory at the root of the module
+func path(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "testdata", name)
+}
+
+// Load decodes a testdata fixture into v
+func Load(t testing.TB, fixture string, v interface{}) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TempDir returns a new temporary directory and a function which removes it
+func TempDir(t testing.TB, prefix string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
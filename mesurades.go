@@ -75,6 +75,7 @@ type Mesurades struct {
 	MetadadesVariablesEstacio
 	MetadadesVariableEstacio
 	MetadadesVariables
+	EstacionsRepresentatives
 	Key          string
 	CodiEstacio  string // ?
 	CodiVariable string // ?
@@ -82,13 +83,17 @@ type Mesurades struct {
 }
 
 // NewMesurades returns a new MesuradesData pointer with the supplied parameters
-func NewMesurades(key string) (*Mesurades, error) {
+func NewMesurades(key string, options ...Option) (*Mesurades, error) {
 	c := &Mesurades{
 		Settings: NewSettings(),
 	}
 
 	c.Key, _ = setKey(key)
 
+	if err := setOptions(c.Settings, options); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
@@ -111,19 +116,19 @@ func (m *Mesurades) MeasurementByDay(p *Parameters) error {
 		return errVariableUnavailable
 	}
 
+	var url string
 	if p.codiEstacio != "" {
 		p.codiEstacio = strings.ToUpper(p.codiEstacio)
 
 		if ValidCodiEstacio(p.codiEstacio) {
 			m.CodiEstacio = p.codiEstacio
-			sFlag = true
-			url = fmt.Sprintf(baseURL, fmt.Sprintf("/variables/mesurades/%s/%s/%s/%s?codiEstacio=%s", p.codiVariable, p.Any, p.Mes, p.Dia, p.codiEstacio))
+			url = m.endpoint(fmt.Sprintf("/variables/mesurades/%s/%s/%s/%s?codiEstacio=%s", p.codiVariable, p.Any, p.Mes, p.Dia, p.codiEstacio))
 		} else {
 			return errEstacioUnavailable
 		}
 
 	} else {
-		url = fmt.Sprintf(baseURL, fmt.Sprintf("/variables/mesurades/%s/%s/%s/%s", p.codiVariable, p.Any, p.Mes, p.Dia))
+		url = m.endpoint(fmt.Sprintf("/variables/mesurades/%s/%s/%s/%s", p.codiVariable, p.Any, p.Mes, p.Dia))
 	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -133,7 +138,7 @@ func (m *Mesurades) MeasurementByDay(p *Parameters) error {
 	//req.Header.Set("Content-Type", "application/json")
 	req.Header.Add("X-Api-Key", m.Key)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
@@ -141,7 +146,8 @@ func (m *Mesurades) MeasurementByDay(p *Parameters) error {
 	}
 	defer resp.Body.Close()
 
-	if sFlag == true {
+	// The response of a single station is an item, that of all the stations an array
+	if p.codiEstacio != "" {
 		if err = json.NewDecoder(resp.Body).Decode(&m.Variable); err != nil {
 			return err
 		}
@@ -166,7 +172,7 @@ func (m *Mesurades) MeasurementAllByStation(p *Parameters) error {
 		return errEstacioUnavailable
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf(baseURL, fmt.Sprintf("/estacions/mesurades/%s/%s/%s/%s", strings.ToUpper(p.codiEstacio), p.Any, p.Mes, p.Dia)), nil)
+	req, err := http.NewRequest("GET", m.endpoint(fmt.Sprintf("/estacions/mesurades/%s/%s/%s/%s", strings.ToUpper(p.codiEstacio), p.Any, p.Mes, p.Dia)), nil)
 
 	if err != nil {
 		return err
@@ -174,7 +180,7 @@ func (m *Mesurades) MeasurementAllByStation(p *Parameters) error {
 
 	req.Header.Add("X-Api-Key", m.Key)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
@@ -200,18 +206,18 @@ func (m *Mesurades) MeasurementLast(p *Parameters) error {
 		return errVariableUnavailable
 	}
 
+	var url string
 	if p.codiEstacio != "" {
 		p.codiEstacio = strings.ToUpper(p.codiEstacio)
 		if ValidCodiEstacio(p.codiEstacio) {
 			m.CodiEstacio = p.codiEstacio
-			url = fmt.Sprintf(baseURL, fmt.Sprintf("/variables/mesurades/%s/ultimes?codiEstacio=%s", p.codiVariable, p.codiEstacio))
-			sFlag = true
+			url = m.endpoint(fmt.Sprintf("/variables/mesurades/%s/ultimes?codiEstacio=%s", p.codiVariable, p.codiEstacio))
 		} else {
 			return errEstacioUnavailable
 		}
 
 	} else {
-		url = fmt.Sprintf(baseURL, fmt.Sprintf("/variables/mesurades/%s/ultimes", p.codiVariable))
+		url = m.endpoint(fmt.Sprintf("/variables/mesurades/%s/ultimes", p.codiVariable))
 	}
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -221,14 +227,15 @@ func (m *Mesurades) MeasurementLast(p *Parameters) error {
 
 	req.Header.Add("X-Api-Key", m.Key)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 	defer resp.Body.Close()
-	if sFlag == true {
+	// The response of a single station is an item, that of all the stations an array
+	if p.codiEstacio != "" {
 		if err = json.NewDecoder(resp.Body).Decode(&m.Variable); err != nil {
 			return err
 		}
@@ -256,19 +263,19 @@ func (m *Mesurades) MeasurementMetadataAllByStation(p *Parameters) error {
 		return errVariableUnavailable
 	}
 
-	dataOk := ValidData(p.Data)
-	if p.codiEstat != "" && dataOk == true {
+	var url string
+	filtered := p.codiEstat != "" && ValidData(p.Data)
+	if filtered {
 		p.codiEstat = strings.ToLower(p.codiEstat)
 
 		if ValidCodiEstat(p.codiEstat) {
-			sFlag = true
-			url = fmt.Sprintf(baseURL, fmt.Sprintf("/estacions/%s/variables/mesurades/metadades?estat=%s&data=%s-%s-%sZ", p.codiEstacio, p.codiEstat, p.Any, p.Mes, p.Dia))
+			url = m.endpoint(fmt.Sprintf("/estacions/%s/variables/mesurades/metadades?estat=%s&data=%s-%s-%sZ", p.codiEstacio, p.codiEstat, p.Any, p.Mes, p.Dia))
 		} else {
 			return errEstacioUnavailable
 		}
 
 	} else {
-		url = fmt.Sprintf(baseURL, fmt.Sprintf("/estacions/%s/variables/mesurades/metadades", p.codiEstacio))
+		url = m.endpoint(fmt.Sprintf("/estacions/%s/variables/mesurades/metadades", p.codiEstacio))
 	}
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -278,14 +285,14 @@ func (m *Mesurades) MeasurementMetadataAllByStation(p *Parameters) error {
 
 	req.Header.Add("X-Api-Key", m.Key)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 	defer resp.Body.Close()
-	if sFlag == true {
+	if filtered {
 		if err = json.NewDecoder(resp.Body).Decode(&m.MetadadesVariableEstacio); err != nil {
 			return err
 		}
@@ -315,14 +322,14 @@ func (m *Mesurades) MeasurementMetadataByStation(p *Parameters) error {
 		return errEstacioUnavailable
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf(baseURL, fmt.Sprintf("/estacions/%s/variables/mesurades/%s/metadades", p.codiEstacio, p.codiVariable)), nil)
+	req, err := http.NewRequest("GET", m.endpoint(fmt.Sprintf("/estacions/%s/variables/mesurades/%s/metadades", p.codiEstacio, p.codiVariable)), nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Add("X-Api-Key", m.Key)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
@@ -341,14 +348,14 @@ func (m *Mesurades) MeasurementMetadataByStation(p *Parameters) error {
 // there are no parameters. Request example https://api.meteo.cat/xema/v1/variables/mesurades/metadades
 func (m *Mesurades) MeasurementMetadataAll() error {
 
-	req, err := http.NewRequest("GET", fmt.Sprintf(baseURL, fmt.Sprintf("/variables/mesurades/metadades")), nil)
+	req, err := http.NewRequest("GET", m.endpoint(fmt.Sprintf("/variables/mesurades/metadades")), nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Add("X-Api-Key", m.Key)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
@@ -374,13 +381,13 @@ func (m *Mesurades) MeasurementMetadataUnique(p *Parameters) error {
 		return errVariableUnavailable
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf(baseURL, fmt.Sprintf("/variables/mesurades/%s/metadades", p.codiVariable)), nil)
+	req, err := http.NewRequest("GET", m.endpoint(fmt.Sprintf("/variables/mesurades/%s/metadades", p.codiVariable)), nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Add("X-Api-Key", m.Key)
-	resp, err := m.client.Do(req)
+	resp, err := m.do(req)
 
 	if err != nil {
 		fmt.Println(err)
@@ -1,9 +1,13 @@
 package meteocat
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
 )
 
 var errEstacioUnavailable = errors.New("station code unavailable")
@@ -11,16 +15,14 @@ var errVariableUnavailable = errors.New("variable code unavailable")
 var errInvalidKey = errors.New("invalid api key")
 var errInvalidOption = errors.New("invalid option")
 var errInvalidHttpClient = errors.New("invalid http client")
+var errInvalidData = errors.New("invalid data")
+var errMunicipiUnavailable = errors.New("municipi code unavailable")
+var errNoRepresentativeStation = errors.New("no representative station")
 
 // DataUnits represents the character chosen to represent the temperature notation
 // var DataUnits = map[string]string{"C": "metric"}
 var (
 	baseURL = "https://api.meteo.cat/xema/v1%s"
-	url     string // helper variable to hold url values
-	sFlag   bool   // helper variable to check where to handle JSON that varies between an array or a single item
-	// Its worth to note that the single item corresponds to a lecture of a single station whereas an array is a lecture
-	// containing data of all stations. See the endpoint of MeasurementByDay function.
-
 )
 
 // Config will hold default settings
@@ -48,6 +50,7 @@ type Parameters struct {
 	codiEstacio  string // should reference a key in the CodisEstacions map
 	codiVariable string // should reference a key in the CodisVariables map
 	codiEstat    string // should reference a key in the CodisEstat map
+	codiMunicipi string // INE code of the municipi
 	Data
 	TimeDate
 }
@@ -60,6 +63,7 @@ func NewParameters(options ...func(*Parameters) error) (*Parameters, error) {
 	p.codiEstacio = ""
 	p.codiVariable = ""
 	p.codiEstat = ""
+	p.codiMunicipi = ""
 	p.Any = ""
 	p.Mes = ""
 	p.Dia = ""
@@ -97,6 +101,14 @@ func OptionCodiVariable(codiVariable string) func(p *Parameters) error {
 	}
 }
 
+// OptionCodiMunicipi is a helper function to set up the value of CodiMunicipi to be passed in Parameters struct
+func OptionCodiMunicipi(codiMunicipi string) func(p *Parameters) error {
+	return func(p *Parameters) error {
+		p.codiMunicipi = codiMunicipi
+		return nil
+	}
+}
+
 // OptionData is a helper function to set up the value of Data to be passed in Parameters struct
 func OptionData(d Data) func(p *Parameters) error {
 	return func(p *Parameters) error {
@@ -118,6 +130,26 @@ func OptionTimeDate(d TimeDate) func(p *Parameters) error {
 	}
 }
 
+// dataLayouts holds the time formats used by the API to represent dates, e.g 2023-03-12T00:00Z
+var dataLayouts = []string{
+	"2006-01-02T15:04Z",
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05.000Z",
+	time.RFC3339,
+	"2006-01-02Z",
+	"2006-01-02",
+}
+
+// ParseData parses a date as returned by the API, e.g 2023-03-12T00:00Z, into a UTC time.
+func ParseData(s string) (time.Time, error) {
+	for _, layout := range dataLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date format: %q", s)
+}
+
 // APIError returned on failed API calls.
 type APIError struct {
 	Message string `json:"message"`
@@ -132,16 +164,31 @@ func setKey(key string) (string, error) {
 	return key, nil
 }
 
-// ValidData validates that we set a correct data
+// ValidData validates that we set a correct data, that is all its fields are filled. It prints a warning when the
+// data is partially filled, an empty data being the way to leave it unset.
 func ValidData(d Data) bool {
 	if d.Mes != "" && d.Any != "" && d.Dia != "" {
 		return true
-	} else if d.Mes == "" || d.Any == "" || d.Dia == "" {
+	} else if d.Mes != "" || d.Any != "" || d.Dia != "" {
 		fmt.Println("To use the data parameter all the fields must be filled. Leave empty if its not used")
 	}
 	return false
 }
 
+// ValidCodiMunicipi makes sure the string passed in is a
+// well formed INE municipi code, e.g 080193.
+func ValidCodiMunicipi(c string) bool {
+	if len(c) != 6 {
+		return false
+	}
+	for _, r := range c {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // ValidAPIKey makes sure that the key given is a valid one
 func ValidAPIKey(key string) error {
 	if len(key) != 40 {
@@ -188,40 +235,96 @@ func CheckAPIKeyExists(apiKey string) bool { return len(apiKey) > 1 }
 
 // Settings holds the client settings
 type Settings struct {
-	client *http.Client
-	req    *http.Request
+	client     *http.Client
+	req        *http.Request
+	stats      CallStats
+	xemaURL    string       // Base URL of the XEMA endpoints, api.meteo.cat when empty
+	httpClient *http.Client // Client set by WithHttpClient, the client of the Settings wraps its transport
+	cache      Cache        // Cache set by WithCache
 
 	//cr *resty.Client
 }
 
-// NewSettings returns a new Setting pointer with default http client.
+// NewSettings returns a new Setting pointer with default http client. The client asks for gzip compressed responses.
 func NewSettings() *Settings {
-	return &Settings{
-		client: http.DefaultClient,
-	}
+	s := &Settings{}
+	s.buildClient()
+	return s
 }
 
-//func OptionURL(url string) Option {
-//	return func(s *Settings) error {
-//		s.url = url
-//		return nil
-//	}
-//}
+// buildClient sets the client of the Settings from the options, whatever their order: the transport of the
+// http client is wrapped with the gzip support and then with the cache.
+func (s *Settings) buildClient() {
+	client := &http.Client{}
+	if s.httpClient != nil {
+		c := *s.httpClient
+		client = &c
+	}
+	client.Transport = newTransport(client.Transport)
+	if s.cache != nil {
+		client.Transport = NewCacheTransport(s.cache, client.Transport)
+	}
+	s.client = client
+}
 
 // Optional client settings
 type Option func(s *Settings) error
 
-// // WithHttpClient sets custom http client when creating a new Client.
+// WithBaseURL sets the base URL of the XEMA endpoints, e.g http://localhost:9725/xema/v1, to send the requests
+// to a meteocat-proxy or a mirror of the API instead of api.meteo.cat.
+func WithBaseURL(u string) Option {
+	return func(s *Settings) error {
+		parsed, err := neturl.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errInvalidOption
+		}
+		s.xemaURL = strings.TrimSuffix(u, "/")
+		return nil
+	}
+}
+
+// endpoint returns the URL of a XEMA endpoint path
+func (s *Settings) endpoint(path string) string {
+	if s.xemaURL != "" {
+		return s.xemaURL + path
+	}
+	return fmt.Sprintf(baseURL, path)
+}
+
+// WithHttpClient sets custom http client when creating a new Client. The transport of the client is wrapped
+// to ask for gzip compressed responses and to use the cache set by WithCache, if any.
 func WithHttpClient(c *http.Client) Option {
 	return func(s *Settings) error {
 		if c == nil {
 			return errInvalidHttpClient
 		}
-		s.client = c
+		s.httpClient = c
 		return nil
 	}
 }
 
+// get performs a GET request against url authenticated with key and decodes the JSON response into v.
+func (s *Settings) get(key, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("X-Api-Key", key)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
 // setOptions sets Optional client settings to the Settings pointer
 func setOptions(settings *Settings, options []Option) error {
 	for _, option := range options {
@@ -233,5 +336,6 @@ func setOptions(settings *Settings, options []Option) error {
 			return err
 		}
 	}
+	settings.buildClient()
 	return nil
 }
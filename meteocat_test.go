@@ -1,6 +1,10 @@
 package meteocat
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 )
 
@@ -21,7 +25,7 @@ func TestValidCodisEstacions(t *testing.T) {
 // TestValidCodiVariable tests whether or not ValidCodiVariable provides
 // the correct assertion on provided data unit.
 func TestValidCodiVariable(t *testing.T) {
-	for _, s := range CodisVariables {
+	for s := range CodisVariables {
 		if !ValidCodiVariable(s) {
 			t.Error("False positive on data unit symbol")
 		}
@@ -51,3 +55,81 @@ func TestSetOptionsWithEmpty(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// TestWithBaseURL tests that the requests are sent to the configured base URL and that invalid URLs are rejected.
+func TestWithBaseURL(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/mesurades_dia_unica_estacio.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	m, err := NewMesurades("", WithBaseURL(srv.URL+"/xema/v1/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := NewParameters(
+		OptionCodiEstacio("D5"),
+		OptionCodiVariable("32"),
+		OptionData(Data{Any: "2023", Mes: "03", Dia: "12"}),
+	)
+	if err := m.MeasurementByDay(p); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/xema/v1/variables/mesurades/32/2023/03/12" {
+		t.Errorf("unexpected path %q", path)
+	}
+	if len(m.Variable.Lectures) != 2 {
+		t.Errorf("unexpected variable: %+v", m.Variable)
+	}
+
+	for _, u := range []string{"", "localhost:9725", "ftp://localhost/xema/v1"} {
+		if _, err := NewMesurades("", WithBaseURL(u)); err != errInvalidOption {
+			t.Errorf("%q: expected %v, got %v", u, errInvalidOption, err)
+		}
+	}
+}
+
+// TestValidData tests that only complete dates are valid and that the warning is printed for partially filled
+// dates only.
+func TestValidData(t *testing.T) {
+	for _, tc := range []struct {
+		d       Data
+		valid   bool
+		warning bool
+	}{
+		{Data{Any: "2023", Mes: "03", Dia: "12"}, true, false},
+		{Data{}, false, false},
+		{Data{Any: "2023"}, false, true},
+		{Data{Any: "2023", Mes: "03"}, false, true},
+		{Data{Dia: "12"}, false, true},
+	} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		valid := ValidData(tc.d)
+		os.Stdout = stdout
+		w.Close()
+		out, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if valid != tc.valid {
+			t.Errorf("%+v: expected %v, got %v", tc.d, tc.valid, valid)
+		}
+		if warning := len(out) > 0; warning != tc.warning {
+			t.Errorf("%+v: unexpected warning %q", tc.d, out)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Package mqtt publishes the new XEMA readings to an MQTT broker, for IoT platforms and Home Assistant instances.
+//
+// A Publisher polls the last measurements of its variables at all the stations and publishes each reading newer than
+// the last one published for its station and variable as a JSON payload to the topic {prefix}/{station}/{acronym},
+// e.g meteocat/D5/T. The latest reading is also published retained to {prefix}/{station}/{acronym}/latest, so new
+// subscribers get the current values at once.
+//
+// On the first poll the times of the last published readings are read back from the retained latest topics, so a
+// restarted Publisher does not publish the readings it already published again.
+//
+// The first time a station and variable are seen, a Home Assistant MQTT discovery config is published retained to
+// {discovery prefix}/sensor/meteocat_{station}_{variable}/config. The sensors of a station are grouped in a device
+// whose state is the latest topic.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/oscaromeu/meteocat"
+)
+
+// Default settings of a Publisher
+const (
+	DefaultTopicPrefix     = "meteocat"
+	DefaultDiscoveryPrefix = "homeassistant"
+)
+
+// publishTimeout bounds the wait for the acknowledgement of a message
+const publishTimeout = 30 * time.Second
+
+// seedWait is the time given to the broker to deliver the retained latest readings once subscribed
+const seedWait = 2 * time.Second
+
+var errInvalidOption = errors.New("invalid option")
+var errNoClient = errors.New("no mqtt client")
+var errNotConnected = errors.New("mqtt client not connected")
+var errPublishTimeout = errors.New("mqtt publish timed out")
+
+// deviceClasses maps the codes of the variables with a Home Assistant sensor device class
+var deviceClasses = map[int]string{
+	30: "wind_speed",
+	32: "temperature",
+	33: "humidity",
+	34: "atmospheric_pressure",
+	35: "precipitation",
+	36: "irradiance",
+	40: "temperature",
+	42: "temperature",
+}
+
+// haUnits maps the units of the catalogue which Home Assistant spells differently
+var haUnits = map[string]string{
+	"W/m2": "W/m²",
+}
+
+// Reading is the JSON payload of a reading
+type Reading struct {
+	Estacio     string  `json:"estacio"`
+	Variable    int     `json:"variable"`
+	Acronim     string  `json:"acronim"`
+	Valor       float64 `json:"valor"`
+	Unitats     string  `json:"unitats"`
+	Data        string  `json:"data"` // RFC 3339 time of the reading
+	Estat       string  `json:"estat"`
+	BaseHoraria string  `json:"baseHoraria"`
+}
+
+// Publisher publishes the new readings of the variables to the broker of an MQTT client
+type Publisher struct {
+	key             string
+	options         []meteocat.Option
+	client          paho.Client
+	codes           []int
+	prefix          string
+	discoveryPrefix string // Discovery is disabled when empty
+	qos             byte
+	stations        map[string]meteocat.MetadadesEstacions
+	variables       map[int]meteocat.MetadadesVariable
+	last            map[string]time.Time // Time of the last published reading by topic
+	seeded          bool                 // The last published readings have been read from the broker
+	seedWait        time.Duration        // Wait for the retained latest readings
+	discovered      map[string]bool      // Discovery configs published by topic
+}
+
+// Option configures a Publisher
+type Option func(p *Publisher) error
+
+// WithClientOptions sets the options of the clients which request the readings, e.g meteocat.WithCache.
+func WithClientOptions(options ...meteocat.Option) Option {
+	return func(p *Publisher) error {
+		p.options = options
+		return nil
+	}
+}
+
+// WithVariables sets the codes of the published variables, by default 32, 33 and 35.
+func WithVariables(codes ...int) Option {
+	return func(p *Publisher) error {
+		if len(codes) == 0 {
+			return errInvalidOption
+		}
+		p.codes = codes
+		return nil
+	}
+}
+
+// WithTopicPrefix sets the first level of the topics of the readings, by default meteocat.
+func WithTopicPrefix(prefix string) Option {
+	return func(p *Publisher) error {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" || strings.ContainsAny(prefix, "#+") {
+			return errInvalidOption
+		}
+		p.prefix = prefix
+		return nil
+	}
+}
+
+// WithDiscoveryPrefix sets the prefix of the Home Assistant discovery topics, by default homeassistant. An empty
+// prefix disables the discovery configs.
+func WithDiscoveryPrefix(prefix string) Option {
+	return func(p *Publisher) error {
+		prefix = strings.Trim(prefix, "/")
+		if strings.ContainsAny(prefix, "#+") {
+			return errInvalidOption
+		}
+		p.discoveryPrefix = prefix
+		return nil
+	}
+}
+
+// WithQoS sets the quality of service of the messages, 0, 1 or 2. It is 1 by default.
+func WithQoS(qos byte) Option {
+	return func(p *Publisher) error {
+		if qos > 2 {
+			return errInvalidOption
+		}
+		p.qos = qos
+		return nil
+	}
+}
+
+// NewPublisher returns a new Publisher pointer which requests the readings with key and publishes them with client.
+// The client is connected by the caller.
+func NewPublisher(key string, client paho.Client, options ...Option) (*Publisher, error) {
+	if client == nil {
+		return nil, errNoClient
+	}
+	p := &Publisher{
+		key:             key,
+		client:          client,
+		codes:           []int{32, 33, 35},
+		prefix:          DefaultTopicPrefix,
+		discoveryPrefix: DefaultDiscoveryPrefix,
+		qos:             1,
+		last:            make(map[string]time.Time),
+		seedWait:        seedWait,
+		discovered:      make(map[string]bool),
+	}
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(p); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := meteocat.NewMesurades(key, p.options...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Topic returns the topic of the readings of a variable at a station, e.g meteocat/D5/T
+func (p *Publisher) Topic(estacio string, codi int) string {
+	return p.prefix + "/" + estacio + "/" + p.acronym(codi)
+}
+
+// acronym returns the acronym of a variable, or its code when it is unknown
+func (p *Publisher) acronym(codi int) string {
+	if v, ok := p.variables[codi]; ok && v.Acronim != "" {
+		return v.Acronim
+	}
+	return strconv.Itoa(codi)
+}
+
+// loadMetadata fetches the station and variable catalogues used for the topics and the discovery configs
+func (p *Publisher) loadMetadata() error {
+	if p.stations == nil {
+		e, err := meteocat.NewEstacions(p.key, p.options...)
+		if err != nil {
+			return err
+		}
+		params, _ := meteocat.NewParameters()
+		if err := e.StationsAll(params); err != nil {
+			return err
+		}
+		p.stations = make(map[string]meteocat.MetadadesEstacions, len(e.MetadadesTotesEstacions))
+		for _, st := range e.MetadadesTotesEstacions {
+			p.stations[st.Codi] = st
+		}
+	}
+
+	if p.variables == nil {
+		m, err := meteocat.NewMesurades(p.key, p.options...)
+		if err != nil {
+			return err
+		}
+		if err := m.MeasurementMetadataAll(); err != nil {
+			return err
+		}
+		p.variables = make(map[int]meteocat.MetadadesVariable, len(m.MetadadesVariables))
+		for _, v := range m.MetadadesVariables {
+			p.variables[v.Codi] = v.MetadadesVariable
+		}
+	}
+	return nil
+}
+
+// seed reads the times of the last published readings from the retained latest topics
+func (p *Publisher) seed() error {
+	var mu sync.Mutex
+	last := make(map[string]time.Time)
+	filter := p.prefix + "/+/+/latest"
+	token := p.client.Subscribe(filter, p.qos, func(_ paho.Client, msg paho.Message) {
+		var r Reading
+		if !msg.Retained() || json.Unmarshal(msg.Payload(), &r) != nil {
+			return
+		}
+		t, err := time.Parse(time.RFC3339, r.Data)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		last[strings.TrimSuffix(msg.Topic(), "/latest")] = t
+	})
+	if !token.WaitTimeout(publishTimeout) {
+		return errPublishTimeout
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	time.Sleep(p.seedWait)
+	token = p.client.Unsubscribe(filter)
+	if !token.WaitTimeout(publishTimeout) {
+		return errPublishTimeout
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for topic, t := range last {
+		if t.After(p.last[topic]) {
+			p.last[topic] = t
+		}
+	}
+	p.seeded = true
+	return token.Error()
+}
+
+// Poll requests the last readings of the variables and publishes those newer than the last published ones. It
+// returns the number of published readings.
+func (p *Publisher) Poll() (int, error) {
+	if !p.client.IsConnectionOpen() {
+		return 0, errNotConnected
+	}
+	if err := p.loadMetadata(); err != nil {
+		return 0, err
+	}
+	if !p.seeded {
+		if err := p.seed(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for _, codi := range p.codes {
+		m, err := meteocat.NewMesurades(p.key, p.options...)
+		if err != nil {
+			return n, err
+		}
+		params, err := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)))
+		if err != nil {
+			return n, err
+		}
+		if err := m.MeasurementLast(params); err != nil {
+			return n, err
+		}
+
+		for _, st := range m.Measurements {
+			for _, v := range st.Variables {
+				if v.Codi != codi {
+					continue
+				}
+				published, err := p.publishVariable(st.Codi, v)
+				n += published
+				if err != nil {
+					return n, err
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+// publishVariable publishes the new readings of a variable at a station, its latest reading and its discovery config
+func (p *Publisher) publishVariable(estacio string, v meteocat.Variable) (int, error) {
+	type timed struct {
+		t time.Time
+		l meteocat.Lectura
+	}
+	topic := p.Topic(estacio, v.Codi)
+	last := p.last[topic]
+
+	var lectures []timed
+	for _, l := range v.Lectures {
+		t, err := meteocat.ParseData(l.Data)
+		if err != nil || !t.After(last) {
+			continue
+		}
+		lectures = append(lectures, timed{t, l})
+	}
+	if len(lectures) == 0 {
+		return 0, nil
+	}
+	sort.Slice(lectures, func(i, j int) bool { return lectures[i].t.Before(lectures[j].t) })
+
+	if p.discoveryPrefix != "" && !p.discovered[topic] {
+		if err := p.publish(p.discoveryTopic(estacio, v.Codi), true, p.discovery(estacio, v.Codi)); err != nil {
+			return 0, err
+		}
+		p.discovered[topic] = true
+	}
+
+	meta := p.variables[v.Codi]
+	var payload Reading
+	for i, tl := range lectures {
+		payload = Reading{
+			Estacio:     estacio,
+			Variable:    v.Codi,
+			Acronim:     p.acronym(v.Codi),
+			Valor:       tl.l.Valor,
+			Unitats:     meta.Unitats,
+			Data:        tl.t.UTC().Format(time.RFC3339),
+			Estat:       strings.TrimSpace(tl.l.Estat),
+			BaseHoraria: tl.l.BaseHoraria,
+		}
+		if err := p.publish(topic, false, payload); err != nil {
+			return i, err
+		}
+		p.last[topic] = tl.t
+	}
+	if err := p.publish(topic+"/latest", true, payload); err != nil {
+		return len(lectures), err
+	}
+	return len(lectures), nil
+}
+
+// discoveryTopic returns the topic of the Home Assistant discovery config of a variable at a station
+func (p *Publisher) discoveryTopic(estacio string, codi int) string {
+	return fmt.Sprintf("%s/sensor/meteocat_%s_%d/config", p.discoveryPrefix, estacio, codi)
+}
+
+// discovery returns the Home Assistant discovery config of the sensor of a variable at a station
+func (p *Publisher) discovery(estacio string, codi int) map[string]interface{} {
+	meta := p.variables[codi]
+	station := p.stations[estacio]
+	name := station.Nom
+	if name == "" {
+		name = estacio
+	}
+
+	config := map[string]interface{}{
+		"name":                  meta.Nom,
+		"unique_id":             fmt.Sprintf("meteocat_%s_%d", estacio, codi),
+		"object_id":             fmt.Sprintf("meteocat_%s_%s", strings.ToLower(estacio), strings.ToLower(p.acronym(codi))),
+		"state_topic":           p.Topic(estacio, codi) + "/latest",
+		"value_template":        "{{ value_json.valor }}",
+		"json_attributes_topic": p.Topic(estacio, codi) + "/latest",
+		"state_class":           "measurement",
+		"device": map[string]interface{}{
+			"identifiers":  []string{"meteocat_" + estacio},
+			"name":         name,
+			"manufacturer": "Servei Meteorològic de Catalunya",
+			"model":        "XEMA",
+		},
+	}
+	if meta.Unitats != "" {
+		unit := meta.Unitats
+		if u, ok := haUnits[unit]; ok {
+			unit = u
+		}
+		config["unit_of_measurement"] = unit
+	}
+	if class, ok := deviceClasses[codi]; ok {
+		config["device_class"] = class
+	}
+	if meta.Decimals > 0 {
+		config["suggested_display_precision"] = meta.Decimals
+	}
+	return config
+}
+
+// publish sends v as the JSON payload of a message and waits for its acknowledgement
+func (p *Publisher) publish(topic string, retained bool, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	token := p.client.Publish(topic, p.qos, retained, b)
+	if !token.WaitTimeout(publishTimeout) {
+		return errPublishTimeout
+	}
+	return token.Error()
+}
+
+// Run polls every interval until the context is cancelled. Failed polls are logged and retried on the next tick.
+func (p *Publisher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := p.Poll()
+		if err != nil {
+			log.Printf("poll: %v", err)
+		} else {
+			log.Printf("published %d readings", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/oscaromeu/meteocat"
+)
+
+// newBroker starts an embedded broker on a free local port and returns it with its address
+func newBroker(t *testing.T) (*broker.Server, string) {
+	t.Helper()
+
+	b := broker.New(&broker.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := b.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	l := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := b.AddListener(l); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	return b, l.Address()
+}
+
+// newClient returns a client connected to the broker at addr
+func newClient(t *testing.T, addr string) paho.Client {
+	t.Helper()
+
+	c := paho.NewClient(paho.NewClientOptions().AddBroker("tcp://" + addr).SetClientID("meteocat-test"))
+	if token := c.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return c
+}
+
+// messages collects the messages delivered to an inline subscription
+type messages struct {
+	mu     sync.Mutex
+	topics []string
+	byName map[string][][]byte
+	retain map[string]bool
+}
+
+// subscribe collects the messages of the topics matching filter
+func subscribe(t *testing.T, b *broker.Server, filter string, id int) *messages {
+	t.Helper()
+
+	m := &messages{byName: make(map[string][][]byte), retain: make(map[string]bool)}
+	err := b.Subscribe(filter, id, func(cl *broker.Client, sub packets.Subscription, pk packets.Packet) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.topics = append(m.topics, pk.TopicName)
+		m.byName[pk.TopicName] = append(m.byName[pk.TopicName], append([]byte(nil), pk.Payload...))
+		m.retain[pk.TopicName] = pk.FixedHeader.Retain
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// wait waits until n messages have been collected
+func (m *messages) wait(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		got := len(m.topics)
+		m.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d messages, got %d", n, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newAPIServer starts a test server which answers the catalogues with testdata fixtures and the last temperatures
+// with the body returned by last
+func newAPIServer(t *testing.T, last func() []byte) *httptest.Server {
+	t.Helper()
+
+	routes := map[string]string{
+		"/xema/v1/estacions/metadades":           "metadades_totes_estacions.json",
+		"/xema/v1/variables/mesurades/metadades": "variables_mesurades_metadades.json",
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/xema/v1/variables/mesurades/32/ultimes" {
+			w.Write(last())
+			return
+		}
+		fixture, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := ioutil.ReadFile("../testdata/" + fixture)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(body)
+	}))
+}
+
+// TestPublisher tests that new readings are published once, with the retained latest reading and the discovery
+// configs.
+func TestPublisher(t *testing.T) {
+	body, err := ioutil.ReadFile("../testdata/mesurades_dia_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture meteocat.Measurements
+	if err := json.Unmarshal(body, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, st := range fixture {
+		for _, v := range st.Variables {
+			total += len(v.Lectures)
+		}
+	}
+
+	var mu sync.Mutex
+	api := newAPIServer(t, func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return body
+	})
+	defer api.Close()
+
+	b, addr := newBroker(t)
+	defer b.Close()
+	client := newClient(t, addr)
+	defer client.Disconnect(100)
+
+	readings := subscribe(t, b, "meteocat/+/+", 1)
+	p, err := NewPublisher("", client, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(api.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.seedWait = 100 * time.Millisecond
+
+	n, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != total {
+		t.Errorf("expected %d published readings, got %d", total, n)
+	}
+	readings.wait(t, total)
+
+	d5 := readings.byName["meteocat/D5/T"]
+	if len(d5) != 48 || readings.retain["meteocat/D5/T"] {
+		t.Fatalf("expected 48 readings of D5 not retained, got %d", len(d5))
+	}
+	var first Reading
+	if err := json.Unmarshal(d5[0], &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Estacio != "D5" || first.Acronim != "T" || first.Unitats != "°C" || first.Data != "2023-03-12T00:00:00Z" {
+		t.Errorf("unexpected first reading %+v", first)
+	}
+
+	if n, err := p.Poll(); err != nil || n != 0 {
+		t.Errorf("expected no new readings, got %d: %v", n, err)
+	}
+
+	mu.Lock()
+	body = []byte(`[{"codi":"D5","variables":[{"codi":32,"lectures":[
+		{"data":"2023-03-12T23:30Z","valor":9.1,"estat":"V","baseHoraria":"SH"},
+		{"data":"2023-03-13T00:00Z","valor":8.7,"estat":" ","baseHoraria":"SH"}]}]}]`)
+	mu.Unlock()
+	if n, err := p.Poll(); err != nil || n != 1 {
+		t.Errorf("expected 1 new reading, got %d: %v", n, err)
+	}
+
+	latest := subscribe(t, b, "meteocat/D5/T/latest", 2)
+	if msgs := latest.byName["meteocat/D5/T/latest"]; len(msgs) != 1 || !latest.retain["meteocat/D5/T/latest"] {
+		t.Fatalf("expected a retained latest reading, got %d", len(msgs))
+	}
+	var last Reading
+	json.Unmarshal(latest.byName["meteocat/D5/T/latest"][0], &last)
+	if last.Valor != 8.7 || last.Data != "2023-03-13T00:00:00Z" || last.Estat != "" {
+		t.Errorf("unexpected latest reading %+v", last)
+	}
+
+	configs := subscribe(t, b, "homeassistant/sensor/+/config", 3)
+	if len(configs.topics) != len(fixture) {
+		t.Errorf("expected %d discovery configs, got %d", len(fixture), len(configs.topics))
+	}
+	var config map[string]interface{}
+	json.Unmarshal(configs.byName["homeassistant/sensor/meteocat_D5_32/config"][0], &config)
+	if config["state_topic"] != "meteocat/D5/T/latest" || config["unit_of_measurement"] != "°C" || config["device_class"] != "temperature" {
+		t.Errorf("unexpected discovery config %v", config)
+	}
+	if device := config["device"].(map[string]interface{}); device["name"] != "Barcelona - Observatori Fabra" {
+		t.Errorf("unexpected device %v", device)
+	}
+}
+
+// TestPublisherRestart tests that a restarted publisher reads the last published readings from the retained latest
+// topics and only publishes the newer ones.
+func TestPublisherRestart(t *testing.T) {
+	var mu sync.Mutex
+	body := []byte(`[{"codi":"D5","variables":[{"codi":32,"lectures":[
+		{"data":"2023-03-12T23:00Z","valor":9.4,"estat":"V","baseHoraria":"SH"},
+		{"data":"2023-03-12T23:30Z","valor":9.1,"estat":"V","baseHoraria":"SH"}]}]}]`)
+	api := newAPIServer(t, func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return body
+	})
+	defer api.Close()
+
+	b, addr := newBroker(t)
+	defer b.Close()
+	readings := subscribe(t, b, "meteocat/+/+", 1)
+
+	for i, want := range []int{2, 0} {
+		client := newClient(t, addr)
+		p, err := NewPublisher("", client, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(api.URL+"/xema/v1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.seedWait = 100 * time.Millisecond
+		if n, err := p.Poll(); err != nil || n != want {
+			t.Errorf("run %d: expected %d published readings, got %d: %v", i, want, n, err)
+		}
+		if i == 1 {
+			mu.Lock()
+			body = bytes.Replace(body, []byte(`"estat":"V"`), []byte(`"estat":"V"},{"data":"2023-03-13T00:00Z","valor":8.7,"estat":" "`), 1)
+			mu.Unlock()
+			if n, err := p.Poll(); err != nil || n != 1 {
+				t.Errorf("expected 1 new reading, got %d: %v", n, err)
+			}
+		}
+		client.Disconnect(100)
+	}
+
+	readings.wait(t, 3)
+	time.Sleep(100 * time.Millisecond)
+	readings.mu.Lock()
+	defer readings.mu.Unlock()
+	if n := len(readings.byName["meteocat/D5/T"]); n != 3 {
+		t.Errorf("expected 3 readings published once, got %d", n)
+	}
+}
+
+// TestPublisherOptions tests the validation of the options.
+func TestPublisherOptions(t *testing.T) {
+	client := paho.NewClient(paho.NewClientOptions())
+	if _, err := NewPublisher("", nil); err != errNoClient {
+		t.Errorf("expected errNoClient, got %v", err)
+	}
+	for _, op := range []Option{WithVariables(), WithTopicPrefix("/"), WithTopicPrefix("a/#"), WithDiscoveryPrefix("+"), WithQoS(3), nil} {
+		if _, err := NewPublisher("", client, op); err != errInvalidOption {
+			t.Errorf("expected errInvalidOption, got %v", err)
+		}
+	}
+
+	p, err := NewPublisher("", client, WithTopicPrefix("/xema/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic := p.Topic("D5", 32); topic != "xema/D5/32" {
+		t.Errorf("unexpected topic %q", topic)
+	}
+	if _, err := p.Poll(); err != errNotConnected {
+		t.Errorf("expected errNotConnected, got %v", err)
+	}
+}
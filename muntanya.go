@@ -0,0 +1,95 @@
+package meteocat
+
+import (
+	"fmt"
+	"math"
+)
+
+// AltitudAltaMuntanya is the minimum altitude in meters of a high-mountain station
+const AltitudAltaMuntanya = 2000.0
+
+// VentAltitud struct holds the forecast of the wind at a given altitude
+type VentAltitud struct {
+	Altitud   float64 `json:"altitud"`   // Altitude in meters of the forecast
+	Velocitat float64 `json:"velocitat"` // Wind speed in km/h
+	Direccio  float64 `json:"direccio"`  // Wind direction in degrees
+}
+
+// PrevisioMuntanya is an aggregate type which represents the forecast of a mountain point for a time period
+type PrevisioMuntanya struct {
+	DataInici     string        `json:"dataInici"`     // Start of the forecast period
+	DataFi        string        `json:"dataFi"`        // End of the forecast period
+	Temperatura   float64       `json:"temperatura"`   // Temperature in ºC at the altitude of the point
+	SensacioTermi float64       `json:"sensacioTermi"` // Wind chill in ºC at the altitude of the point
+	Isozero       float64       `json:"isozero"`       // Freezing level in meters
+	CotaNeu       float64       `json:"cotaNeu"`       // Snow level in meters
+	NeuNova       float64       `json:"neuNova"`       // Expected new snow in cm
+	Vent          []VentAltitud `json:"vent"`          // Wind at altitude
+	Cel           string        `json:"cel"`           // Sky state
+}
+
+// PuntMuntanya holds a summit or ski area and its forecasts
+type PuntMuntanya struct {
+	Codi        string             `json:"codi"`        // Identification code of the point
+	Nom         string             `json:"nom"`         // Name of the summit or ski area
+	Tipus       string             `json:"tipus"`       // Type of point, cim or pista
+	Coordenades Coordenades        `json:"coordenades"` // Georeference data of the point
+	Altitud     float64            `json:"altitud"`     // Altitude in meters of the point
+	Previsions  []PrevisioMuntanya `json:"previsions"`  // Forecasts of the point
+}
+
+// PuntsMuntanya is a slice which holds the high-mountain forecast points
+type PuntsMuntanya []PuntMuntanya
+
+// NearestHighStation returns the nearest station to the point among the operational stations placed at
+// AltitudAltaMuntanya meters or above, together with its distance in kilometers. Dismantled and temporarily closed
+// stations are skipped. It returns false when there are no operational high-mountain stations.
+func (pm PuntMuntanya) NearestHighStation(estacions MetadadesTotesEstacions) (MetadadesEstacions, float64, bool) {
+	var nearest MetadadesEstacions
+	distance := math.Inf(1)
+	for _, e := range estacions {
+		if e.Altitud < AltitudAltaMuntanya || e.EstatActual() != "ope" {
+			continue
+		}
+		if d := pm.Coordenades.Distance(e.Coordenades); d < distance {
+			nearest, distance = e, d
+		}
+	}
+	return nearest, distance, !math.IsInf(distance, 1)
+}
+
+// Muntanya holds all the data representations to unmarshall the high-mountain forecast API responses
+type Muntanya struct {
+	PuntsMuntanya
+	Key string
+	*Settings
+}
+
+// NewMuntanya returns a new Muntanya pointer with the supplied parameters
+func NewMuntanya(key string, options ...Option) (*Muntanya, error) {
+	mu := &Muntanya{
+		Settings: NewSettings(),
+	}
+
+	mu.Key, _ = setKey(key)
+
+	if err := setOptions(mu.Settings, options); err != nil {
+		return nil, err
+	}
+
+	return mu, nil
+}
+
+// Returns the high-mountain forecast of the summits of the Pyrenees and the Prepyrenees.
+// The API resource is /muntanya/cims and there are no parameters. Request example: https://api.meteo.cat/pronostic/v1/muntanya/cims
+func (mu *Muntanya) Summits() error {
+	mu.PuntsMuntanya = nil
+	return mu.get(mu.Key, fmt.Sprintf(pronosticURL, "/muntanya/cims"), &mu.PuntsMuntanya)
+}
+
+// Returns the forecast of the ski areas.
+// The API resource is /muntanya/pistes and there are no parameters. Request example: https://api.meteo.cat/pronostic/v1/muntanya/pistes
+func (mu *Muntanya) SkiAreas() error {
+	mu.PuntsMuntanya = nil
+	return mu.get(mu.Key, fmt.Sprintf(pronosticURL, "/muntanya/pistes"), &mu.PuntsMuntanya)
+}
@@ -0,0 +1,54 @@
+package meteocat
+
+import (
+	"testing"
+)
+
+// TestSummitsNearestHighStation tests that each summit is linked to its nearest high-mountain station.
+func TestSummitsNearestHighStation(t *testing.T) {
+	defer newFixtureServerFor(t, &pronosticURL, "/muntanya/cims", "muntanya_cims.json")()
+	defer newFixtureServer(t, "/estacions/metadades", "metadades_totes_estacions.json")()
+
+	mu, _ := NewMuntanya("")
+	if err := mu.Summits(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := NewEstacions("")
+	p, _ := NewParameters()
+	if err := e.StationsAll(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mu.PuntsMuntanya) != 2 {
+		t.Fatalf("expected 2 summits, got %d", len(mu.PuntsMuntanya))
+	}
+	if pr := mu.PuntsMuntanya[0].Previsions[0]; pr.Isozero != 1800 || len(pr.Vent) != 2 || pr.Vent[1].Velocitat != 70 {
+		t.Errorf("unexpected forecast: %+v", pr)
+	}
+
+	// Closer stations which are dismantled or temporarily closed are skipped
+	estacions := e.MetadadesTotesEstacions
+	for i, codi := range map[int]int{0: 1, 1: 3} {
+		st := MetadadesEstacions{Codi: "X" + mu.PuntsMuntanya[i].Codi, Coordenades: mu.PuntsMuntanya[i].Coordenades, Altitud: 2900}
+		st.Estats = Estats{
+			{Codi: 2, DataInici: "2001-01-01T00:00Z", DataFi: "2020-01-01T00:00Z"},
+			{Codi: codi, DataInici: "2020-01-01T00:00Z"},
+		}
+		estacions = append(estacions, st)
+	}
+
+	for summit, want := range map[int]string{0: "Z5", 1: "ZC"} {
+		st, d, ok := mu.PuntsMuntanya[summit].NearestHighStation(estacions)
+		if !ok || st.Codi != want {
+			t.Errorf("%s: expected station %s, got %s", mu.PuntsMuntanya[summit].Nom, want, st.Codi)
+		}
+		if d <= 0 || d > 20 {
+			t.Errorf("%s: unexpected distance %v km", mu.PuntsMuntanya[summit].Nom, d)
+		}
+	}
+
+	if _, _, ok := mu.PuntsMuntanya[0].NearestHighStation(nil); ok {
+		t.Error("expected no station on an empty catalogue")
+	}
+}
@@ -0,0 +1,359 @@
+// Package poll emits the new and revised XEMA readings once. The last measurements of a variable cover the last 4
+// hours, so polling them naively returns the same readings again and again. A Poller keeps a watermark per station
+// and variable, the time of the newest emitted reading, and emits only the readings after it. The readings emitted
+// within the retention are remembered too, so a reading returned again with another value or state, e.g validated
+// after being provisional, is emitted as a revision, and a reading within the retention returned late, after newer
+// ones, is emitted as a new one.
+//
+// The watermarks are persisted to a JSON file after each poll, so a restarted poller carries on where it stopped:
+//
+//	p, err := poll.NewPoller(key, "/var/lib/meteocat/watermarks.json", poll.WithVariables(32, 33, 35))
+//	for ev := range p.Events(ctx, 30*time.Minute) {
+//		s.Write(ev.Row)
+//	}
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// DefaultRetention is how long the emitted readings are remembered to detect their revisions
+const DefaultRetention = 24 * time.Hour
+
+var errInvalidOption = errors.New("invalid option")
+
+// Event is a new or revised reading
+type Event struct {
+	export.Row
+	Revised  bool              // The reading was emitted before with another value or state
+	Previous *meteocat.Lectura // The reading emitted before, for revisions
+}
+
+// series holds the watermark and the emitted readings of a variable at a station
+type series struct {
+	Watermark time.Time                   `json:"watermark"`
+	Readings  map[string]meteocat.Lectura `json:"readings"` // Emitted readings by RFC 3339 time
+}
+
+// Poller polls the last measurements of its variables and emits each new or revised reading once
+type Poller struct {
+	key       string
+	options   []meteocat.Option
+	codes     []int
+	path      string
+	retention time.Duration
+
+	mu     sync.Mutex
+	series map[string]*series // By station and variable code, e.g D5/32
+	err    error
+}
+
+// Option configures a Poller
+type Option func(p *Poller) error
+
+// WithClientOptions sets the options of the clients which request the readings, e.g meteocat.WithCache.
+func WithClientOptions(options ...meteocat.Option) Option {
+	return func(p *Poller) error {
+		p.options = options
+		return nil
+	}
+}
+
+// WithVariables sets the codes of the polled variables, by default 32, 33 and 35.
+func WithVariables(codes ...int) Option {
+	return func(p *Poller) error {
+		if len(codes) == 0 {
+			return errInvalidOption
+		}
+		p.codes = codes
+		return nil
+	}
+}
+
+// WithRetention sets how long the emitted readings are remembered to detect their revisions, DefaultRetention by
+// default. Revisions of older readings are not emitted.
+func WithRetention(d time.Duration) Option {
+	return func(p *Poller) error {
+		if d <= 0 {
+			return errInvalidOption
+		}
+		p.retention = d
+		return nil
+	}
+}
+
+// NewPoller returns a new Poller pointer which requests the readings with key and persists its watermarks to the
+// file of path, loading them if it exists
+func NewPoller(key, path string, options ...Option) (*Poller, error) {
+	p := &Poller{
+		key:       key,
+		codes:     []int{32, 33, 35},
+		path:      path,
+		retention: DefaultRetention,
+		series:    make(map[string]*series),
+	}
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(p); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := meteocat.NewMesurades(key, p.options...); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return p, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &p.series); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Watermark returns the time of the newest emitted reading of a variable at a station
+func (p *Poller) Watermark(estacio string, variable int) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.series[strings.ToUpper(estacio)+"/"+strconv.Itoa(variable)]; ok {
+		return s.Watermark
+	}
+	return time.Time{}
+}
+
+// Err returns the error of the last poll of Events
+func (p *Poller) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// changes requests the last measurements of the variables and returns their new and revised readings, in time
+// order for each station and variable. The state is not updated.
+func (p *Poller) changes() ([]Event, error) {
+	var events []Event
+	for _, codi := range p.codes {
+		m, err := meteocat.NewMesurades(p.key, p.options...)
+		if err != nil {
+			return nil, err
+		}
+		params, err := meteocat.NewParameters(meteocat.OptionCodiVariable(strconv.Itoa(codi)))
+		if err != nil {
+			return nil, err
+		}
+		if err := m.MeasurementLast(params); err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		for _, st := range m.Measurements {
+			for _, v := range st.Variables {
+				if v.Codi == codi {
+					events = append(events, p.diff(st.Codi, v)...)
+				}
+			}
+		}
+		p.mu.Unlock()
+	}
+	return events, nil
+}
+
+// diff returns the new and revised readings of a variable at a station. Readings at or before the watermark which
+// were not emitted are new if they are within the retention.
+func (p *Poller) diff(estacio string, v meteocat.Variable) []Event {
+	type timed struct {
+		t  time.Time
+		ev Event
+	}
+	s := p.series[estacio+"/"+strconv.Itoa(v.Codi)]
+
+	var changed []timed
+	for _, l := range v.Lectures {
+		t, err := meteocat.ParseData(l.Data)
+		if err != nil {
+			continue
+		}
+		ev := Event{Row: export.Row{Estacio: estacio, Variable: v.Codi, Lectura: l}}
+		if s != nil && !t.After(s.Watermark) {
+			prev, ok := s.Readings[t.UTC().Format(time.RFC3339)]
+			switch {
+			case !ok && t.Before(s.Watermark.Add(-p.retention)):
+				continue
+			case !ok:
+			case prev.Valor == l.Valor && strings.TrimSpace(prev.Estat) == strings.TrimSpace(l.Estat):
+				continue
+			default:
+				ev.Revised = true
+				ev.Previous = &prev
+			}
+		}
+		changed = append(changed, timed{t, ev})
+	}
+	sort.SliceStable(changed, func(i, j int) bool { return changed[i].t.Before(changed[j].t) })
+
+	events := make([]Event, len(changed))
+	for i, c := range changed {
+		events[i] = c.ev
+	}
+	return events
+}
+
+// commit records an emitted event in the state
+func (p *Poller) commit(ev Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record(p.series, ev)
+}
+
+// record records an emitted event in the series of a state
+func (p *Poller) record(state map[string]*series, ev Event) {
+	t, err := meteocat.ParseData(ev.Lectura.Data)
+	if err != nil {
+		return
+	}
+	key := ev.Estacio + "/" + strconv.Itoa(ev.Variable)
+	s, ok := state[key]
+	if !ok {
+		s = &series{Readings: make(map[string]meteocat.Lectura)}
+		state[key] = s
+	}
+	if t.After(s.Watermark) {
+		s.Watermark = t.UTC()
+	}
+	s.Readings[t.UTC().Format(time.RFC3339)] = ev.Lectura
+
+	oldest := s.Watermark.Add(-p.retention)
+	for k := range s.Readings {
+		if rt, err := time.Parse(time.RFC3339, k); err == nil && rt.Before(oldest) {
+			delete(s.Readings, k)
+		}
+	}
+}
+
+// clone returns a copy of the state
+func (p *Poller) clone() map[string]*series {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := make(map[string]*series, len(p.series))
+	for key, s := range p.series {
+		readings := make(map[string]meteocat.Lectura, len(s.Readings))
+		for k, l := range s.Readings {
+			readings[k] = l
+		}
+		state[key] = &series{Watermark: s.Watermark, Readings: readings}
+	}
+	return state
+}
+
+// save persists a copy of the state, writing a temporary file first so a crash never leaves a partial file
+func (p *Poller) save(state map[string]*series) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p.path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), p.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Poll requests the last measurements of the variables and returns their new and revised readings, which are
+// recorded as emitted and persisted before returning. When the state cannot be persisted, the readings are not
+// recorded either and are returned again by the next poll.
+func (p *Poller) Poll() ([]Event, error) {
+	events, err := p.changes()
+	if err != nil {
+		return nil, err
+	}
+	state := p.clone()
+	for _, ev := range events {
+		p.record(state, ev)
+	}
+	if err := p.save(state); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.series = state
+	p.mu.Unlock()
+	return events, nil
+}
+
+// Events polls every interval and sends the new and revised readings on the returned channel, which is closed when
+// the context is cancelled. An event is recorded as emitted once it has been received, and the state is persisted
+// after each poll, so a crash of the process re-emits at most the events received since the last poll. Failed polls
+// are logged, reported by Err and retried on the next tick.
+func (p *Poller) Events(ctx context.Context, interval time.Duration) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			events, err := p.changes()
+			sent := 0
+			for _, ev := range events {
+				select {
+				case ch <- ev:
+					p.commit(ev)
+					sent++
+				case <-ctx.Done():
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+			if err == nil && sent > 0 {
+				err = p.save(p.clone())
+			}
+			if err != nil {
+				log.Printf("poll: %v", err)
+			}
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,297 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+)
+
+// api is a test server answering the last temperatures with a body which can be replaced
+type api struct {
+	*httptest.Server
+	mu   sync.Mutex
+	body []byte
+}
+
+// newAPI starts a test server answering the fixture day of temperatures, or the temperatures of a single station
+// when one is requested
+func newAPI(t *testing.T) *api {
+	t.Helper()
+
+	body, err := ioutil.ReadFile("../testdata/mesurades_dia_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &api{body: body}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xema/v1/variables/mesurades/32/ultimes" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("codiEstacio") != "" {
+			w.Write([]byte(`{"codi":32,"lectures":[{"data":"2023-03-12T23:30Z","valor":9.8,"estat":"V","baseHoraria":"SH"}]}`))
+			return
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		w.Write(a.body)
+	}))
+	return a
+}
+
+// set replaces the body of the responses
+func (a *api) set(body string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.body = []byte(body)
+}
+
+// total returns the number of readings of the fixture
+func total(t *testing.T) int {
+	t.Helper()
+
+	b, err := ioutil.ReadFile("../testdata/mesurades_dia_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m meteocat.Measurements
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, st := range m {
+		for _, v := range st.Variables {
+			n += len(v.Lectures)
+		}
+	}
+	return n
+}
+
+// TestPoll tests that readings are emitted once across polls and restarts, and that revisions are emitted.
+func TestPoll(t *testing.T) {
+	a := newAPI(t)
+	defer a.Close()
+	dir, remove := testutil.TempDir(t, "meteocat-poll")
+	defer remove()
+	path := filepath.Join(dir, "watermarks.json")
+
+	p, err := NewPoller("", path, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(a.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != total(t) {
+		t.Errorf("expected %d events, got %d", total(t), len(events))
+	}
+	for _, ev := range events {
+		if ev.Revised {
+			t.Fatalf("unexpected revision %+v", ev)
+		}
+	}
+	if w := p.Watermark("d5", 32); !w.Equal(time.Date(2023, 3, 12, 23, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected watermark %v", w)
+	}
+	if events, err := p.Poll(); err != nil || len(events) != 0 {
+		t.Errorf("expected no events, got %d: %v", len(events), err)
+	}
+
+	// A restarted poller loads the watermarks
+	p, err = NewPoller("", path, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(a.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events, err := p.Poll(); err != nil || len(events) != 0 {
+		t.Errorf("expected no events after a restart, got %d: %v", len(events), err)
+	}
+
+	a.set(`[{"codi":"D5","variables":[{"codi":32,"lectures":[
+		{"data":"2023-03-13T00:00Z","valor":8.7,"estat":"T","baseHoraria":"SH"},
+		{"data":"2023-03-12T23:30Z","valor":9.9,"estat":"V","baseHoraria":"SH"},
+		{"data":"2023-03-12T23:00Z","valor":15.1,"estat":"V","baseHoraria":"SH"}]}]}]`)
+	events, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected a revision and a new reading, got %+v", events)
+	}
+	if !events[0].Revised || events[0].Lectura.Data != "2023-03-12T23:30Z" || events[0].Previous == nil || events[0].Previous.Valor == 9.9 {
+		t.Errorf("unexpected revision %+v", events[0])
+	}
+	if events[1].Revised || events[1].Estacio != "D5" || events[1].Lectura.Data != "2023-03-13T00:00Z" {
+		t.Errorf("unexpected new reading %+v", events[1])
+	}
+
+	// The provisional reading is validated
+	a.set(`[{"codi":"D5","variables":[{"codi":32,"lectures":[
+		{"data":"2023-03-13T00:00Z","valor":8.7,"estat":"V","baseHoraria":"SH"}]}]}]`)
+	events, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !events[0].Revised || events[0].Previous.Estat != "T" || events[0].Lectura.Estat != "V" {
+		t.Errorf("expected the validation of the reading, got %+v", events)
+	}
+	if events, err := p.Poll(); err != nil || len(events) != 0 {
+		t.Errorf("expected no events, got %d: %v", len(events), err)
+	}
+
+	// A late reading within the retention is new, one before it is ignored
+	a.set(`[{"codi":"D5","variables":[{"codi":32,"lectures":[
+		{"data":"2023-03-11T23:30Z","valor":7.2,"estat":"V","baseHoraria":"SH"},
+		{"data":"2023-03-12T22:45Z","valor":9.6,"estat":"V","baseHoraria":"SH"},
+		{"data":"2023-03-13T00:00Z","valor":8.7,"estat":"V","baseHoraria":"SH"}]}]}]`)
+	events, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Revised || events[0].Lectura.Data != "2023-03-12T22:45Z" {
+		t.Errorf("expected the late reading, got %+v", events)
+	}
+	if w := p.Watermark("D5", 32); !w.Equal(time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected watermark %v", w)
+	}
+	if events, err := p.Poll(); err != nil || len(events) != 0 {
+		t.Errorf("expected no events, got %d: %v", len(events), err)
+	}
+}
+
+// TestPollSaveError tests that the readings of a poll whose state cannot be persisted are returned again by the next
+// poll.
+func TestPollSaveError(t *testing.T) {
+	a := newAPI(t)
+	defer a.Close()
+	dir, remove := testutil.TempDir(t, "meteocat-poll")
+	defer remove()
+	path := filepath.Join(dir, "state", "watermarks.json")
+
+	p, err := NewPoller("", path, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(a.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Poll(); err == nil {
+		t.Fatal("expected an error for the missing state directory")
+	}
+	if w := p.Watermark("D5", 32); !w.IsZero() {
+		t.Errorf("unexpected watermark %v", w)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "state"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	events, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := total(t); len(events) != want {
+		t.Errorf("expected %d events, got %d", want, len(events))
+	}
+}
+
+// TestPollAfterStationCall tests that the readings of all the stations are emitted after a call for a single station
+// in the same process.
+func TestPollAfterStationCall(t *testing.T) {
+	a := newAPI(t)
+	defer a.Close()
+	dir, remove := testutil.TempDir(t, "meteocat-poll")
+	defer remove()
+
+	m, err := meteocat.NewMesurades("", meteocat.WithBaseURL(a.URL+"/xema/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, _ := meteocat.NewParameters(meteocat.OptionCodiVariable("32"), meteocat.OptionCodiEstacio("D5"))
+	if err := m.MeasurementLast(params); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Variable.Lectures) != 1 {
+		t.Fatalf("unexpected variable %+v", m.Variable)
+	}
+
+	p, err := NewPoller("", filepath.Join(dir, "watermarks.json"), WithVariables(32), WithClientOptions(meteocat.WithBaseURL(a.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := total(t); len(events) != want {
+		t.Errorf("expected %d events, got %d", want, len(events))
+	}
+}
+
+// TestEvents tests that the events are sent on the channel and persisted once received.
+func TestEvents(t *testing.T) {
+	a := newAPI(t)
+	defer a.Close()
+	dir, remove := testutil.TempDir(t, "meteocat-poll")
+	defer remove()
+	path := filepath.Join(dir, "watermarks.json")
+
+	p, err := NewPoller("", path, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(a.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := p.Events(ctx, 10*time.Millisecond)
+	want := total(t)
+	n := 0
+	for range ch {
+		n++
+		if n == want {
+			a.set(`[{"codi":"D5","variables":[{"codi":32,"lectures":[{"data":"2023-03-13T00:00Z","valor":8.7,"estat":"V"}]}]}]`)
+		}
+		if n == want+1 {
+			cancel()
+		}
+	}
+	if n != want+1 {
+		t.Errorf("expected %d events, got %d", want+1, n)
+	}
+	if err := p.Err(); err != nil {
+		t.Error(err)
+	}
+
+	p, err = NewPoller("", path, WithVariables(32), WithClientOptions(meteocat.WithBaseURL(a.URL+"/xema/v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := p.Watermark("D5", 32); !w.Equal(time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected persisted watermark %v", w)
+	}
+}
+
+// TestOptions tests the validation of the options and of the state file.
+func TestOptions(t *testing.T) {
+	dir, remove := testutil.TempDir(t, "meteocat-poll")
+	defer remove()
+	path := filepath.Join(dir, "watermarks.json")
+
+	for _, op := range []Option{WithVariables(), WithRetention(0), nil} {
+		if _, err := NewPoller("", path, op); err != errInvalidOption {
+			t.Errorf("expected errInvalidOption, got %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPoller("", path); err == nil {
+		t.Error("expected an error for a corrupt state file")
+	}
+}
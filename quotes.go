@@ -0,0 +1,64 @@
+package meteocat
+
+import (
+	"fmt"
+	"strings"
+)
+
+var quotesURL = "https://api.meteo.cat/quotes/v1%s"
+
+// Pla struct holds the consumption of an API plan in the current period
+type Pla struct {
+	Nom                  string `json:"nom"`                  // Name of the plan, e.g XEMA_750
+	Periode              string `json:"periode"`              // Period of the quota, e.g Mensual
+	MaxConsultes         int    `json:"maxConsultes"`         // Maximum number of requests in the period
+	ConsultesRestants    int    `json:"consultesRestants"`    // Remaining requests in the period
+	ConsultesRealitzades int    `json:"consultesRealitzades"` // Requests made in the period
+}
+
+// Consum is an aggregate type which represents the quota consumption of an API key
+type Consum struct {
+	Client struct {
+		Nom string `json:"nom"` // Name of the client of the key
+	} `json:"client"`
+	Plans []Pla `json:"plans"` // Plans of the key, one per API
+}
+
+// Plan returns the plan whose name starts with prefix, e.g XEMA, ignoring case. It returns false when there is none.
+func (c Consum) Plan(prefix string) (Pla, bool) {
+	for _, p := range c.Plans {
+		if strings.HasPrefix(strings.ToUpper(p.Nom), strings.ToUpper(prefix)) {
+			return p, true
+		}
+	}
+	return Pla{}, false
+}
+
+// Quotes holds all the data representations to unmarshall the quota API responses
+type Quotes struct {
+	Consum
+	Key string
+	*Settings
+}
+
+// NewQuotes returns a new Quotes pointer with the supplied parameters
+func NewQuotes(key string, options ...Option) (*Quotes, error) {
+	q := &Quotes{
+		Settings: NewSettings(),
+	}
+
+	q.Key, _ = setKey(key)
+	if err := setOptions(q.Settings, options); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Returns the consumption of every plan of the API key in the current period. Requests to this resource do not
+// count towards the quota. The API resource is /consum-actual and there are no parameters.
+// Request example: https://api.meteo.cat/quotes/v1/consum-actual
+func (q *Quotes) Consumption() error {
+	q.Consum = Consum{}
+	return q.get(q.Key, fmt.Sprintf(quotesURL, "/consum-actual"), &q.Consum)
+}
@@ -0,0 +1,25 @@
+package meteocat
+
+import "testing"
+
+// TestConsumption tests that Consumption decodes the plans of the key and Plan finds them by prefix.
+func TestConsumption(t *testing.T) {
+	defer newFixtureServerFor(t, &quotesURL, "/consum-actual", "quotes_consum_actual.json")()
+
+	q, _ := NewQuotes("")
+	if err := q.Consumption(); err != nil {
+		t.Fatal(err)
+	}
+
+	if q.Client.Nom != "Exemple" || len(q.Plans) != 3 {
+		t.Fatalf("unexpected consumption: %+v", q.Consum)
+	}
+
+	p, ok := q.Plan("xema")
+	if !ok || p.Nom != "XEMA_750" || p.MaxConsultes != 750 || p.ConsultesRestants != 612 {
+		t.Errorf("unexpected XEMA plan: %+v", p)
+	}
+	if _, ok := q.Plan("radar"); ok {
+		t.Error("expected no radar plan")
+	}
+}
@@ -0,0 +1,262 @@
+// Package render draws the readings of a meteocat.Variable on a terminal as a sparkline or as a line chart with
+// a labelled time axis. Missing readings and readings that have not been validated yet are marked.
+package render
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// Chart symbols
+const (
+	Validated    = '●' // Validated reading
+	NotValidated = '○' // Reading not validated yet
+	Gap          = '┊' // Missing readings
+)
+
+// EstatValidated is the estat of the readings validated by Meteocat
+const EstatValidated = "V"
+
+var errNoReadings = errors.New("no readings to render")
+
+// sparks holds the block characters used by Sparkline from lowest to highest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparksNotValidated holds the dotted characters used by Sparkline for the readings not validated yet, from lowest
+// to highest like sparks
+var sparksNotValidated = []rune("⡀⣀⣄⣤⣦⣶⣷⣿")
+
+// basesHoraries maps the time base of the readings to the interval between them
+var basesHoraries = map[string]time.Duration{
+	"SH": 30 * time.Minute, // Semihorària
+	"HO": time.Hour,        // Horària
+	"DM": 24 * time.Hour,   // Diària
+}
+
+// Options holds the settings of a chart
+type Options struct {
+	Width    int    // Width in columns of the plot area, 72 if zero
+	Height   int    // Height in rows of the plot area, 10 if zero
+	Unitats  string // Unit of the values shown on the y axis, e.g ºC
+	Decimals int    // Number of decimals of the values shown on the y axis
+}
+
+// OptionsFor returns the chart options of a variable using the units and decimals of its metadata
+func OptionsFor(meta meteocat.MetadadesVariable) Options {
+	return Options{Unitats: meta.Unitats, Decimals: meta.Decimals}
+}
+
+// point holds a reading placed on the time axis
+type point struct {
+	t         time.Time
+	valor     float64
+	validated bool
+}
+
+// column holds the readings aggregated in a column of the chart
+type column struct {
+	sum       float64
+	n         int
+	validated bool
+}
+
+// value returns the mean of the readings of the column
+func (c column) value() float64 {
+	return c.sum / float64(c.n)
+}
+
+// points returns the readings of v sorted by time together with the interval between them
+func points(v meteocat.Variable) ([]point, time.Duration, error) {
+	var ps []point
+	var step time.Duration
+	for _, l := range v.Lectures {
+		t, err := meteocat.ParseData(l.Data)
+		if err != nil {
+			return nil, 0, err
+		}
+		ps = append(ps, point{t: t, valor: l.Valor, validated: l.Estat == EstatValidated})
+		if d, ok := basesHoraries[l.BaseHoraria]; ok && step == 0 {
+			step = d
+		}
+	}
+	if len(ps) == 0 {
+		return nil, 0, errNoReadings
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].t.Before(ps[j].t) })
+
+	// Infer the interval from the readings when the time base is unknown.
+	if step == 0 {
+		for i := 1; i < len(ps); i++ {
+			if d := ps[i].t.Sub(ps[i-1].t); d > 0 && (step == 0 || d < step) {
+				step = d
+			}
+		}
+		if step == 0 {
+			step = 30 * time.Minute
+		}
+	}
+
+	return ps, step, nil
+}
+
+// columns places the readings on n columns. Columns without readings are gaps.
+func columns(ps []point, step time.Duration, width int) []*column {
+	slots := int(ps[len(ps)-1].t.Sub(ps[0].t)/step) + 1
+	n := slots
+	if width > 0 && n > width {
+		n = width
+	}
+
+	cols := make([]*column, n)
+	for _, p := range ps {
+		slot := int(p.t.Sub(ps[0].t) / step)
+		i := slot * n / slots
+		if cols[i] == nil {
+			cols[i] = &column{validated: true}
+		}
+		cols[i].sum += p.valor
+		cols[i].n++
+		cols[i].validated = cols[i].validated && p.validated
+	}
+	return cols
+}
+
+// Sparkline returns the readings of v as a single line of block characters. Readings not validated yet are drawn
+// with dotted characters of the same height and missing readings are left blank.
+func Sparkline(v meteocat.Variable) string {
+	ps, step, err := points(v)
+	if err != nil {
+		return ""
+	}
+	cols := columns(ps, step, 0)
+
+	min, max := bounds(cols)
+	var b strings.Builder
+	for _, c := range cols {
+		if c == nil {
+			b.WriteRune(' ')
+			continue
+		}
+		if c.validated {
+			b.WriteRune(sparks[scale(c.value(), min, max, len(sparks))])
+		} else {
+			b.WriteRune(sparksNotValidated[scale(c.value(), min, max, len(sparksNotValidated))])
+		}
+	}
+	return b.String()
+}
+
+// bounds returns the minimum and maximum values of the columns
+func bounds(cols []*column) (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, c := range cols {
+		if c == nil {
+			continue
+		}
+		min = math.Min(min, c.value())
+		max = math.Max(max, c.value())
+	}
+	return min, max
+}
+
+// scale maps v in [min, max] to an integer in [0, n)
+func scale(v, min, max float64, n int) int {
+	if max == min {
+		return n / 2
+	}
+	i := int(math.Round((v - min) / (max - min) * float64(n-1)))
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// Chart returns the readings of v as a line chart with the values on the y axis and the time in UTC on the x axis.
+// Validated readings are drawn with Validated, the other ones with NotValidated and missing readings with Gap.
+func Chart(v meteocat.Variable, o Options) (string, error) {
+	if o.Width <= 0 {
+		o.Width = 72
+	}
+	if o.Height <= 0 {
+		o.Height = 10
+	}
+
+	ps, step, err := points(v)
+	if err != nil {
+		return "", err
+	}
+	cols := columns(ps, step, o.Width)
+	min, max := bounds(cols)
+
+	// The labels of the y axis show the maximum, the middle and the minimum values.
+	label := func(f float64) string {
+		s := strconv.FormatFloat(f, 'f', o.Decimals, 64)
+		if o.Unitats != "" {
+			s += " " + o.Unitats
+		}
+		return s
+	}
+	labels := map[int]string{0: label(max), o.Height - 1: label(min)}
+	if o.Height > 2 {
+		labels[(o.Height-1)/2] = label(max - (max-min)*float64((o.Height-1)/2)/float64(o.Height-1))
+	}
+	margin := 0
+	for _, l := range labels {
+		if n := utf8.RuneCountInString(l); n > margin {
+			margin = n
+		}
+	}
+
+	grid := make([][]rune, o.Height)
+	for r := range grid {
+		grid[r] = []rune(strings.Repeat(" ", len(cols)))
+	}
+	for i, c := range cols {
+		if c == nil {
+			for r := range grid {
+				grid[r][i] = Gap
+			}
+			continue
+		}
+		r := o.Height - 1 - scale(c.value(), min, max, o.Height)
+		grid[r][i] = Validated
+		if !c.validated {
+			grid[r][i] = NotValidated
+		}
+	}
+
+	var b strings.Builder
+	for r, row := range grid {
+		fmt.Fprintf(&b, "%*s │%s\n", margin, labels[r], strings.TrimRight(string(row), " "))
+	}
+	fmt.Fprintf(&b, "%*s └%s\n", margin, "", strings.Repeat("─", len(cols)))
+	b.WriteString(timeAxis(ps[0].t, ps[len(ps)-1].t, len(cols), margin+2))
+	fmt.Fprintf(&b, "%*s  %c validated  %c not validated  %c missing\n", margin, "", Validated, NotValidated, Gap)
+
+	return b.String(), nil
+}
+
+// timeAxis returns the labels of the time axis placed every 12 columns
+func timeAxis(from, to time.Time, n, indent int) string {
+	axis := []rune(strings.Repeat(" ", indent+n+5))
+	span := to.Sub(from)
+	for i := 0; i < n; i += 12 {
+		t := from
+		if n > 1 {
+			t = from.Add(time.Duration(float64(span) * float64(i) / float64(n-1)))
+		}
+		copy(axis[indent+i:], []rune(t.Format("15:04")))
+	}
+	return strings.TrimRight(string(axis), " ") + "\n"
+}
@@ -0,0 +1,109 @@
+package render
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// loadVariable reads the readings of a testdata fixture
+func loadVariable(t *testing.T) meteocat.Variable {
+	t.Helper()
+
+	b, err := ioutil.ReadFile("../testdata/dades_variable_32_estacio_D5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v meteocat.Variable
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// TestSparkline tests that a sparkline has a character per reading and blanks for the gaps.
+func TestSparkline(t *testing.T) {
+	v := loadVariable(t)
+
+	s := Sparkline(v)
+	if n := utf8.RuneCountInString(s); n != len(v.Lectures) {
+		t.Errorf("expected %d characters, got %d: %s", len(v.Lectures), n, s)
+	}
+
+	// Drop two readings in the middle of the day.
+	v.Lectures = append(v.Lectures[:10:10], v.Lectures[12:]...)
+	s = Sparkline(v)
+	if n := utf8.RuneCountInString(s); n != len(v.Lectures)+2 || strings.Count(s, " ") != 2 {
+		t.Errorf("expected two gaps, got %q", s)
+	}
+
+	if Sparkline(meteocat.Variable{}) != "" {
+		t.Error("expected an empty sparkline without readings")
+	}
+}
+
+// TestSparklineNotValidated tests that the readings not validated yet are marked with the dotted characters.
+func TestSparklineNotValidated(t *testing.T) {
+	v := meteocat.Variable{Codi: 32, Lectures: []meteocat.Lectura{
+		{Data: "2023-03-12T22:00Z", Valor: 10, Estat: "V", BaseHoraria: "SH"},
+		{Data: "2023-03-12T22:30Z", Valor: 12, Estat: "V", BaseHoraria: "SH"},
+		{Data: "2023-03-12T23:00Z", Valor: 14, Estat: " ", BaseHoraria: "SH"},
+		{Data: "2023-03-12T23:30Z", Valor: 10, Estat: "T", BaseHoraria: "SH"},
+	}}
+
+	if s := Sparkline(v); s != "▁▅⣿⡀" {
+		t.Errorf("unexpected sparkline %q", s)
+	}
+}
+
+// TestChart tests the chart layout, its labels and the marks of gaps and non validated readings.
+func TestChart(t *testing.T) {
+	v := loadVariable(t)
+	v.Lectures = append(v.Lectures[:10:10], v.Lectures[12:]...)
+	v.Lectures[len(v.Lectures)-1].Estat = " "
+
+	s, err := Chart(v, Options{Height: 8, Unitats: "°C", Decimals: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+
+	if len(lines) != 8+3 {
+		t.Fatalf("expected 11 lines, got %d:\n%s", len(lines), s)
+	}
+	if !strings.Contains(lines[0], "°C │") || !strings.Contains(lines[7], "°C │") {
+		t.Errorf("expected labelled y axis:\n%s", s)
+	}
+	if strings.Count(s, string(Gap)) != 2*8+1 {
+		t.Errorf("expected two gap columns:\n%s", s)
+	}
+	if strings.Count(s, string(NotValidated)) != 2 {
+		t.Errorf("expected one non validated reading:\n%s", s)
+	}
+	if !strings.Contains(lines[9], "00:00") || !strings.Contains(lines[9], "06:00") {
+		t.Errorf("expected a labelled time axis:\n%s", s)
+	}
+
+	if _, err := Chart(meteocat.Variable{}, Options{}); err != errNoReadings {
+		t.Errorf("expected %v, got %v", errNoReadings, err)
+	}
+}
+
+// TestChartWidth tests that readings are aggregated when they do not fit in the width.
+func TestChartWidth(t *testing.T) {
+	v := loadVariable(t)
+
+	s, err := Chart(v, Options{Width: 24, Height: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(s, "\n")
+	axis := lines[5]
+	if n := strings.Count(axis, "─"); n != 24 {
+		t.Errorf("expected 24 columns, got %d:\n%s", n, s)
+	}
+}
@@ -0,0 +1,62 @@
+package meteocat
+
+import (
+	"fmt"
+)
+
+// EstacioRepresentativa holds a station that represents a municipi for a given variable. The order sets the priority
+// of the station when a municipi has several representative stations.
+type EstacioRepresentativa struct {
+	CodiEstacio string `json:"codiEstacio"` // Identification code of the station
+	Ordre       int    `json:"ordre"`       // Priority of the station, 1 being the most representative
+}
+
+// EstacionsRepresentatives is a slice which holds the representative stations of a municipi
+type EstacionsRepresentatives []EstacioRepresentativa
+
+// Returns the representative stations of a municipi for a variable.
+// The API resource is /representatives/metadades/municipis/{codiMunicipi}/variables/{codiVariable} where both
+// parameters are mandatory. Request example: https://api.meteo.cat/xema/v1/representatives/metadades/municipis/080193/variables/32
+func (m *Mesurades) RepresentativeStations(p *Parameters) error {
+
+	if ValidCodiVariable(p.codiVariable) {
+		m.CodiVariable = p.codiVariable
+	} else {
+		return errVariableUnavailable
+	}
+
+	if !ValidCodiMunicipi(p.codiMunicipi) {
+		return errMunicipiUnavailable
+	}
+
+	m.EstacionsRepresentatives = nil
+	return m.get(m.Key, m.endpoint(fmt.Sprintf("/representatives/metadades/municipis/%s/variables/%s", p.codiMunicipi, p.codiVariable)), &m.EstacionsRepresentatives)
+}
+
+// MeasurementLastByMunicipi returns the last measurements of a variable at the most representative station of a
+// municipi. It looks up the representative stations with RepresentativeStations and then calls MeasurementLast for
+// the first of them, so the readings end up in the Variable field.
+func (m *Mesurades) MeasurementLastByMunicipi(p *Parameters) error {
+
+	if err := m.RepresentativeStations(p); err != nil {
+		return err
+	}
+
+	best := -1
+	for i, e := range m.EstacionsRepresentatives {
+		if !ValidCodiEstacio(e.CodiEstacio) {
+			continue
+		}
+		if best < 0 || e.Ordre < m.EstacionsRepresentatives[best].Ordre {
+			best = i
+		}
+	}
+	if best < 0 {
+		return errNoRepresentativeStation
+	}
+
+	q := *p
+	q.codiEstacio = m.EstacionsRepresentatives[best].CodiEstacio
+
+	return m.MeasurementLast(&q)
+}
@@ -0,0 +1,40 @@
+package meteocat
+
+import (
+	"testing"
+)
+
+// TestMeasurementLastByMunicipi tests that the last reading is fetched from the most representative station.
+func TestMeasurementLastByMunicipi(t *testing.T) {
+	defer newFixturesServerFor(t, &baseURL, map[string]string{
+		"/representatives/metadades/municipis/080193/variables/32": "representatives_municipi_080193_variable_32.json",
+		"/variables/mesurades/32/ultimes":                          "mesurades_dia_unica_estacio.json",
+	})()
+
+	m, _ := NewMesurades("")
+	p, _ := NewParameters(OptionCodiMunicipi("080193"), OptionCodiVariable("32"))
+
+	if err := m.MeasurementLastByMunicipi(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.EstacionsRepresentatives) != 2 {
+		t.Errorf("expected 2 representative stations, got %d", len(m.EstacionsRepresentatives))
+	}
+	if m.CodiEstacio != "D5" {
+		t.Errorf("expected station D5, got %s", m.CodiEstacio)
+	}
+	if m.Variable.Codi != 32 || len(m.Variable.Lectures) != 2 {
+		t.Errorf("unexpected variable: %+v", m.Variable)
+	}
+}
+
+// TestRepresentativeStationsInvalidMunicipi tests that malformed INE codes are rejected.
+func TestRepresentativeStationsInvalidMunicipi(t *testing.T) {
+	m, _ := NewMesurades("")
+	p, _ := NewParameters(OptionCodiMunicipi("Barcelona"), OptionCodiVariable("32"))
+
+	if err := m.RepresentativeStations(p); err != errMunicipiUnavailable {
+		t.Errorf("expected %v, got %v", errMunicipiUnavailable, err)
+	}
+}
@@ -0,0 +1,464 @@
+package sensorthings
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// Definitions referenced by the entities
+const (
+	observationType = "http://www.opengis.net/def/observationType/OGC-OM/2.0/OM_Measurement"
+	geoJSON         = "application/geo+json"
+	docsURL         = "https://apidocs.meteocat.gencat.cat/documentacio/dades-de-la-xema/"
+	variableURL     = "https://api.meteo.cat/xema/v1/variables/mesurades/%d/metadades"
+)
+
+// entity is a SensorThings entity with the station and variable it was built from
+type entity struct {
+	set      string
+	id       interface{}
+	estacio  string
+	variable int
+	props    map[string]interface{}
+}
+
+// navigation holds the navigation properties of each entity set and the set they lead to
+var navigation = map[string]map[string]string{
+	"Things":              {"Locations": "Locations", "HistoricalLocations": "HistoricalLocations", "Datastreams": "Datastreams"},
+	"Locations":           {"Things": "Things", "HistoricalLocations": "HistoricalLocations"},
+	"HistoricalLocations": {"Thing": "Things", "Locations": "Locations"},
+	"Datastreams":         {"Thing": "Things", "Sensor": "Sensors", "ObservedProperty": "ObservedProperties", "Observations": "Observations"},
+	"Sensors":             {"Datastreams": "Datastreams"},
+	"ObservedProperties":  {"Datastreams": "Datastreams"},
+	"Observations":        {"Datastream": "Datastreams", "FeatureOfInterest": "FeaturesOfInterest"},
+	"FeaturesOfInterest":  {"Observations": "Observations"},
+}
+
+// single holds the navigation properties which lead to a single entity
+var single = map[string]bool{"Thing": true, "Sensor": true, "ObservedProperty": true, "Datastream": true, "FeatureOfInterest": true}
+
+// sets lists the entity sets in the order of the service root
+var sets = []string{"Things", "Locations", "HistoricalLocations", "Datastreams", "Sensors", "ObservedProperties", "Observations", "FeaturesOfInterest"}
+
+// point returns the GeoJSON point of a station
+func point(st meteocat.MetadadesEstacions) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "Point",
+		"coordinates": []float64{st.Coordenades.Longitud, st.Coordenades.Latitud, st.Altitud},
+	}
+}
+
+func (s *Server) thing(st meteocat.MetadadesEstacions) entity {
+	return entity{set: "Things", id: st.Codi, estacio: st.Codi, props: map[string]interface{}{
+		"name":        st.Nom,
+		"description": st.Emplacament,
+		"properties": map[string]interface{}{
+			"codi":      st.Codi,
+			"tipus":     st.Tipus,
+			"altitud":   st.Altitud,
+			"municipi":  st.Municipi.Nom,
+			"comarca":   st.Comarca.Nom,
+			"provincia": st.Provincia.Nom,
+			"xarxa":     st.Xarxa.Nom,
+			"estat":     st.EstatActual(),
+		},
+	}}
+}
+
+func (s *Server) location(st meteocat.MetadadesEstacions) entity {
+	return entity{set: "Locations", id: st.Codi, estacio: st.Codi, props: map[string]interface{}{
+		"name":         st.Nom,
+		"description":  st.Emplacament,
+		"encodingType": geoJSON,
+		"location":     point(st),
+	}}
+}
+
+func (s *Server) featureOfInterest(st meteocat.MetadadesEstacions) entity {
+	return entity{set: "FeaturesOfInterest", id: st.Codi, estacio: st.Codi, props: map[string]interface{}{
+		"name":         st.Nom,
+		"description":  st.Emplacament,
+		"encodingType": geoJSON,
+		"feature":      point(st),
+	}}
+}
+
+func (s *Server) observedProperty(v meteocat.MetadadesVariable) entity {
+	return entity{set: "ObservedProperties", id: v.Codi, variable: v.Codi, props: map[string]interface{}{
+		"name":        v.Nom,
+		"definition":  fmt.Sprintf(variableURL, v.Codi),
+		"description": fmt.Sprintf("%s (%s)", v.Nom, v.Acronim),
+		"properties": map[string]interface{}{
+			"acronim":  v.Acronim,
+			"tipus":    v.Tipus,
+			"decimals": v.Decimals,
+		},
+	}}
+}
+
+func (s *Server) sensor(v meteocat.MetadadesVariable) entity {
+	return entity{set: "Sensors", id: v.Codi, variable: v.Codi, props: map[string]interface{}{
+		"name":         "XEMA " + v.Acronim,
+		"description":  "Sensors of " + v.Nom + " of the automatic weather stations of the XEMA",
+		"encodingType": "text/html",
+		"metadata":     docsURL,
+	}}
+}
+
+func (s *Server) datastream(st meteocat.MetadadesEstacions, v meteocat.MetadadesVariable) entity {
+	return entity{set: "Datastreams", id: fmt.Sprintf("%s-%d", st.Codi, v.Codi), estacio: st.Codi, variable: v.Codi, props: map[string]interface{}{
+		"name":            st.Nom + " - " + v.Nom,
+		"description":     fmt.Sprintf("%s at the station %s (%s)", v.Nom, st.Nom, st.Codi),
+		"observationType": observationType,
+		"unitOfMeasurement": map[string]interface{}{
+			"name":       v.Unitats,
+			"symbol":     v.Unitats,
+			"definition": fmt.Sprintf(variableURL, v.Codi),
+		},
+		"properties": map[string]interface{}{
+			"codiEstacio":  st.Codi,
+			"codiVariable": v.Codi,
+		},
+	}}
+}
+
+func (s *Server) observation(estacio string, variable int, l meteocat.Lectura) (entity, error) {
+	t, err := meteocat.ParseData(l.Data)
+	if err != nil {
+		return entity{}, err
+	}
+	return entity{set: "Observations", id: fmt.Sprintf("%s-%d-%s", estacio, variable, t.Format("200601021504")), estacio: estacio, variable: variable, props: map[string]interface{}{
+		"phenomenonTime": t,
+		"resultTime":     t,
+		"result":         l.Valor,
+		"resultQuality":  l.Estat,
+		"parameters":     map[string]interface{}{"baseHoraria": l.BaseHoraria},
+	}}, nil
+}
+
+// observations returns the observations of a datastream in the time window of the query
+func (s *Server) observations(estacio string, variable int, q *query) ([]entity, error) {
+	if q == nil {
+		q = &query{top: -1}
+	}
+	from, to := q.window(s.now())
+	lectures, err := s.readings.Readings(estacio, variable, from, to)
+	if err != nil {
+		return nil, err
+	}
+	entities := make([]entity, 0, len(lectures))
+	for _, l := range lectures {
+		e, err := s.observation(estacio, variable, l)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, nil
+}
+
+// stationDatastreams returns the datastreams of a station
+func (s *Server) stationDatastreams(st meteocat.MetadadesEstacions) []entity {
+	var entities []entity
+	for _, v := range s.variables {
+		entities = append(entities, s.datastream(st, v))
+	}
+	return entities
+}
+
+// variableDatastreams returns the datastreams of a variable
+func (s *Server) variableDatastreams(v meteocat.MetadadesVariable) []entity {
+	var entities []entity
+	for _, st := range s.stations {
+		entities = append(entities, s.datastream(st, v))
+	}
+	return entities
+}
+
+// collection returns all the entities of a set
+func (s *Server) collection(set string) ([]entity, error) {
+	var entities []entity
+	switch set {
+	case "Things", "Locations", "FeaturesOfInterest":
+		for _, st := range s.stations {
+			entities = append(entities, s.stationEntity(set, st))
+		}
+	case "Sensors", "ObservedProperties":
+		for _, v := range s.variables {
+			entities = append(entities, s.variableEntity(set, v))
+		}
+	case "Datastreams":
+		for _, st := range s.stations {
+			entities = append(entities, s.stationDatastreams(st)...)
+		}
+	case "HistoricalLocations":
+		// Stations do not move
+	case "Observations":
+		return nil, badRequest("Observations must be requested through a Datastream or a FeatureOfInterest")
+	default:
+		return nil, notFound("unknown entity set " + set)
+	}
+	return entities, nil
+}
+
+// stationEntity returns the entity of a set built from a station
+func (s *Server) stationEntity(set string, st meteocat.MetadadesEstacions) entity {
+	switch set {
+	case "Things":
+		return s.thing(st)
+	case "Locations":
+		return s.location(st)
+	}
+	return s.featureOfInterest(st)
+}
+
+// variableEntity returns the entity of a set built from a variable
+func (s *Server) variableEntity(set string, v meteocat.MetadadesVariable) entity {
+	if set == "Sensors" {
+		return s.sensor(v)
+	}
+	return s.observedProperty(v)
+}
+
+// station returns the station of a code
+func (s *Server) station(codi string) (meteocat.MetadadesEstacions, error) {
+	i, ok := s.byStation[strings.ToUpper(codi)]
+	if !ok {
+		return meteocat.MetadadesEstacions{}, notFound("unknown station " + codi)
+	}
+	return s.stations[i], nil
+}
+
+// variable returns the variable of a code
+func (s *Server) variable(codi int) (meteocat.MetadadesVariable, error) {
+	v, ok := s.byVariable[codi]
+	if !ok {
+		return meteocat.MetadadesVariable{}, notFound(fmt.Sprintf("unknown variable %d", codi))
+	}
+	return v, nil
+}
+
+// parseDatastreamID splits the identifier of a datastream, e.g D5-32, into its station and variable
+func parseDatastreamID(id string) (string, int, bool) {
+	i := strings.LastIndexByte(id, '-')
+	if i < 0 {
+		return "", 0, false
+	}
+	codi, err := strconv.Atoi(id[i+1:])
+	return id[:i], codi, err == nil
+}
+
+// get returns the entity of a set with the key of a resource path, e.g 'D5' or 32
+func (s *Server) get(set, key string) (entity, error) {
+	id := strings.Trim(key, "'")
+	switch set {
+	case "Things", "Locations", "FeaturesOfInterest":
+		st, err := s.station(id)
+		if err != nil {
+			return entity{}, err
+		}
+		return s.stationEntity(set, st), nil
+
+	case "Sensors", "ObservedProperties":
+		codi, err := strconv.Atoi(id)
+		if err != nil {
+			return entity{}, notFound("invalid id " + key)
+		}
+		v, err := s.variable(codi)
+		if err != nil {
+			return entity{}, err
+		}
+		return s.variableEntity(set, v), nil
+
+	case "Datastreams":
+		estacio, codi, ok := parseDatastreamID(id)
+		if !ok {
+			return entity{}, notFound("invalid id " + key)
+		}
+		st, err := s.station(estacio)
+		if err != nil {
+			return entity{}, err
+		}
+		v, err := s.variable(codi)
+		if err != nil {
+			return entity{}, err
+		}
+		return s.datastream(st, v), nil
+
+	case "Observations":
+		i := strings.LastIndexByte(id, '-')
+		if i < 0 {
+			return entity{}, notFound("invalid id " + key)
+		}
+		t, err := time.Parse("200601021504", id[i+1:])
+		if err != nil {
+			return entity{}, notFound("invalid id " + key)
+		}
+		ds, err := s.get("Datastreams", id[:i])
+		if err != nil {
+			return entity{}, err
+		}
+		lectures, err := s.readings.Readings(ds.estacio, ds.variable, t, t.Add(time.Minute))
+		if err != nil {
+			return entity{}, err
+		}
+		for _, l := range lectures {
+			if e, err := s.observation(ds.estacio, ds.variable, l); err == nil && e.id == id {
+				return e, nil
+			}
+		}
+		return entity{}, notFound("unknown observation " + key)
+	}
+	return entity{}, notFound("unknown entity set " + set)
+}
+
+// navigate returns the entities related to e by a navigation property. The query bounds the observations.
+func (s *Server) navigate(e entity, nav string, q *query) ([]entity, error) {
+	if _, ok := navigation[e.set][nav]; !ok {
+		return nil, badRequest(fmt.Sprintf("unknown navigation property %s of %s", nav, e.set))
+	}
+
+	switch e.set + "/" + nav {
+	case "Things/HistoricalLocations", "Locations/HistoricalLocations":
+		return nil, nil
+	case "Datastreams/Sensor", "Datastreams/ObservedProperty":
+		v, err := s.variable(e.variable)
+		if err != nil {
+			return nil, err
+		}
+		return []entity{s.variableEntity(navigation[e.set][nav], v)}, nil
+	case "Sensors/Datastreams", "ObservedProperties/Datastreams":
+		v, err := s.variable(e.variable)
+		if err != nil {
+			return nil, err
+		}
+		return s.variableDatastreams(v), nil
+	case "Datastreams/Observations":
+		return s.observations(e.estacio, e.variable, q)
+	case "FeaturesOfInterest/Observations":
+		var entities []entity
+		for _, v := range s.variables {
+			obs, err := s.observations(e.estacio, v.Codi, q)
+			if err != nil {
+				return nil, err
+			}
+			entities = append(entities, obs...)
+		}
+		return entities, nil
+	}
+
+	// The remaining navigation properties lead to the station or its datastreams
+	st, err := s.station(e.estacio)
+	if err != nil {
+		return nil, err
+	}
+	if nav == "Datastreams" {
+		return s.stationDatastreams(st), nil
+	}
+	if e.set == "Observations" && nav == "Datastream" {
+		v, err := s.variable(e.variable)
+		if err != nil {
+			return nil, err
+		}
+		return []entity{s.datastream(st, v)}, nil
+	}
+	return []entity{s.stationEntity(navigation[e.set][nav], st)}, nil
+}
+
+// resolve returns the value of a property path of an entity for $filter and $orderby. Paths may go through the
+// navigation properties which lead to a single entity, e.g Thing/properties/comarca.
+func (s *Server) resolve(e entity, path []string) (interface{}, error) {
+	first, rest := path[0], path[1:]
+	if first == "id" || first == "@iot.id" {
+		return e.id, nil
+	}
+	if single[first] {
+		if _, ok := navigation[e.set][first]; ok {
+			related, err := s.navigate(e, first, nil)
+			if err != nil || len(related) == 0 || len(rest) == 0 {
+				return nil, err
+			}
+			return s.resolve(related[0], rest)
+		}
+	}
+
+	v := e.props[first]
+	for _, p := range rest {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		v = m[p]
+	}
+	return v, nil
+}
+
+// formatKey returns the key of an identifier in a resource path
+func formatKey(id interface{}) string {
+	if s, ok := id.(string); ok {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return fmt.Sprint(id)
+}
+
+// render returns the JSON object of an entity with its links and the expanded navigation properties
+func (s *Server) render(e entity, root string, q *query) (map[string]interface{}, error) {
+	self := fmt.Sprintf("%s/%s(%s)", root, e.set, formatKey(e.id))
+	out := make(map[string]interface{}, len(e.props)+8)
+	for k, v := range e.props {
+		out[k] = v
+	}
+	out["@iot.id"] = e.id
+	out["@iot.selfLink"] = self
+
+	navs := make([]string, 0, len(navigation[e.set]))
+	for nav := range navigation[e.set] {
+		navs = append(navs, nav)
+	}
+	sort.Strings(navs)
+	for _, nav := range navs {
+		out[nav+"@iot.navigationLink"] = self + "/" + nav
+	}
+
+	if q == nil {
+		return out, nil
+	}
+	for _, x := range q.expand {
+		related, err := s.navigate(e, x.nav, x.q)
+		if err != nil {
+			return nil, err
+		}
+		if single[x.nav] {
+			out[x.nav] = nil
+			if len(related) > 0 {
+				if out[x.nav], err = s.render(related[0], root, x.q); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		page, count, err := x.q.apply(related, s.resolve, defaultTop)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, len(page))
+		for _, r := range page {
+			v, err := s.render(r, root, x.q)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		out[x.nav] = values
+		if x.q.count {
+			out[x.nav+"@iot.count"] = count
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,585 @@
+package sensorthings
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// token is a lexical token of a $filter or $orderby expression
+type token struct {
+	kind  int
+	text  string
+	value interface{} // Value of literals
+}
+
+// Kinds of tokens
+const (
+	tokEOF = iota
+	tokIdent
+	tokLiteral
+	tokOpen
+	tokClose
+	tokComma
+)
+
+// dateTime matches the unquoted date-time literals, e.g 2023-03-12T00:00:00Z or 2023-03-12T01:00:00+01:00
+var dateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})`)
+
+// lex splits an expression into tokens
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokClose, text: ")"})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ","})
+			i++
+		case c == '\'':
+			str, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokLiteral, text: s[i : i+n], value: str})
+			i += n
+		case dateTime.MatchString(s[i:]):
+			m := dateTime.FindString(s[i:])
+			t, err := parseTime(m)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokLiteral, text: m, value: t})
+			i += len(m)
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E') {
+				j++
+			}
+			v, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", s[i:j])
+			}
+			tokens = append(tokens, token{kind: tokLiteral, text: s[i:j], value: v})
+			i = j
+		case unicode.IsLetter(rune(c)) || c == '@' || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || s[j] >= '0' && s[j] <= '9' || strings.IndexByte("_./@", s[j]) >= 0) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// lexString reads a quoted string literal, where two quotes stand for one
+func lexString(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '\'' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\'' {
+			b.WriteByte('\'')
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, fmt.Errorf("unterminated string %s", s)
+}
+
+// parseTime parses a date-time literal
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time %q", s)
+}
+
+// resolver returns the value of a property path of an entity, e.g name, properties/comarca or Thing/name
+type resolver func(e entity, path []string) (interface{}, error)
+
+// expr is a node of an expression tree
+type expr interface {
+	eval(e entity, r resolver) (interface{}, error)
+}
+
+type literal struct{ v interface{} }
+
+type property struct{ path []string }
+
+type unary struct {
+	op string
+	x  expr
+}
+
+type binary struct {
+	op   string
+	l, r expr
+}
+
+type call struct {
+	name string
+	args []expr
+}
+
+// parser is a recursive descent parser of the OData expressions of $filter and $orderby
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseFilter parses a $filter expression
+func parseFilter(s string) (expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return x, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is one of the keywords and consumes it
+func (p *parser) keyword(keywords ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", false
+	}
+	for _, k := range keywords {
+		if t.text == k {
+			p.pos++
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.keyword("or"); !ok {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: "or", l: l, r: r}
+	}
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.keyword("and"); !ok {
+			return l, nil
+		}
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: "and", l: l, r: r}
+	}
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if _, ok := p.keyword("not"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: "not", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.keyword("eq", "ne", "gt", "ge", "lt", "le"); ok {
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return binary{op: op, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.keyword("add", "sub")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.keyword("mul", "div", "mod")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLiteral:
+		return literal{t.value}, nil
+	case tokOpen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokClose {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return x, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return literal{t.text == "true"}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if p.peek().kind == tokOpen {
+			return p.parseCall(t.text)
+		}
+		return property{path: strings.Split(t.text, "/")}, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// functions holds the number of arguments of the supported functions
+var functions = map[string]int{
+	"substringof": 2, "contains": 2, "startswith": 2, "endswith": 2, "indexof": 2,
+	"length": 1, "tolower": 1, "toupper": 1, "trim": 1,
+	"year": 1, "month": 1, "day": 1, "hour": 1, "minute": 1, "second": 1,
+	"round": 1, "floor": 1, "ceiling": 1,
+}
+
+func (p *parser) parseCall(name string) (expr, error) {
+	n, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function %s", name)
+	}
+	p.next() // (
+	var args []expr
+	for p.peek().kind != tokClose {
+		if len(args) > 0 && p.next().kind != tokComma {
+			return nil, fmt.Errorf("expected , in the arguments of %s", name)
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next() // )
+	if len(args) != n {
+		return nil, fmt.Errorf("%s expects %d arguments", name, n)
+	}
+	return call{name: name, args: args}, nil
+}
+
+func (x literal) eval(e entity, r resolver) (interface{}, error) {
+	return x.v, nil
+}
+
+func (x property) eval(e entity, r resolver) (interface{}, error) {
+	return r(e, x.path)
+}
+
+func (x unary) eval(e entity, r resolver) (interface{}, error) {
+	v, err := x.x.eval(e, r)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("not expects a boolean")
+	}
+	return !b, nil
+}
+
+// number converts the numeric values to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// compare returns the order of two values, or false when they are not comparable. Strings are compared to times
+// as date-time literals.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	ta, aTime := a.(time.Time)
+	tb, bTime := b.(time.Time)
+	if aTime || bTime {
+		var err error
+		if s, ok := a.(string); ok {
+			ta, err = parseTime(s)
+		} else if s, ok := b.(string); ok {
+			tb, err = parseTime(s)
+		} else if !aTime || !bTime {
+			return 0, false
+		}
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if x == y {
+			return 0, true
+		}
+		if !x {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func (x binary) eval(e entity, r resolver) (interface{}, error) {
+	l, err := x.l.eval(e, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// and, or short-circuit
+	if x.op == "and" || x.op == "or" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects booleans", x.op)
+		}
+		if x.op == "and" && !lb || x.op == "or" && lb {
+			return lb, nil
+		}
+		rv, err := x.r.eval(e, r)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := rv.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects booleans", x.op)
+		}
+		return rb, nil
+	}
+
+	rv, err := x.r.eval(e, r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x.op {
+	case "eq", "ne":
+		if l == nil || rv == nil {
+			return (l == nil && rv == nil) == (x.op == "eq"), nil
+		}
+		c, ok := compare(l, rv)
+		return ok && c == 0 == (x.op == "eq"), nil
+	case "gt", "ge", "lt", "le":
+		c, ok := compare(l, rv)
+		if !ok {
+			return false, nil
+		}
+		switch x.op {
+		case "gt":
+			return c > 0, nil
+		case "ge":
+			return c >= 0, nil
+		case "lt":
+			return c < 0, nil
+		}
+		return c <= 0, nil
+	}
+
+	a, aok := number(l)
+	b, bok := number(rv)
+	if !aok || !bok {
+		return nil, fmt.Errorf("%s expects numbers", x.op)
+	}
+	switch x.op {
+	case "add":
+		return a + b, nil
+	case "sub":
+		return a - b, nil
+	case "mul":
+		return a * b, nil
+	case "div":
+		return a / b, nil
+	}
+	return math.Mod(a, b), nil
+}
+
+func (x call) eval(e entity, r resolver) (interface{}, error) {
+	args := make([]interface{}, len(x.args))
+	for i, arg := range x.args {
+		v, err := arg.eval(e, r)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch x.name {
+	case "substringof", "contains", "startswith", "endswith", "indexof", "length", "tolower", "toupper", "trim":
+		s := make([]string, len(args))
+		for i, a := range args {
+			str, ok := a.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s expects strings", x.name)
+			}
+			s[i] = str
+		}
+		switch x.name {
+		case "substringof":
+			return strings.Contains(s[1], s[0]), nil
+		case "contains":
+			return strings.Contains(s[0], s[1]), nil
+		case "startswith":
+			return strings.HasPrefix(s[0], s[1]), nil
+		case "endswith":
+			return strings.HasSuffix(s[0], s[1]), nil
+		case "indexof":
+			return float64(strings.Index(s[0], s[1])), nil
+		case "length":
+			return float64(len([]rune(s[0]))), nil
+		case "tolower":
+			return strings.ToLower(s[0]), nil
+		case "toupper":
+			return strings.ToUpper(s[0]), nil
+		}
+		return strings.TrimSpace(s[0]), nil
+
+	case "year", "month", "day", "hour", "minute", "second":
+		t, ok := args[0].(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a date-time", x.name)
+		}
+		parts := map[string]int{
+			"year": t.Year(), "month": int(t.Month()), "day": t.Day(),
+			"hour": t.Hour(), "minute": t.Minute(), "second": t.Second(),
+		}
+		return float64(parts[x.name]), nil
+	}
+
+	n, ok := number(args[0])
+	if !ok {
+		return nil, fmt.Errorf("%s expects a number", x.name)
+	}
+	switch x.name {
+	case "round":
+		return math.Round(n), nil
+	case "floor":
+		return math.Floor(n), nil
+	}
+	return math.Ceil(n), nil
+}
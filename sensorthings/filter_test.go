@@ -0,0 +1,126 @@
+package sensorthings
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFilter tests the evaluation of $filter expressions against an entity.
+func TestFilter(t *testing.T) {
+	e := entity{set: "Observations", id: "D5-32-202303121000", props: map[string]interface{}{
+		"phenomenonTime": time.Date(2023, 3, 12, 10, 0, 0, 0, time.UTC),
+		"result":         12.5,
+		"resultQuality":  "V",
+		"name":           "Barcelona - l'Observatori Fabra",
+		"properties":     map[string]interface{}{"comarca": "Barcelonès", "altitud": 411.0},
+	}}
+	r := func(e entity, path []string) (interface{}, error) {
+		if path[0] == "id" {
+			return e.id, nil
+		}
+		v := e.props[path[0]]
+		for _, p := range path[1:] {
+			v = v.(map[string]interface{})[p]
+		}
+		return v, nil
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"result gt 12", true},
+		{"result ge 12.5 and result le 12.5", true},
+		{"result lt 0 or resultQuality eq 'V'", true},
+		{"not (result gt 12)", false},
+		{"result add 0.5 eq 13", true},
+		{"result mul 2 sub 5 eq 20", true},
+		{"result mod 5 eq 2.5", true},
+		{"phenomenonTime ge 2023-03-12T10:00:00Z and phenomenonTime lt 2023-03-12T11:00Z", true},
+		{"phenomenonTime gt 2023-03-12T11:00:00+01:00", false},
+		{"hour(phenomenonTime) eq 10 and day(phenomenonTime) eq 12", true},
+		{"properties/comarca eq 'Barcelonès'", true},
+		{"properties/altitud gt 400", true},
+		{"properties/missing eq null", true},
+		{"substringof('Fabra', name)", true},
+		{"startswith(tolower(name), 'barcelona')", true},
+		{"endswith(name, 'l''Observatori Fabra')", true},
+		{"length(resultQuality) eq 1", true},
+		{"round(result) eq 13 and floor(result) eq 12 and ceiling(result) eq 13", true},
+		{"id eq 'D5-32-202303121000'", true},
+		{"resultQuality ne 'V'", false},
+		{"result eq 'V'", false},
+	}
+	for _, tc := range tests {
+		x, err := parseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tc.filter, err)
+			continue
+		}
+		got, err := x.eval(e, r)
+		if err != nil {
+			t.Errorf("%s: %v", tc.filter, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.filter, tc.want, got)
+		}
+	}
+
+	for _, s := range []string{"result gt", "(result gt 1", "unknown(name)", "startswith(name)", "name eq 'open", "result # 1"} {
+		if _, err := parseFilter(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+// TestWindow tests the time range of the observations selected by a filter.
+func TestWindow(t *testing.T) {
+	now := time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC)
+	day := time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		filter   string
+		from, to time.Time
+	}{
+		{"", now.Add(-24 * time.Hour), now},
+		{"result gt 10", now.Add(-24 * time.Hour), now},
+		{"phenomenonTime ge 2023-03-12T00:00:00Z and phenomenonTime lt 2023-03-12T12:00:00Z", day, day.Add(12 * time.Hour)},
+		{"2023-03-12T00:00:00Z le phenomenonTime and result gt 0", day, now},
+		{"phenomenonTime le 2023-03-12T12:00:00Z", day.Add(-12*time.Hour + time.Second), day.Add(12*time.Hour + time.Second)},
+		{"resultTime eq 2023-03-12T00:00:00Z", day, day.Add(time.Second)},
+		{"phenomenonTime ge 2023-03-12T00:00:00Z or result gt 0", now.Add(-24 * time.Hour), now},
+	}
+	for _, tc := range tests {
+		q := &query{top: -1}
+		if tc.filter != "" {
+			var err error
+			if q.filter, err = parseFilter(tc.filter); err != nil {
+				t.Fatal(err)
+			}
+		}
+		from, to := q.window(now)
+		if !from.Equal(tc.from) || !to.Equal(tc.to) {
+			t.Errorf("%q: expected [%v, %v), got [%v, %v)", tc.filter, tc.from, tc.to, from, to)
+		}
+	}
+}
+
+// TestExpandOptions tests the parsing of nested $expand options.
+func TestExpandOptions(t *testing.T) {
+	x, err := parseExpand("Datastreams($top=2;$expand=Observations($orderby=phenomenonTime desc;$top=1)),Locations,Datastreams/Sensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x) != 2 || x[0].nav != "Datastreams" || x[1].nav != "Locations" {
+		t.Fatalf("unexpected expansions %+v", x)
+	}
+	ds := x[0].q
+	if ds.top != 2 || len(ds.expand) != 2 || ds.expand[0].nav != "Observations" || ds.expand[1].nav != "Sensor" {
+		t.Fatalf("unexpected Datastreams options %+v", ds)
+	}
+	obs := ds.expand[0].q
+	if obs.top != 1 || len(obs.orderby) != 1 || !obs.orderby[0].desc {
+		t.Errorf("unexpected Observations options %+v", obs)
+	}
+}
@@ -0,0 +1,308 @@
+package sensorthings
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// order is an item of $orderby
+type order struct {
+	x    expr
+	desc bool
+}
+
+// expansion is an item of $expand, a navigation property with the options of the expanded entities
+type expansion struct {
+	nav string
+	q   *query
+}
+
+// query holds the query options of a request or of an expanded navigation property
+type query struct {
+	filter  expr
+	orderby []order
+	top     int // -1 when not set
+	skip    int
+	count   bool
+	expand  []expansion
+}
+
+// splitTop splits s on sep outside parentheses and quotes
+func splitTop(s string, sep byte) []string {
+	var parts []string
+	depth, start, quoted := 0, 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseQuery parses the query options of a request
+func parseQuery(values url.Values) (*query, error) {
+	options := make(map[string]string)
+	for k, v := range values {
+		if strings.HasPrefix(k, "$") && len(v) > 0 {
+			options[k] = v[0]
+		}
+	}
+	return newQuery(options)
+}
+
+// parseNested parses the options of an expanded navigation property separated by semicolons, e.g
+// $top=1;$orderby=phenomenonTime desc
+func parseNested(s string) (*query, error) {
+	options := make(map[string]string)
+	for _, part := range splitTop(s, ';') {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid expand option %q", part)
+		}
+		options[kv[0]] = kv[1]
+	}
+	return newQuery(options)
+}
+
+// newQuery returns the query of the options by name
+func newQuery(options map[string]string) (*query, error) {
+	q := &query{top: -1}
+	for name, v := range options {
+		var err error
+		switch name {
+		case "$filter":
+			q.filter, err = parseFilter(v)
+		case "$orderby":
+			q.orderby, err = parseOrderBy(v)
+		case "$top":
+			q.top, err = strconv.Atoi(v)
+			if err == nil && q.top < 0 {
+				err = fmt.Errorf("$top must not be negative")
+			}
+		case "$skip":
+			q.skip, err = strconv.Atoi(v)
+			if err == nil && q.skip < 0 {
+				err = fmt.Errorf("$skip must not be negative")
+			}
+		case "$count":
+			q.count, err = strconv.ParseBool(v)
+		case "$expand":
+			q.expand, err = parseExpand(v)
+		case "$select", "$resultFormat":
+			err = fmt.Errorf("%s is not supported", name)
+		default:
+			err = fmt.Errorf("unknown query option %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return q, nil
+}
+
+// parseOrderBy parses a $orderby list, e.g phenomenonTime desc,result
+func parseOrderBy(s string) ([]order, error) {
+	var orders []order
+	for _, item := range splitTop(s, ',') {
+		item = strings.TrimSpace(item)
+		var o order
+		if i := strings.LastIndexByte(item, ' '); i >= 0 {
+			switch strings.TrimSpace(item[i+1:]) {
+			case "desc":
+				o.desc = true
+				item = item[:i]
+			case "asc":
+				item = item[:i]
+			}
+		}
+		x, err := parseFilter(item)
+		if err != nil {
+			return nil, err
+		}
+		o.x = x
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// parseExpand parses a $expand list. A path such as Datastreams/Observations expands Observations within
+// Datastreams, and options may follow in parentheses, e.g Datastreams($top=1;$expand=Observations).
+func parseExpand(s string) ([]expansion, error) {
+	var expansions []expansion
+	for _, item := range splitTop(s, ',') {
+		item = strings.TrimSpace(item)
+		options := ""
+		if i := strings.IndexByte(item, '('); i >= 0 {
+			if !strings.HasSuffix(item, ")") {
+				return nil, fmt.Errorf("missing closing parenthesis in %q", item)
+			}
+			item, options = item[:i], item[i+1:len(item)-1]
+		}
+
+		segments := strings.Split(item, "/")
+		q := &query{top: -1}
+		if options != "" {
+			var err error
+			if q, err = parseNested(options); err != nil {
+				return nil, err
+			}
+		}
+		// Build the innermost expansion first
+		for i := len(segments) - 1; i > 0; i-- {
+			q = &query{top: -1, expand: []expansion{{nav: segments[i], q: q}}}
+		}
+		e := expansion{nav: segments[0], q: q}
+
+		// Merge with a previous expansion of the same property, e.g Datastreams/Thing,Datastreams/Sensor
+		merged := false
+		for j := range expansions {
+			if expansions[j].nav == e.nav && len(segments) > 1 {
+				expansions[j].q.expand = append(expansions[j].q.expand, q.expand...)
+				merged = true
+			}
+		}
+		if !merged {
+			expansions = append(expansions, e)
+		}
+	}
+	return expansions, nil
+}
+
+// timeProperties are the properties of the observations which bound the readings to load
+var timeProperties = map[string]bool{"phenomenonTime": true, "resultTime": true}
+
+// window returns the time range of the readings selected by the comparisons of phenomenonTime or resultTime joined
+// by and in the filter. Without a lower bound the range spans one day before the upper one, which defaults to now.
+func (q *query) window(now time.Time) (time.Time, time.Time) {
+	var from, to time.Time
+	var walk func(x expr)
+	walk = func(x expr) {
+		b, ok := x.(binary)
+		if !ok {
+			return
+		}
+		if b.op == "and" {
+			walk(b.l)
+			walk(b.r)
+			return
+		}
+
+		op := b.op
+		p, pok := b.l.(property)
+		l, lok := b.r.(literal)
+		if !pok || !lok {
+			// The literal on the left reverses the comparison
+			p, pok = b.r.(property)
+			l, lok = b.l.(literal)
+			op = map[string]string{"gt": "lt", "ge": "le", "lt": "gt", "le": "ge", "eq": "eq"}[op]
+		}
+		t, tok := l.v.(time.Time)
+		if !pok || !lok || !tok || len(p.path) != 1 || !timeProperties[p.path[0]] {
+			return
+		}
+		switch op {
+		case "gt", "ge":
+			if from.IsZero() || t.After(from) {
+				from = t
+			}
+		case "lt", "le":
+			if op == "le" {
+				t = t.Add(time.Second)
+			}
+			if to.IsZero() || t.Before(to) {
+				to = t
+			}
+		case "eq":
+			from, to = t, t.Add(time.Second)
+		}
+	}
+	if q.filter != nil {
+		walk(q.filter)
+	}
+
+	if to.IsZero() {
+		to = now
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+	return from, to
+}
+
+// apply filters, counts, sorts and pages the entities. It returns the selected page and the number of entities
+// which matched the filter.
+func (q *query) apply(entities []entity, r resolver, defaultTop int) ([]entity, int, error) {
+	if q.filter != nil {
+		selected := entities[:0:0]
+		for _, e := range entities {
+			v, err := q.filter.eval(e, r)
+			if err != nil {
+				return nil, 0, asBadRequest(err)
+			}
+			if b, ok := v.(bool); ok && b {
+				selected = append(selected, e)
+			}
+		}
+		entities = selected
+	}
+	count := len(entities)
+
+	if len(q.orderby) > 0 {
+		keys := make([][]interface{}, len(entities))
+		for i, e := range entities {
+			keys[i] = make([]interface{}, len(q.orderby))
+			for j, o := range q.orderby {
+				v, err := o.x.eval(e, r)
+				if err != nil {
+					return nil, 0, asBadRequest(err)
+				}
+				keys[i][j] = v
+			}
+		}
+		index := make([]int, len(entities))
+		for i := range index {
+			index[i] = i
+		}
+		sort.SliceStable(index, func(a, b int) bool {
+			for j, o := range q.orderby {
+				c, ok := compare(keys[index[a]][j], keys[index[b]][j])
+				if !ok || c == 0 {
+					continue
+				}
+				return c < 0 != o.desc
+			}
+			return false
+		})
+		sorted := make([]entity, len(entities))
+		for i, k := range index {
+			sorted[i] = entities[k]
+		}
+		entities = sorted
+	}
+
+	if q.skip >= len(entities) {
+		return nil, count, nil
+	}
+	entities = entities[q.skip:]
+	top := q.top
+	if top < 0 {
+		top = defaultTop
+	}
+	if top < len(entities) {
+		entities = entities[:top]
+	}
+	return entities, count, nil
+}
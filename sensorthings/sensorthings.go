@@ -0,0 +1,389 @@
+// Package sensorthings serves the XEMA through the OGC SensorThings API 1.1, for the partners which consume sensor
+// data in that standard. Stations are mapped to a Thing with its Location and FeatureOfInterest, variables to an
+// ObservedProperty and a Sensor, each station and variable to a Datastream, and readings to Observations.
+//
+// The server is built from the catalogues of stations and variables and a source of readings, either stored ones
+// (a storage.Store, or a tsdb.DB through ReadingsFunc) or live ones requested to the API with LiveReadings. It
+// supports the $filter, $expand, $orderby, $top, $skip and $count query options. Observations are loaded for the
+// phenomenonTime range of the $filter, by default the last 24 hours:
+//
+//	/v1.1/Datastreams('D5-32')/Observations?$filter=phenomenonTime ge 2023-03-12T00:00:00Z and phenomenonTime lt 2023-03-13T00:00:00Z
+//	/v1.1/Things?$filter=properties/comarca eq 'Osona'&$expand=Datastreams($expand=Observations($orderby=phenomenonTime desc;$top=1))
+package sensorthings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+)
+
+// Prefix is the path of the service root
+const Prefix = "/v1.1"
+
+// defaultTop is the page size of the collections without $top
+const defaultTop = 100
+
+// maxLiveDays is the longest range of days LiveReadings requests to the API
+const maxLiveDays = 31
+
+var errInvalidOption = errors.New("invalid option")
+var errNoReadings = errors.New("no readings source")
+
+// Readings is a source of the readings of a variable at a station within [from, to), in time order. It is
+// implemented by storage.Store.
+type Readings interface {
+	Readings(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error)
+}
+
+// ReadingsFunc adapts a function to the Readings interface, e.g the Query method of a tsdb.DB
+type ReadingsFunc func(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error)
+
+// Readings implements the Readings interface
+func (f ReadingsFunc) Readings(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error) {
+	return f(estacio, variable, from, to)
+}
+
+// live requests the readings to the API
+type live struct {
+	key     string
+	options []meteocat.Option
+	now     func() time.Time
+}
+
+// LiveReadings returns a source which requests the readings of every day of the range to the API, one call per
+// station and day. Set meteocat.WithCache to keep the calls within the quota.
+func LiveReadings(key string, options ...meteocat.Option) Readings {
+	return &live{key: key, options: options, now: time.Now}
+}
+
+// Readings implements the Readings interface
+func (l *live) Readings(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error) {
+	estacio = strings.ToUpper(estacio)
+	first := from.UTC().Truncate(24 * time.Hour)
+	if to.Sub(first) > maxLiveDays*24*time.Hour {
+		return nil, badRequest(fmt.Sprintf("the phenomenonTime range of live data is limited to %d days", maxLiveDays))
+	}
+
+	var lectures []meteocat.Lectura
+	for day := first; day.Before(to) && !day.After(l.now()); day = day.AddDate(0, 0, 1) {
+		m, err := meteocat.NewMesurades(l.key, l.options...)
+		if err != nil {
+			return nil, err
+		}
+		p, err := meteocat.NewParameters(
+			meteocat.OptionCodiEstacio(estacio),
+			meteocat.OptionData(meteocat.Data{Any: day.Format("2006"), Mes: day.Format("01"), Dia: day.Format("02")}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.MeasurementAllByStation(p); err != nil {
+			return nil, err
+		}
+
+		for _, st := range m.Measurements {
+			for _, v := range st.Variables {
+				if st.Codi != estacio || v.Codi != variable {
+					continue
+				}
+				for _, lectura := range v.Lectures {
+					t, err := meteocat.ParseData(lectura.Data)
+					if err == nil && !t.Before(from) && t.Before(to) {
+						lectures = append(lectures, lectura)
+					}
+				}
+			}
+		}
+	}
+	return lectures, nil
+}
+
+// Server serves the SensorThings API under Prefix. It is safe for concurrent use.
+type Server struct {
+	stations   []meteocat.MetadadesEstacions
+	byStation  map[string]int
+	variables  []meteocat.MetadadesVariable
+	byVariable map[int]meteocat.MetadadesVariable
+	codes      []int // Codes of the exposed variables, all when empty
+	readings   Readings
+	root       string // Service root URL, derived from the requests when empty
+	now        func() time.Time
+}
+
+// Option configures a Server
+type Option func(s *Server) error
+
+// WithVariables restricts the datastreams to the variables of the codes, e.g 32, 33 and 35.
+func WithVariables(codes ...int) Option {
+	return func(s *Server) error {
+		if len(codes) == 0 {
+			return errInvalidOption
+		}
+		s.codes = codes
+		return nil
+	}
+}
+
+// WithServiceRoot sets the URL of the service root used in the links, e.g https://example.org/sensorthings/v1.1
+// behind a reverse proxy. By default it is derived from the host of the requests.
+func WithServiceRoot(u string) Option {
+	return func(s *Server) error {
+		parsed, err := neturl.Parse(u)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return errInvalidOption
+		}
+		s.root = strings.TrimSuffix(u, "/")
+		return nil
+	}
+}
+
+// NewServer returns a new Server pointer over the stations and variables whose observations are the readings
+func NewServer(stations meteocat.MetadadesTotesEstacions, variables meteocat.MetadadesVariables, readings Readings, options ...Option) (*Server, error) {
+	if readings == nil {
+		return nil, errNoReadings
+	}
+	s := &Server{
+		byStation:  make(map[string]int),
+		byVariable: make(map[int]meteocat.MetadadesVariable),
+		readings:   readings,
+		now:        time.Now,
+	}
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(s); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, st := range stations {
+		s.byStation[st.Codi] = len(s.stations)
+		s.stations = append(s.stations, st)
+	}
+
+	exposed := make(map[int]bool)
+	for _, c := range s.codes {
+		exposed[c] = true
+	}
+	for _, v := range variables {
+		if len(exposed) == 0 || exposed[v.Codi] {
+			s.variables = append(s.variables, v.MetadadesVariable)
+			s.byVariable[v.Codi] = v.MetadadesVariable
+		}
+	}
+	sort.Slice(s.variables, func(i, j int) bool { return s.variables[i].Codi < s.variables[j].Codi })
+	return s, nil
+}
+
+// httpError is an error with the status of its response
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &httpError{status: http.StatusBadRequest, message: message}
+}
+
+func notFound(message string) error {
+	return &httpError{status: http.StatusNotFound, message: message}
+}
+
+// asBadRequest returns the errors of the evaluation of an expression as bad requests
+func asBadRequest(err error) error {
+	if _, ok := err.(*httpError); ok {
+		return err
+	}
+	return badRequest(err.Error())
+}
+
+// segment matches a segment of a resource path, e.g Things('D5') or Datastreams
+var segment = regexp.MustCompile(`^([A-Za-z]+)(?:\((.+)\))?$`)
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the JSON response of an error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*httpError); ok {
+		status = e.status
+	}
+	writeJSON(w, status, map[string]interface{}{"code": status, "type": "error", "message": err.Error()})
+}
+
+// serviceRoot returns the URL of the service root for a request
+func (s *Server) serviceRoot(r *http.Request) string {
+	if s.root != "" {
+		return s.root
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + Prefix
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, &httpError{status: http.StatusMethodNotAllowed, message: "the API is read only"})
+		return
+	}
+	if r.URL.Path != Prefix && !strings.HasPrefix(r.URL.Path, Prefix+"/") {
+		writeError(w, notFound("not found"))
+		return
+	}
+	root := s.serviceRoot(r)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+
+	if path == "" {
+		values := make([]map[string]string, 0, len(sets))
+		for _, set := range sets {
+			values = append(values, map[string]string{"name": set, "url": root + "/" + set})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"value": values,
+			"serverSettings": map[string]interface{}{
+				"conformance": []string{
+					"http://www.opengis.net/spec/iot_sensing/1.1/req/datamodel",
+					"http://www.opengis.net/spec/iot_sensing/1.1/req/resource-path/resource-path-to-entities",
+					"http://www.opengis.net/spec/iot_sensing/1.1/req/request-data",
+				},
+			},
+		})
+		return
+	}
+
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+
+	v, err := s.resolvePath(strings.Split(path, "/"), q, root, r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// resolvePath returns the response of a resource path, e.g Things('D5')/Datastreams
+func (s *Server) resolvePath(segments []string, q *query, root string, values neturl.Values) (interface{}, error) {
+	var current *entity
+	var list []entity
+	isList := false
+
+	for i, seg := range segments {
+		m := segment.FindStringSubmatch(seg)
+		if m == nil {
+			return nil, notFound("invalid path segment " + seg)
+		}
+		name, key := m[1], m[2]
+		last := i == len(segments)-1
+
+		// A property of the entity, e.g Things('D5')/name
+		if current != nil && last && key == "" {
+			if _, ok := navigation[current.set][name]; !ok {
+				v, ok := current.props[name]
+				if !ok && name != "id" {
+					return nil, notFound("unknown property " + name)
+				}
+				if name == "id" {
+					v = current.id
+				}
+				return map[string]interface{}{name: v}, nil
+			}
+		}
+
+		var err error
+		switch {
+		case i == 0 && key != "":
+			var e entity
+			if e, err = s.get(name, key); err == nil {
+				current, isList = &e, false
+			}
+		case i == 0:
+			list, err = s.collection(name)
+			isList = true
+		case isList:
+			return nil, badRequest("navigation from a collection requires a key")
+		case key != "":
+			var e entity
+			if e, err = s.get(navigation[current.set][name], key); err == nil {
+				current = &e
+			}
+		default:
+			nq := &query{top: -1}
+			if last {
+				nq = q
+			}
+			list, err = s.navigate(*current, name, nq)
+			if err == nil && single[name] {
+				if len(list) == 0 {
+					return nil, notFound("no " + name)
+				}
+				current, isList = &list[0], false
+			} else {
+				isList = true
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !isList {
+		return s.render(*current, root, q)
+	}
+
+	page, count, err := q.apply(list, s.resolve, defaultTop)
+	if err != nil {
+		return nil, err
+	}
+	rendered := make([]interface{}, 0, len(page))
+	for _, e := range page {
+		v, err := s.render(e, root, q)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, v)
+	}
+
+	out := map[string]interface{}{"value": rendered}
+	if q.count {
+		out["@iot.count"] = count
+	}
+	if next := q.skip + len(page); len(page) > 0 && next < count {
+		v := neturl.Values{}
+		for k, vs := range values {
+			v[k] = vs
+		}
+		v.Set("$skip", strconv.Itoa(next))
+		if q.top < 0 {
+			v.Set("$top", strconv.Itoa(defaultTop))
+		}
+		out["@iot.nextLink"] = root + "/" + strings.Join(segments, "/") + "?" + v.Encode()
+	}
+	return out, nil
+}
@@ -0,0 +1,287 @@
+package sensorthings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+	"github.com/oscaromeu/meteocat/storage"
+	"github.com/oscaromeu/meteocat/storage/tsdb"
+)
+
+var _ Readings = (*storage.Store)(nil)
+var _ Readings = ReadingsFunc((*tsdb.DB)(nil).Query)
+
+// window is a range of readings requested to the source
+type window struct {
+	estacio  string
+	from, to time.Time
+}
+
+// newTestServer returns a server over the fixture day of temperatures and the ranges requested to its source
+func newTestServer(t *testing.T) (*Server, *[]window) {
+	t.Helper()
+
+	var stations meteocat.MetadadesTotesEstacions
+	var variables meteocat.MetadadesVariables
+	var m meteocat.Measurements
+	testutil.Load(t, "metadades_totes_estacions.json", &stations)
+	testutil.Load(t, "variables_mesurades_metadades.json", &variables)
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	var requested []window
+	readings := ReadingsFunc(func(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error) {
+		requested = append(requested, window{estacio, from, to})
+		var lectures []meteocat.Lectura
+		for _, st := range m {
+			for _, v := range st.Variables {
+				if st.Codi != estacio || v.Codi != variable {
+					continue
+				}
+				for _, l := range v.Lectures {
+					if ts, _ := meteocat.ParseData(l.Data); !ts.Before(from) && ts.Before(to) {
+						lectures = append(lectures, l)
+					}
+				}
+			}
+		}
+		return lectures, nil
+	})
+
+	s, err := NewServer(stations, variables, readings, WithVariables(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC) }
+	return s, &requested
+}
+
+// get sends a request to the server and decodes its JSON response
+func get(t *testing.T, s *Server, path string, query url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	target := path
+	if query != nil {
+		target += "?" + query.Encode()
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org"+target, nil))
+
+	var v map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, v
+}
+
+// TestServiceRoot tests that the service root lists the entity sets.
+func TestServiceRoot(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	code, v := get(t, s, "/v1.1", nil)
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	sets := v["value"].([]interface{})
+	if len(sets) != 8 {
+		t.Fatalf("expected 8 entity sets, got %d", len(sets))
+	}
+	if first := sets[0].(map[string]interface{}); first["name"] != "Things" || first["url"] != "http://example.org/v1.1/Things" {
+		t.Errorf("unexpected entity set %v", first)
+	}
+}
+
+// TestThings tests the filter, order and paging of the Things of a comarca.
+func TestThings(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	code, v := get(t, s, "/v1.1/Things", url.Values{
+		"$filter":  {"properties/comarca eq 'Osona'"},
+		"$orderby": {"name desc"},
+		"$top":     {"2"},
+		"$count":   {"true"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	if v["@iot.count"] != 7.0 {
+		t.Errorf("expected 7 things in Osona, got %v", v["@iot.count"])
+	}
+	things := v["value"].([]interface{})
+	if len(things) != 2 {
+		t.Fatalf("expected 2 things, got %d", len(things))
+	}
+	first := things[0].(map[string]interface{})
+	second := things[1].(map[string]interface{})
+	if first["name"].(string) < second["name"].(string) {
+		t.Errorf("things not in descending order: %v, %v", first["name"], second["name"])
+	}
+	if first["Datastreams@iot.navigationLink"] != first["@iot.selfLink"].(string)+"/Datastreams" {
+		t.Errorf("unexpected navigation link %v", first["Datastreams@iot.navigationLink"])
+	}
+	next, _ := v["@iot.nextLink"].(string)
+	if !strings.Contains(next, "%24skip=2") || !strings.HasPrefix(next, "http://example.org/v1.1/Things?") {
+		t.Errorf("unexpected next link %q", next)
+	}
+}
+
+// TestObservations tests that the observations of a datastream are loaded for the range of the filter.
+func TestObservations(t *testing.T) {
+	s, requested := newTestServer(t)
+
+	code, v := get(t, s, "/v1.1/Datastreams('D5-32')/Observations", url.Values{
+		"$filter":  {"phenomenonTime ge 2023-03-12T10:00:00Z and phenomenonTime lt 2023-03-12T12:00:00Z and result gt 0"},
+		"$orderby": {"result desc"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+
+	from := time.Date(2023, 3, 12, 10, 0, 0, 0, time.UTC)
+	if len(*requested) != 1 || (*requested)[0] != (window{"D5", from, from.Add(2 * time.Hour)}) {
+		t.Errorf("unexpected requested ranges %v", *requested)
+	}
+
+	obs := v["value"].([]interface{})
+	if len(obs) != 4 {
+		t.Fatalf("expected 4 observations, got %d", len(obs))
+	}
+	for i := 1; i < len(obs); i++ {
+		if obs[i-1].(map[string]interface{})["result"].(float64) < obs[i].(map[string]interface{})["result"].(float64) {
+			t.Errorf("observations not in descending order of result")
+		}
+	}
+
+	first := obs[0].(map[string]interface{})
+	id := first["@iot.id"].(string)
+	code, o := get(t, s, "/v1.1/Observations('"+id+"')", url.Values{"$expand": {"Datastream/ObservedProperty,FeatureOfInterest"}})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, o)
+	}
+	if o["result"] != first["result"] || o["phenomenonTime"] != first["phenomenonTime"] {
+		t.Errorf("unexpected observation %v", o)
+	}
+	ds := o["Datastream"].(map[string]interface{})
+	if ds["@iot.id"] != "D5-32" || ds["ObservedProperty"].(map[string]interface{})["name"] != "Temperatura" {
+		t.Errorf("unexpected expanded datastream %v", ds)
+	}
+	if o["FeatureOfInterest"].(map[string]interface{})["@iot.id"] != "D5" {
+		t.Errorf("unexpected feature of interest %v", o["FeatureOfInterest"])
+	}
+}
+
+// TestExpand tests the latest observation of the datastreams of a thing with nested expand options.
+func TestExpand(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	code, v := get(t, s, "/v1.1/Things('cc')", url.Values{
+		"$expand": {"Datastreams($expand=Observations($orderby=phenomenonTime desc;$top=1)),Locations"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", code, v)
+	}
+	if v["@iot.id"] != "CC" || v["properties"].(map[string]interface{})["comarca"] != "Osona" {
+		t.Errorf("unexpected thing %v", v)
+	}
+
+	datastreams := v["Datastreams"].([]interface{})
+	if len(datastreams) != 1 {
+		t.Fatalf("expected 1 datastream, got %d", len(datastreams))
+	}
+	ds := datastreams[0].(map[string]interface{})
+	if ds["unitOfMeasurement"].(map[string]interface{})["symbol"] != "°C" {
+		t.Errorf("unexpected unit of measurement %v", ds["unitOfMeasurement"])
+	}
+	obs := ds["Observations"].([]interface{})
+	if len(obs) != 1 || obs[0].(map[string]interface{})["phenomenonTime"] != "2023-03-12T23:30:00Z" {
+		t.Errorf("unexpected latest observation %v", obs)
+	}
+
+	locations := v["Locations"].([]interface{})
+	coords := locations[0].(map[string]interface{})["location"].(map[string]interface{})["coordinates"].([]interface{})
+	if len(coords) != 3 || coords[0] != 2.20862 || coords[1] != 42.07398 {
+		t.Errorf("unexpected coordinates %v", coords)
+	}
+}
+
+// TestErrors tests the responses of invalid requests.
+func TestErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		path   string
+		query  url.Values
+		status int
+	}{
+		{"/v1.1/Things", url.Values{"$filter": {"name eq"}}, http.StatusBadRequest},
+		{"/v1.1/Things", url.Values{"$top": {"-1"}}, http.StatusBadRequest},
+		{"/v1.1/Things", url.Values{"$select": {"name"}}, http.StatusBadRequest},
+		{"/v1.1/Things('XX0')", nil, http.StatusNotFound},
+		{"/v1.1/Datastreams('D5-33')", nil, http.StatusNotFound},
+		{"/v1.1/Observations", nil, http.StatusBadRequest},
+		{"/v1.1/Things/Datastreams", nil, http.StatusBadRequest},
+		{"/v1.1/Things('D5')/Sensors", nil, http.StatusNotFound},
+		{"/v1.1/Things('D5')/Sensors/Datastreams", nil, http.StatusBadRequest},
+		{"/v1.1/Unknown", nil, http.StatusNotFound},
+		{"/v2/Things", nil, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		if code, v := get(t, s, tc.path, tc.query); code != tc.status {
+			t.Errorf("%s %v: expected status %d, got %d: %v", tc.path, tc.query, tc.status, code, v)
+		}
+	}
+
+	if code, v := get(t, s, "/v1.1/Things('D5')/name", nil); code != http.StatusOK || v["name"] != "Barcelona - Observatori Fabra" {
+		t.Errorf("unexpected property response %d: %v", code, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/v1.1/Things", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
+
+// TestLiveReadings tests that the live source requests the readings of each day of a station to the API.
+func TestLiveReadings(t *testing.T) {
+	body, err := ioutil.ReadFile("../testdata/mesurades_dia_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/2023/03/12") {
+			w.Write(body)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	src := LiveReadings("", meteocat.WithBaseURL(srv.URL+"/xema/v1")).(*live)
+	src.now = func() time.Time { return time.Date(2023, 3, 13, 0, 30, 0, 0, time.UTC) }
+
+	from := time.Date(2023, 3, 12, 23, 0, 0, 0, time.UTC)
+	lectures, err := src.Readings("d5", 32, from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lectures) != 2 || lectures[0].Data != "2023-03-12T23:00Z" {
+		t.Errorf("unexpected readings %+v", lectures)
+	}
+	if len(paths) != 2 || paths[0] != "/xema/v1/estacions/mesurades/D5/2023/03/12" || paths[1] != "/xema/v1/estacions/mesurades/D5/2023/03/13" {
+		t.Errorf("unexpected requests %v", paths)
+	}
+
+	if _, err := src.Readings("D5", 32, from.AddDate(0, -2, 0), from); err == nil {
+		t.Error("expected an error for a range longer than the limit")
+	}
+}
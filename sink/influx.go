@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// measurementEscaper escapes the special characters of measurement names
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+// tagEscaper escapes the special characters of tag keys and values
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// stringEscaper escapes the special characters of string field values
+var stringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+// Influx is a sink which writes readings to an InfluxDB write endpoint using the line protocol
+type Influx struct {
+	batch
+	url    string
+	config *config
+}
+
+// NewInflux returns a new Influx pointer which posts the readings to url, the write endpoint with the database or
+// bucket in its query, e.g http://localhost:8086/api/v2/write?org=meteo&bucket=xema. The measurement is xema unless
+// configured with WithName, and the token of InfluxDB 2 can be set with WithHeader("Authorization", "Token ...").
+func NewInflux(url string, options ...Option) (*Influx, error) {
+	c, err := newConfig("xema", options)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Influx{url: url, config: c}
+	s.batch = newBatch(c, s.send)
+	return s, nil
+}
+
+// Line returns the line protocol of a reading. The station, the variable and the time base are tags, the value
+// and its state are fields and the timestamp is in nanoseconds.
+func Line(measurement string, r export.Row) (string, error) {
+	t, err := meteocat.ParseData(r.Lectura.Data)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+	b.WriteString(",estacio=")
+	b.WriteString(tagEscaper.Replace(r.Estacio))
+	b.WriteString(",variable=")
+	b.WriteString(strconv.Itoa(r.Variable))
+	if r.Lectura.BaseHoraria != "" {
+		b.WriteString(",base_horaria=")
+		b.WriteString(tagEscaper.Replace(r.Lectura.BaseHoraria))
+	}
+	b.WriteString(" valor=")
+	b.WriteString(strconv.FormatFloat(r.Lectura.Valor, 'f', -1, 64))
+	b.WriteString(`,estat="`)
+	b.WriteString(stringEscaper.Replace(r.Lectura.Estat))
+	b.WriteString(`" `)
+	b.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	return b.String(), nil
+}
+
+// send posts a batch of readings
+func (s *Influx) send(rows []export.Row) error {
+	var b strings.Builder
+	for _, r := range rows {
+		l, err := Line(s.config.name, r)
+		if err != nil {
+			return err
+		}
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return s.config.post(s.url, "text/plain; charset=utf-8", []byte(b.String()), nil)
+}
@@ -0,0 +1,203 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+)
+
+// receiver records the requests sent to a test server
+type receiver struct {
+	srv      *httptest.Server
+	bodies   [][]byte
+	requests []*http.Request
+	status   int
+}
+
+// newReceiver starts a test server which records the requests and answers them with status
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+
+	rc := &receiver{status: status}
+	rc.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		rc.bodies = append(rc.bodies, body)
+		rc.requests = append(rc.requests, r)
+		w.WriteHeader(rc.status)
+	}))
+	return rc
+}
+
+// TestLine tests the line protocol of a reading and the escaping of tags and fields.
+func TestLine(t *testing.T) {
+	r := export.Row{
+		Estacio:  "D5",
+		Variable: 32,
+		Lectura:  meteocat.Lectura{Data: "2023-03-12T10:30Z", Valor: 15.2, Estat: "V", BaseHoraria: "SH"},
+	}
+
+	l, err := Line("xema", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `xema,estacio=D5,variable=32,base_horaria=SH valor=15.2,estat="V" 1678617000000000000`; l != want {
+		t.Errorf("expected %s, got %s", want, l)
+	}
+
+	r.Estacio = "a b,c=d"
+	r.Lectura.Estat = `"`
+	l, _ = Line("my measure", r)
+	if want := `my\ measure,estacio=a\ b\,c\=d,variable=32,base_horaria=SH valor=15.2,estat="\"" 1678617000000000000`; l != want {
+		t.Errorf("expected %s, got %s", want, l)
+	}
+
+	r.Lectura.Data = "yesterday"
+	if _, err := Line("xema", r); err == nil {
+		t.Error("expected an error for an invalid date")
+	}
+}
+
+// TestInflux tests that the readings of a day are posted in batches.
+func TestInflux(t *testing.T) {
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	rc := newReceiver(t, http.StatusNoContent)
+	defer rc.srv.Close()
+
+	s, err := NewInflux(rc.srv.URL+"/api/v2/write?bucket=xema", WithBatchSize(1000), WithHeader("Authorization", "Token secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMeasurements(s, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(export.Row{}); err != errClosed {
+		t.Errorf("expected %v, got %v", errClosed, err)
+	}
+
+	var lines []string
+	for _, b := range rc.bodies {
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")...)
+	}
+
+	var total int
+	for _, st := range m {
+		for _, v := range st.Variables {
+			total += len(v.Lectures)
+		}
+	}
+	if want := (total + 999) / 1000; len(rc.bodies) != want {
+		t.Errorf("expected %d batches, got %d", want, len(rc.bodies))
+	}
+	if len(lines) != total {
+		t.Errorf("expected %d lines, got %d", total, len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "xema,estacio=C6,variable=32,base_horaria=SH valor=14.6,") {
+		t.Errorf("unexpected first line %s", lines[0])
+	}
+
+	req := rc.requests[0]
+	if req.URL.Query().Get("bucket") != "xema" || req.Header.Get("Authorization") != "Token secret" {
+		t.Errorf("unexpected request %s %v", req.URL, req.Header)
+	}
+}
+
+// TestInfluxError tests that a failed batch is kept to be retried, the reading being accepted by the Write which
+// sent it.
+func TestInfluxError(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError)
+	defer rc.srv.Close()
+
+	s, _ := NewInflux(rc.srv.URL, WithBatchSize(1))
+	r := export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T10:30Z", Valor: 15.2}}
+	if err := s.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.bodies) != 1 || len(s.rows) != 1 {
+		t.Fatalf("expected a failed request and the reading pending, got %q and %+v", rc.bodies, s.rows)
+	}
+	if err := s.Flush(); err == nil {
+		t.Fatal("expected an error for the failed request")
+	}
+
+	rc.status = http.StatusNoContent
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.bodies) != 3 || string(rc.bodies[0]) != string(rc.bodies[2]) {
+		t.Errorf("expected the batch to be sent again, got %q", rc.bodies)
+	}
+}
+
+// TestInvalidRow tests that a reading with an invalid date is rejected by Write and does not block the batch.
+func TestInvalidRow(t *testing.T) {
+	rc := newReceiver(t, http.StatusNoContent)
+	defer rc.srv.Close()
+
+	s, _ := NewInflux(rc.srv.URL)
+	if err := s.Write(export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "12/03/2023"}}); err == nil {
+		t.Error("expected an error for the invalid date")
+	}
+	if err := s.Write(export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T10:30Z"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.bodies) != 1 || strings.Count(string(rc.bodies[0]), "\n") != 1 {
+		t.Errorf("expected the valid reading only, got %q", rc.bodies)
+	}
+}
+
+// TestMaxPending tests that the oldest readings are dropped while the backend is down and that the pending ones are
+// sent in batches once it is back.
+func TestMaxPending(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError)
+	defer rc.srv.Close()
+
+	s, err := NewInflux(rc.srv.URL, WithBatchSize(2), WithMaxPending(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		r := export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: fmt.Sprintf("2023-03-12T10:%02dZ", i), Valor: float64(i)}}
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.rows) != 3 || s.Dropped() != 4 || s.rows[0].Lectura.Valor != 4 {
+		t.Errorf("expected the 3 newest readings pending and 4 dropped, got %+v and %d", s.rows, s.Dropped())
+	}
+
+	rc.status = http.StatusNoContent
+	rc.bodies = nil
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.bodies) != 2 || len(s.rows) != 0 {
+		t.Errorf("expected 2 requests, got %q", rc.bodies)
+	}
+}
+
+// TestOptions tests that invalid options are rejected.
+func TestOptions(t *testing.T) {
+	for _, op := range []Option{nil, WithBatchSize(0), WithHttpClient(nil), WithName(""), WithMaxPending(0), WithMaxPending(DefaultBatchSize - 1)} {
+		if _, err := NewInflux("http://localhost", op); err == nil {
+			t.Errorf("expected an error for option %p", op)
+		}
+	}
+}
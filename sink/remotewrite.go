@@ -0,0 +1,136 @@
+package sink
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/golang/snappy"
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// label is a Prometheus label
+type label struct {
+	name  string
+	value string
+}
+
+// sample is a Prometheus sample with its timestamp in milliseconds
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+// timeSeries holds the samples of a series, identified by its labels sorted by name
+type timeSeries struct {
+	labels  []label
+	samples []sample
+}
+
+// RemoteWrite is a sink which sends readings to a Prometheus remote-write endpoint
+type RemoteWrite struct {
+	batch
+	url    string
+	config *config
+}
+
+// NewRemoteWrite returns a new RemoteWrite pointer which sends the readings to url, e.g
+// http://localhost:9090/api/v1/write. The metric name is meteocat_lectura unless configured with WithName, and
+// the station, the variable and the time base are labels of each series.
+func NewRemoteWrite(url string, options ...Option) (*RemoteWrite, error) {
+	c, err := newConfig("meteocat_lectura", options)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RemoteWrite{url: url, config: c}
+	s.batch = newBatch(c, s.send)
+	return s, nil
+}
+
+// series groups readings in time series with their samples in time order
+func series(name string, rows []export.Row) ([]timeSeries, error) {
+	var all []timeSeries
+	index := make(map[string]int)
+	for _, r := range rows {
+		t, err := meteocat.ParseData(r.Lectura.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := []label{{"__name__", name}}
+		if r.Lectura.BaseHoraria != "" {
+			labels = append(labels, label{"base_horaria", r.Lectura.BaseHoraria})
+		}
+		labels = append(labels, label{"estacio", r.Estacio}, label{"variable", strconv.Itoa(r.Variable)})
+		key := r.Estacio + "\xff" + strconv.Itoa(r.Variable) + "\xff" + r.Lectura.BaseHoraria
+
+		i, ok := index[key]
+		if !ok {
+			i = len(all)
+			index[key] = i
+			all = append(all, timeSeries{labels: labels})
+		}
+		all[i].samples = append(all[i].samples, sample{value: r.Lectura.Valor, timestamp: t.UnixNano() / 1e6})
+	}
+
+	for _, ts := range all {
+		s := ts.samples
+		sort.SliceStable(s, func(i, j int) bool { return s[i].timestamp < s[j].timestamp })
+	}
+	return all, nil
+}
+
+// protoVarint appends a protobuf varint
+func protoVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// protoBytes appends a length delimited protobuf field
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = protoVarint(b, uint64(field)<<3|2)
+	b = protoVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// marshalWriteRequest encodes the series as a prometheus.WriteRequest protobuf message
+func marshalWriteRequest(all []timeSeries) []byte {
+	var req []byte
+	for _, ts := range all {
+		var m []byte
+		for _, l := range ts.labels {
+			var lb []byte
+			lb = protoBytes(lb, 1, []byte(l.name))
+			lb = protoBytes(lb, 2, []byte(l.value))
+			m = protoBytes(m, 1, lb)
+		}
+		for _, s := range ts.samples {
+			var sb [8]byte
+			binary.LittleEndian.PutUint64(sb[:], math.Float64bits(s.value))
+			smp := append([]byte{1<<3 | 1}, sb[:]...)
+			smp = protoVarint(smp, 2<<3|0)
+			smp = protoVarint(smp, uint64(s.timestamp))
+			m = protoBytes(m, 2, smp)
+		}
+		req = protoBytes(req, 1, m)
+	}
+	return req
+}
+
+// send posts a batch of readings as a snappy compressed WriteRequest
+func (s *RemoteWrite) send(rows []export.Row) error {
+	all, err := series(s.config.name, rows)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{
+		"Content-Encoding":                  {"snappy"},
+		"X-Prometheus-Remote-Write-Version": {"0.1.0"},
+	}
+	return s.config.post(s.url, "application/x-protobuf", snappy.Encode(nil, marshalWriteRequest(all)), header)
+}
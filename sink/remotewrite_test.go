@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// field is a decoded protobuf field
+type field struct {
+	num   int
+	bytes []byte
+	value uint64
+}
+
+// protoFields decodes the fields of a protobuf message
+func protoFields(t *testing.T, b []byte) []field {
+	t.Helper()
+
+	var fields []field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		b = b[n:]
+		f := field{num: int(tag >> 3)}
+		switch tag & 7 {
+		case 0:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case 1:
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// TestRemoteWrite tests the WriteRequest sent for readings of two series.
+func TestRemoteWrite(t *testing.T) {
+	rc := newReceiver(t, http.StatusNoContent)
+	defer rc.srv.Close()
+
+	s, err := NewRemoteWrite(rc.srv.URL + "/api/v1/write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []export.Row{
+		{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T10:30Z", Valor: 15.2, BaseHoraria: "SH"}},
+		{Estacio: "X4", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T10:00Z", Valor: 14.1, BaseHoraria: "SH"}},
+		{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-12T10:00Z", Valor: 14.9, BaseHoraria: "SH"}},
+	}
+	for _, r := range rows {
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rc.requests))
+	}
+	h := rc.requests[0].Header
+	if h.Get("Content-Encoding") != "snappy" || h.Get("Content-Type") != "application/x-protobuf" ||
+		h.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("unexpected headers %v", h)
+	}
+
+	body, err := snappy.Decode(nil, rc.bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := protoFields(t, body)
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(series))
+	}
+
+	var labels []string
+	var values []float64
+	var timestamps []uint64
+	for _, f := range protoFields(t, series[0].bytes) {
+		switch f.num {
+		case 1:
+			for _, l := range protoFields(t, f.bytes) {
+				labels = append(labels, string(l.bytes))
+			}
+		case 2:
+			for _, s := range protoFields(t, f.bytes) {
+				if s.num == 1 {
+					values = append(values, math.Float64frombits(s.value))
+				} else {
+					timestamps = append(timestamps, s.value)
+				}
+			}
+		}
+	}
+
+	want := []string{"__name__", "meteocat_lectura", "base_horaria", "SH", "estacio", "D5", "variable", "32"}
+	if len(labels) != len(want) {
+		t.Fatalf("expected labels %v, got %v", want, labels)
+	}
+	for i := range want {
+		if labels[i] != want[i] {
+			t.Errorf("expected labels %v, got %v", want, labels)
+			break
+		}
+	}
+	if len(values) != 2 || values[0] != 14.9 || values[1] != 15.2 || timestamps[0] != 1678615200000 || timestamps[1] != 1678617000000 {
+		t.Errorf("expected samples in time order, got %v at %v", values, timestamps)
+	}
+}
@@ -0,0 +1,250 @@
+// Package sink pushes readings into time-series databases. Readings are buffered and sent in batches, either when
+// a batch is full or when the sink is flushed or closed. Readings which cannot be sent, e.g with an invalid date,
+// are rejected by Write, and the readings pending while the backend is down are bounded by dropping the oldest ones.
+//
+// InfluxDB sinks write the line protocol to a write endpoint, and Prometheus sinks send remote-write requests, so
+// observations can be stored next to the rest of a monitoring stack.
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// DefaultBatchSize is the number of readings of a batch when no size is configured
+const DefaultBatchSize = 5000
+
+// DefaultMaxPendingBatches is the number of batches kept pending while the backend is down when no limit is
+// configured
+const DefaultMaxPendingBatches = 10
+
+var errClosed = errors.New("sink closed")
+var errInvalidOption = errors.New("invalid option")
+var errInvalidHttpClient = errors.New("invalid http client")
+
+// Sink receives readings and delivers them to a storage backend
+type Sink interface {
+	// Write adds a reading, delivering the pending ones if the batch is full. An error means the reading was
+	// rejected, a failed delivery is reported by the next Flush.
+	Write(r export.Row) error
+	// Flush delivers the pending readings
+	Flush() error
+	// Close flushes the pending readings and releases the sink
+	Close() error
+}
+
+// WriteVariable writes the readings of a variable measured at a station to s
+func WriteVariable(s Sink, estacio string, v meteocat.Variable) error {
+	for _, l := range v.Lectures {
+		if err := s.Write(export.Row{Estacio: estacio, Variable: v.Codi, Lectura: l}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMeasurements writes the readings of all the stations and variables of m to s
+func WriteMeasurements(s Sink, m meteocat.Measurements) error {
+	for _, st := range m {
+		for _, v := range st.Variables {
+			if err := WriteVariable(s, st.Codi, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// config holds the settings shared by the HTTP sinks
+type config struct {
+	client     *http.Client
+	batchSize  int
+	maxPending int
+	name       string
+	header     http.Header
+}
+
+// Option configures a sink
+type Option func(c *config) error
+
+// WithBatchSize sets the number of readings sent in each request.
+func WithBatchSize(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errInvalidOption
+		}
+		c.batchSize = n
+		return nil
+	}
+}
+
+// WithMaxPending sets the number of readings kept pending while the backend is down, DefaultMaxPendingBatches
+// batches by default. Once it is reached the oldest batch of readings is dropped. It must be at least the batch size.
+func WithMaxPending(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errInvalidOption
+		}
+		c.maxPending = n
+		return nil
+	}
+}
+
+// WithHttpClient sets the http client used to send the batches.
+func WithHttpClient(client *http.Client) Option {
+	return func(c *config) error {
+		if client == nil {
+			return errInvalidHttpClient
+		}
+		c.client = client
+		return nil
+	}
+}
+
+// WithName sets the InfluxDB measurement or the Prometheus metric name of the readings.
+func WithName(name string) Option {
+	return func(c *config) error {
+		if name == "" {
+			return errInvalidOption
+		}
+		c.name = name
+		return nil
+	}
+}
+
+// WithHeader adds a header to every request, e.g the Authorization header of the backend.
+func WithHeader(key, value string) Option {
+	return func(c *config) error {
+		c.header.Add(key, value)
+		return nil
+	}
+}
+
+// newConfig applies the options to a new config with the default name of the sink
+func newConfig(name string, options []Option) (*config, error) {
+	c := &config{
+		client:    http.DefaultClient,
+		batchSize: DefaultBatchSize,
+		name:      name,
+		header:    make(http.Header),
+	}
+
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.maxPending == 0 {
+		c.maxPending = DefaultMaxPendingBatches * c.batchSize
+	}
+	if c.maxPending < c.batchSize {
+		return nil, errInvalidOption
+	}
+	return c, nil
+}
+
+// post sends a request body to url with the configured headers and checks the response status
+func (c *config) post(url, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// batch buffers readings and sends them once full
+type batch struct {
+	size       int
+	maxPending int
+	rows       []export.Row
+	dropped    int
+	send       func(rows []export.Row) error
+	closed     bool
+}
+
+// newBatch returns a batch with the sizes of the config which sends the readings with send
+func newBatch(c *config, send func(rows []export.Row) error) batch {
+	return batch{size: c.batchSize, maxPending: c.maxPending, send: send}
+}
+
+// Write adds a reading, sending the batch once it is full. Readings with an invalid date are rejected, as they
+// would fail every request. A failed request is not reported, the reading being accepted, so retrying the Write
+// does not duplicate it: the readings stay pending and the error is returned by the next Flush. When the pending
+// readings reach the limit the oldest batch is dropped.
+func (b *batch) Write(r export.Row) error {
+	if b.closed {
+		return errClosed
+	}
+	if _, err := meteocat.ParseData(r.Lectura.Data); err != nil {
+		return err
+	}
+	if len(b.rows) >= b.maxPending {
+		n := copy(b.rows, b.rows[b.size:])
+		b.dropped += len(b.rows) - n
+		b.rows = b.rows[:n]
+	}
+	b.rows = append(b.rows, r)
+	if len(b.rows) >= b.size {
+		b.Flush()
+	}
+	return nil
+}
+
+// Flush sends the pending readings in batches. The readings of a failed request and the following ones are kept,
+// so it can be retried.
+func (b *batch) Flush() error {
+	if b.closed {
+		return errClosed
+	}
+	for len(b.rows) > 0 {
+		n := len(b.rows)
+		if n > b.size {
+			n = b.size
+		}
+		if err := b.send(b.rows[:n]); err != nil {
+			return err
+		}
+		b.rows = b.rows[:copy(b.rows, b.rows[n:])]
+	}
+	return nil
+}
+
+// Dropped returns the number of readings dropped because the backend was down for too long
+func (b *batch) Dropped() int {
+	return b.dropped
+}
+
+// Close sends the pending readings and rejects any further ones
+func (b *batch) Close() error {
+	if b.closed {
+		return errClosed
+	}
+	err := b.Flush()
+	b.closed = true
+	return err
+}
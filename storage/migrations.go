@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// migration upgrades the schema to its version. Statements use {timestamp} for the timestamp column type.
+type migration struct {
+	version    int
+	statements []string
+}
+
+// migrations holds the schema versions in order. Released migrations must never change; add a new one instead.
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE estacions (
+				codi           TEXT PRIMARY KEY,
+				nom            TEXT NOT NULL,
+				tipus          TEXT NOT NULL,
+				latitud        DOUBLE PRECISION NOT NULL,
+				longitud       DOUBLE PRECISION NOT NULL,
+				emplacament    TEXT NOT NULL,
+				altitud        DOUBLE PRECISION NOT NULL,
+				municipi_codi  TEXT NOT NULL,
+				municipi       TEXT NOT NULL,
+				comarca_codi   INTEGER NOT NULL,
+				comarca        TEXT NOT NULL,
+				provincia_codi INTEGER NOT NULL,
+				provincia      TEXT NOT NULL,
+				xarxa_codi     INTEGER NOT NULL,
+				xarxa          TEXT NOT NULL
+			)`,
+			`CREATE TABLE estats_estacio (
+				codi_estacio TEXT NOT NULL REFERENCES estacions (codi),
+				data_inici   {timestamp} NOT NULL,
+				data_fi      {timestamp},
+				codi         INTEGER NOT NULL,
+				PRIMARY KEY (codi_estacio, data_inici)
+			)`,
+			`CREATE TABLE variables (
+				codi     INTEGER PRIMARY KEY,
+				nom      TEXT NOT NULL,
+				unitats  TEXT NOT NULL,
+				acronim  TEXT NOT NULL,
+				tipus    TEXT NOT NULL,
+				decimals INTEGER NOT NULL
+			)`,
+			`CREATE TABLE lectures (
+				codi_estacio  TEXT NOT NULL,
+				codi_variable INTEGER NOT NULL,
+				data          {timestamp} NOT NULL,
+				valor         DOUBLE PRECISION NOT NULL,
+				estat         TEXT NOT NULL,
+				base_horaria  TEXT NOT NULL,
+				PRIMARY KEY (codi_estacio, codi_variable, data)
+			)`,
+			`CREATE INDEX lectures_variable_data ON lectures (codi_variable, data)`,
+		},
+	},
+}
+
+// LatestVersion returns the schema version created by Migrate
+func LatestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Version returns the schema version of the database, 0 when it has not been migrated
+func (s *Store) Version() (int, error) {
+	if _, err := s.db.Exec(s.dialect.ddl(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at {timestamp} NOT NULL
+	)`)); err != nil {
+		return 0, err
+	}
+
+	var v sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+// Migrate upgrades the schema to LatestVersion, applying each pending migration in its own transaction. It fails
+// if the database was migrated by a newer version of the package.
+func (s *Store) Migrate() error {
+	current, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return errSchemaTooNew
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := s.tx(func(tx *sql.Tx) error {
+			for _, stmt := range m.statements {
+				if _, err := tx.Exec(s.dialect.ddl(stmt)); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(s.dialect.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+				m.version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"database/sql"
+
+	// Pure Go SQLite driver, registered as sqlite
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens the SQLite database at path, creating it if needed, and migrates its schema. Use ":memory:" for
+// a temporary in-memory database. The database is closed by closing DB().
+func OpenSQLite(path string, options ...Option) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer and each connection to ":memory:" is a different database
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`PRAGMA foreign_keys = ON; PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s, err := Open(db, SQLite, options...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
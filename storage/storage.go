@@ -0,0 +1,293 @@
+// Package storage keeps stations, variables and readings in a SQL database, so every consumer of the API shares
+// the same tables instead of writing its own. It works with any database/sql driver of the supported dialects,
+// SQLite and PostgreSQL, and ships a pure Go SQLite driver through OpenSQLite so it builds without system libraries.
+//
+// The schema is versioned and upgraded with Migrate. Every write is an upsert keyed by station, variable and
+// timestamp, so loading the same day twice leaves the tables unchanged.
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// DefaultBatchSize is the number of readings written in a transaction when no size is configured
+const DefaultBatchSize = 1000
+
+var errClosed = errors.New("store closed")
+var errInvalidOption = errors.New("invalid option")
+var errSchemaTooNew = errors.New("database schema is newer than this package")
+
+// Dialect holds the SQL differences between the supported databases
+type Dialect struct {
+	Name      string // Name of the dialect
+	timestamp string // Column type of the timestamps
+	numbered  bool   // Placeholders are numbered, $1, $2... instead of ?
+}
+
+// Supported dialects
+var (
+	SQLite     = Dialect{Name: "sqlite", timestamp: "TIMESTAMP"}
+	PostgreSQL = Dialect{Name: "postgres", timestamp: "TIMESTAMPTZ", numbered: true}
+)
+
+// rebind rewrites the ? placeholders of a query for the dialect
+func (d Dialect) rebind(q string) string {
+	if !d.numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// ddl replaces the {timestamp} column types of a schema statement for the dialect
+func (d Dialect) ddl(q string) string {
+	return strings.Replace(q, "{timestamp}", d.timestamp, -1)
+}
+
+// Store reads and writes the tables of a database. It implements the sink.Sink interface, buffering the readings
+// passed to Write and upserting them in a transaction once a batch is full.
+type Store struct {
+	db        *sql.DB
+	dialect   Dialect
+	batchSize int
+	pending   []export.Row
+	closed    bool
+}
+
+// Option configures a Store
+type Option func(s *Store) error
+
+// WithBatchSize sets the number of readings upserted in each transaction by Write.
+func WithBatchSize(n int) Option {
+	return func(s *Store) error {
+		if n <= 0 {
+			return errInvalidOption
+		}
+		s.batchSize = n
+		return nil
+	}
+}
+
+// Open returns a new Store pointer which uses db with the given dialect. The schema is not created nor upgraded
+// until Migrate is called.
+func Open(db *sql.DB, d Dialect, options ...Option) (*Store, error) {
+	s := &Store{db: db, dialect: d, batchSize: DefaultBatchSize}
+
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// DB returns the database of the store
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// tx runs f in a transaction, committing it if f succeeds
+func (s *Store) tx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// parseData parses an API date as a UTC time
+func parseData(s string) (time.Time, error) {
+	t, err := meteocat.ParseData(s)
+	return t.UTC(), err
+}
+
+// UpsertStations writes the metadata of the stations and their states
+func (s *Store) UpsertStations(stations meteocat.MetadadesTotesEstacions) error {
+	return s.tx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(s.dialect.rebind(`
+			INSERT INTO estacions (codi, nom, tipus, latitud, longitud, emplacament, altitud, municipi_codi,
+				municipi, comarca_codi, comarca, provincia_codi, provincia, xarxa_codi, xarxa)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (codi) DO UPDATE SET nom = excluded.nom, tipus = excluded.tipus,
+				latitud = excluded.latitud, longitud = excluded.longitud, emplacament = excluded.emplacament,
+				altitud = excluded.altitud, municipi_codi = excluded.municipi_codi, municipi = excluded.municipi,
+				comarca_codi = excluded.comarca_codi, comarca = excluded.comarca,
+				provincia_codi = excluded.provincia_codi, provincia = excluded.provincia,
+				xarxa_codi = excluded.xarxa_codi, xarxa = excluded.xarxa`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		estat, err := tx.Prepare(s.dialect.rebind(`
+			INSERT INTO estats_estacio (codi_estacio, data_inici, data_fi, codi)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (codi_estacio, data_inici) DO UPDATE SET data_fi = excluded.data_fi, codi = excluded.codi`))
+		if err != nil {
+			return err
+		}
+		defer estat.Close()
+
+		for _, e := range stations {
+			_, err := stmt.Exec(e.Codi, e.Nom, e.Tipus, e.Coordenades.Latitud, e.Coordenades.Longitud,
+				e.Emplacament, e.Altitud, e.Municipi.Codi, e.Municipi.Nom, e.Comarca.Codi, e.Comarca.Nom,
+				e.Provincia.Codi, e.Provincia.Nom, e.Xarxa.Codi, e.Xarxa.Nom)
+			if err != nil {
+				return err
+			}
+
+			for _, st := range e.Estats {
+				inici, err := parseData(st.DataInici)
+				if err != nil {
+					return err
+				}
+				var fi sql.NullTime
+				if d, ok := st.DataFi.(string); ok && d != "" {
+					if fi.Time, err = parseData(d); err != nil {
+						return err
+					}
+					fi.Valid = true
+				}
+				if _, err := estat.Exec(e.Codi, inici, fi, st.Codi); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertVariables writes the metadata of the variables
+func (s *Store) UpsertVariables(variables meteocat.MetadadesVariables) error {
+	return s.tx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(s.dialect.rebind(`
+			INSERT INTO variables (codi, nom, unitats, acronim, tipus, decimals)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (codi) DO UPDATE SET nom = excluded.nom, unitats = excluded.unitats,
+				acronim = excluded.acronim, tipus = excluded.tipus, decimals = excluded.decimals`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, v := range variables {
+			if _, err := stmt.Exec(v.Codi, v.Nom, v.Unitats, v.Acronim, v.Tipus, v.Decimals); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertReadings writes readings in a transaction. A reading of the same station, variable and timestamp replaces
+// the stored one.
+func (s *Store) UpsertReadings(rows []export.Row) error {
+	return s.tx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(s.dialect.rebind(`
+			INSERT INTO lectures (codi_estacio, codi_variable, data, valor, estat, base_horaria)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (codi_estacio, codi_variable, data) DO UPDATE SET valor = excluded.valor,
+				estat = excluded.estat, base_horaria = excluded.base_horaria`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, r := range rows {
+			t, err := parseData(r.Lectura.Data)
+			if err != nil {
+				return err
+			}
+			_, err = stmt.Exec(r.Estacio, r.Variable, t, r.Lectura.Valor, r.Lectura.Estat, r.Lectura.BaseHoraria)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Readings returns the readings of a variable at a station within [from, to), in time order
+func (s *Store) Readings(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error) {
+	rows, err := s.db.Query(s.dialect.rebind(`
+		SELECT data, valor, estat, base_horaria FROM lectures
+		WHERE codi_estacio = ? AND codi_variable = ? AND data >= ? AND data < ?
+		ORDER BY data`), estacio, variable, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lectures []meteocat.Lectura
+	for rows.Next() {
+		var t time.Time
+		var l meteocat.Lectura
+		if err := rows.Scan(&t, &l.Valor, &l.Estat, &l.BaseHoraria); err != nil {
+			return nil, err
+		}
+		l.Data = t.UTC().Format("2006-01-02T15:04Z")
+		lectures = append(lectures, l)
+	}
+	return lectures, rows.Err()
+}
+
+// Write adds a reading, upserting the pending ones once the batch is full
+func (s *Store) Write(r export.Row) error {
+	if s.closed {
+		return errClosed
+	}
+	s.pending = append(s.pending, r)
+	if len(s.pending) >= s.batchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush upserts the pending readings
+func (s *Store) Flush() error {
+	if s.closed {
+		return errClosed
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := s.UpsertReadings(s.pending); err != nil {
+		return err
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// Close upserts the pending readings and rejects any further ones. It does not close the database.
+func (s *Store) Close() error {
+	if s.closed {
+		return errClosed
+	}
+	err := s.Flush()
+	s.closed = true
+	return err
+}
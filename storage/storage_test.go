@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+	"github.com/oscaromeu/meteocat/sink"
+)
+
+var _ sink.Sink = (*Store)(nil)
+
+// count returns the number of rows of a table
+func count(t *testing.T, s *Store, table string) int {
+	t.Helper()
+
+	var n int
+	if err := s.DB().QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestMigrate tests that migrations are applied once and that newer schemas are rejected.
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "xema.db")
+
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Version(); err != nil || v != LatestVersion() {
+		t.Errorf("expected version %d, got %d (%v)", LatestVersion(), v, err)
+	}
+	if err := s.Migrate(); err != nil {
+		t.Errorf("expected migrating twice to be a no-op, got %v", err)
+	}
+	if n := count(t, s, "schema_migrations"); n != len(migrations) {
+		t.Errorf("expected %d migrations, got %d", len(migrations), n)
+	}
+
+	if _, err := s.DB().Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, LatestVersion()+1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	s.DB().Close()
+
+	if _, err := OpenSQLite(path); err != errSchemaTooNew {
+		t.Errorf("expected %v, got %v", errSchemaTooNew, err)
+	}
+}
+
+// TestUpsertStations tests that loading the station catalogue twice keeps one row per station and state.
+func TestUpsertStations(t *testing.T) {
+	var stations meteocat.MetadadesTotesEstacions
+	testutil.Load(t, "metadades_totes_estacions.json", &stations)
+
+	s, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DB().Close()
+
+	var estats int
+	for _, e := range stations {
+		estats += len(e.Estats)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.UpsertStations(stations); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(t, s, "estacions"); n != len(stations) {
+		t.Errorf("expected %d stations, got %d", len(stations), n)
+	}
+	if n := count(t, s, "estats_estacio"); n != estats {
+		t.Errorf("expected %d states, got %d", estats, n)
+	}
+
+	var nom, comarca string
+	var altitud float64
+	err = s.DB().QueryRow(`SELECT nom, comarca, altitud FROM estacions WHERE codi = 'C6'`).Scan(&nom, &comarca, &altitud)
+	if err != nil || nom != "Castellnou de Seana" || comarca != "Pla d'Urgell" || altitud != 264 {
+		t.Errorf("unexpected station C6: %s, %s, %v (%v)", nom, comarca, altitud, err)
+	}
+
+	var open int
+	if err := s.DB().QueryRow(`SELECT COUNT(*) FROM estats_estacio WHERE data_fi IS NULL`).Scan(&open); err != nil {
+		t.Fatal(err)
+	}
+	if open == 0 || open > len(stations) {
+		t.Errorf("expected at most one current state per station, got %d", open)
+	}
+}
+
+// TestUpsertVariables tests that the variable catalogue is written.
+func TestUpsertVariables(t *testing.T) {
+	var variables meteocat.MetadadesVariables
+	testutil.Load(t, "variables_mesurades_metadades.json", &variables)
+
+	s, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DB().Close()
+
+	if err := s.UpsertVariables(variables); err != nil {
+		t.Fatal(err)
+	}
+	variables[0].Nom = "Temperatura de l'aire"
+	if err := s.UpsertVariables(variables); err != nil {
+		t.Fatal(err)
+	}
+
+	var nom, acronim string
+	if err := s.DB().QueryRow(`SELECT nom, acronim FROM variables WHERE codi = 32`).Scan(&nom, &acronim); err != nil {
+		t.Fatal(err)
+	}
+	if nom != "Temperatura de l'aire" || acronim != "T" || count(t, s, "variables") != 3 {
+		t.Errorf("unexpected variable 32: %s, %s", nom, acronim)
+	}
+}
+
+// TestSink tests that readings written through the sink interface are idempotent and can be queried back.
+func TestSink(t *testing.T) {
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	s, err := OpenSQLite(":memory:", WithBatchSize(2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DB().Close()
+
+	var total int
+	for _, st := range m {
+		for _, v := range st.Variables {
+			total += len(v.Lectures)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.WriteMeasurements(s, m); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, s, "lectures"); n != total {
+		t.Errorf("expected %d readings, got %d", total, n)
+	}
+
+	from := time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC)
+	l, err := s.Readings("C6", 32, from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 2 || l[0].Data != "2023-03-12T00:00Z" || l[0].Valor != 14.6 || l[0].Estat != "V" || l[1].Data != "2023-03-12T00:30Z" {
+		t.Errorf("unexpected readings of the first hour: %+v", l)
+	}
+}
+
+// TestDialect tests the placeholders and column types of PostgreSQL.
+func TestDialect(t *testing.T) {
+	q := "SELECT * FROM lectures WHERE codi_estacio = ? AND data >= ?"
+	if got := PostgreSQL.rebind(q); got != "SELECT * FROM lectures WHERE codi_estacio = $1 AND data >= $2" {
+		t.Errorf("unexpected PostgreSQL query %s", got)
+	}
+	if got := SQLite.rebind(q); got != q {
+		t.Errorf("unexpected SQLite query %s", got)
+	}
+	if got := PostgreSQL.ddl("data {timestamp} NOT NULL"); got != "data TIMESTAMPTZ NOT NULL" {
+		t.Errorf("unexpected PostgreSQL column %s", got)
+	}
+}
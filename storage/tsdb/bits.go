@@ -0,0 +1,63 @@
+package tsdb
+
+import "errors"
+
+var errShortChunk = errors.New("chunk truncated")
+
+// bitWriter appends bits to a byte slice, most significant bit first
+type bitWriter struct {
+	b     []byte
+	count uint8 // Free bits of the last byte
+}
+
+// writeBit appends a single bit
+func (w *bitWriter) writeBit(bit bool) {
+	if w.count == 0 {
+		w.b = append(w.b, 0)
+		w.count = 8
+	}
+	w.count--
+	if bit {
+		w.b[len(w.b)-1] |= 1 << w.count
+	}
+}
+
+// writeBits appends the n least significant bits of v
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		n--
+		w.writeBit(v>>uint(n)&1 == 1)
+	}
+}
+
+// bitReader reads bits written by a bitWriter
+type bitReader struct {
+	b   []byte
+	pos int // Position in bits
+}
+
+// readBit reads a single bit
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= 8*len(r.b) {
+		return false, errShortChunk
+	}
+	bit := r.b[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+// readBits reads n bits as the least significant bits of the result
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for ; n > 0; n-- {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,307 @@
+package tsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"math/bits"
+)
+
+const chunkMagic = "XTS1"
+
+// gridStep is the expected delta between two timestamps in seconds, the 30 minutes of the SH time base. The first
+// delta of a chunk is encoded against it, so a chunk on the grid spends a single bit per timestamp.
+const gridStep = 1800
+
+var errCorruptChunk = errors.New("chunk corrupt")
+
+// point is a reading of a series, with its timestamp in Unix seconds
+type point struct {
+	t     int64
+	v     float64
+	estat string
+	base  string
+}
+
+// dodBuckets holds the prefix and the width of the delta-of-delta encodings, as in the Gorilla paper
+var dodBuckets = []struct {
+	prefix uint64
+	n      int // Width of the prefix
+	bits   int // Width of the value, two's complement
+}{
+	{0x2, 2, 7},
+	{0x6, 3, 9},
+	{0xe, 4, 12},
+}
+
+// chunkEncoder encodes the points of a chunk
+type chunkEncoder struct {
+	w        bitWriter
+	n        int
+	t, delta int64
+	v        uint64
+	lead     int
+	trail    int
+	estat    string
+	base     string
+}
+
+// writeDoD writes the delta of delta of a timestamp
+func (e *chunkEncoder) writeDoD(dod int64) {
+	if dod == 0 {
+		e.w.writeBit(false)
+		return
+	}
+	for _, b := range dodBuckets {
+		if min, max := -int64(1)<<uint(b.bits-1), int64(1)<<uint(b.bits-1)-1; dod >= min && dod <= max {
+			e.w.writeBits(b.prefix, b.n)
+			e.w.writeBits(uint64(dod), b.bits)
+			return
+		}
+	}
+	e.w.writeBits(0xf, 4)
+	e.w.writeBits(uint64(dod), 64)
+}
+
+// writeXOR writes a value as the XOR with the previous one
+func (e *chunkEncoder) writeXOR(v uint64) {
+	xor := v ^ e.v
+	if xor == 0 {
+		e.w.writeBit(false)
+		return
+	}
+	e.w.writeBit(true)
+
+	lead, trail := bits.LeadingZeros64(xor), bits.TrailingZeros64(xor)
+	if lead > 31 {
+		lead = 31
+	}
+	if e.lead >= 0 && lead >= e.lead && trail >= e.trail {
+		e.w.writeBit(false)
+		e.w.writeBits(xor>>uint(e.trail), 64-e.lead-e.trail)
+		return
+	}
+
+	meaningful := 64 - lead - trail
+	e.w.writeBit(true)
+	e.w.writeBits(uint64(lead), 5)
+	e.w.writeBits(uint64(meaningful), 6) // 64 wraps to 0
+	e.w.writeBits(xor>>uint(trail), meaningful)
+	e.lead, e.trail = lead, trail
+}
+
+// writeLabel writes a string, or a single bit when it repeats the previous one
+func (e *chunkEncoder) writeLabel(s string, prev *string) {
+	if s == *prev {
+		e.w.writeBit(false)
+		return
+	}
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	e.w.writeBit(true)
+	e.w.writeBits(uint64(len(s)), 8)
+	for i := 0; i < len(s); i++ {
+		e.w.writeBits(uint64(s[i]), 8)
+	}
+	*prev = s
+}
+
+// add encodes a point, which must be later than the previous one
+func (e *chunkEncoder) add(p point) {
+	v := math.Float64bits(p.v)
+	if e.n == 0 {
+		e.w.writeBits(uint64(p.t), 64)
+		e.w.writeBits(v, 64)
+		e.delta = gridStep
+	} else {
+		delta := p.t - e.t
+		e.writeDoD(delta - e.delta)
+		e.writeXOR(v)
+		e.delta = delta
+	}
+	e.writeLabel(p.estat, &e.estat)
+	e.writeLabel(p.base, &e.base)
+	e.t, e.v = p.t, v
+	e.n++
+}
+
+// encodeChunk returns the chunk file of points sorted by time without duplicates: the magic bytes, the number of
+// points as a varint, the bit stream and the CRC-32 of all the previous bytes.
+func encodeChunk(points []point) []byte {
+	e := &chunkEncoder{lead: -1}
+	for _, p := range points {
+		e.add(p)
+	}
+
+	b := append([]byte(chunkMagic), make([]byte, binary.MaxVarintLen64)...)
+	b = b[:len(chunkMagic)+binary.PutUvarint(b[len(chunkMagic):], uint64(len(points)))]
+	b = append(b, e.w.b...)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(b))
+	return append(b, sum[:]...)
+}
+
+// chunkDecoder decodes the points of a chunk
+type chunkDecoder struct {
+	r     bitReader
+	n     int
+	t     int64
+	delta int64
+	v     uint64
+	lead  int
+	trail int
+	estat string
+	base  string
+}
+
+// readDoD reads the delta of delta of a timestamp. The number of leading one bits selects the encoding.
+func (d *chunkDecoder) readDoD() (int64, error) {
+	ones := 0
+	for ones < len(dodBuckets)+1 {
+		bit, err := d.r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+	if ones == 0 {
+		return 0, nil
+	}
+	if ones > len(dodBuckets) {
+		v, err := d.r.readBits(64)
+		return int64(v), err
+	}
+
+	n := dodBuckets[ones-1].bits
+	v, err := d.r.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	if v&(1<<uint(n-1)) != 0 {
+		return int64(v) - int64(1)<<uint(n), nil
+	}
+	return int64(v), nil
+}
+
+// readXOR reads a value encoded as the XOR with the previous one
+func (d *chunkDecoder) readXOR() error {
+	bit, err := d.r.readBit()
+	if err != nil || !bit {
+		return err
+	}
+	if bit, err = d.r.readBit(); err != nil {
+		return err
+	}
+	if bit {
+		lead, err := d.r.readBits(5)
+		if err != nil {
+			return err
+		}
+		meaningful, err := d.r.readBits(6)
+		if err != nil {
+			return err
+		}
+		if meaningful == 0 {
+			meaningful = 64
+		}
+		d.lead, d.trail = int(lead), 64-int(lead)-int(meaningful)
+		if d.trail < 0 {
+			return errCorruptChunk
+		}
+	} else if d.lead < 0 {
+		return errCorruptChunk
+	}
+
+	xor, err := d.r.readBits(64 - d.lead - d.trail)
+	if err != nil {
+		return err
+	}
+	d.v ^= xor << uint(d.trail)
+	return nil
+}
+
+// readLabel reads a string written by writeLabel
+func (d *chunkDecoder) readLabel(prev *string) error {
+	bit, err := d.r.readBit()
+	if err != nil || !bit {
+		return err
+	}
+	n, err := d.r.readBits(8)
+	if err != nil {
+		return err
+	}
+	s := make([]byte, n)
+	for i := range s {
+		c, err := d.r.readBits(8)
+		if err != nil {
+			return err
+		}
+		s[i] = byte(c)
+	}
+	*prev = string(s)
+	return nil
+}
+
+// next decodes the next point
+func (d *chunkDecoder) next() (point, error) {
+	if d.n == 0 {
+		t, err := d.r.readBits(64)
+		if err != nil {
+			return point{}, err
+		}
+		if d.v, err = d.r.readBits(64); err != nil {
+			return point{}, err
+		}
+		d.t, d.delta = int64(t), gridStep
+	} else {
+		dod, err := d.readDoD()
+		if err != nil {
+			return point{}, err
+		}
+		d.delta += dod
+		d.t += d.delta
+		if err := d.readXOR(); err != nil {
+			return point{}, err
+		}
+	}
+	if err := d.readLabel(&d.estat); err != nil {
+		return point{}, err
+	}
+	if err := d.readLabel(&d.base); err != nil {
+		return point{}, err
+	}
+	d.n++
+	return point{t: d.t, v: math.Float64frombits(d.v), estat: d.estat, base: d.base}, nil
+}
+
+// decodeChunk returns the points of a chunk file, checking its magic bytes and checksum
+func decodeChunk(b []byte) ([]point, error) {
+	if len(b) < len(chunkMagic)+5 || string(b[:len(chunkMagic)]) != chunkMagic {
+		return nil, errCorruptChunk
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errCorruptChunk
+	}
+
+	n, k := binary.Uvarint(body[len(chunkMagic):])
+	if k <= 0 {
+		return nil, errCorruptChunk
+	}
+	d := &chunkDecoder{r: bitReader{b: body[len(chunkMagic)+k:]}, lead: -1}
+	points := make([]point, 0, n)
+	for i := uint64(0); i < n; i++ {
+		p, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
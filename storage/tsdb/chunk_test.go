@@ -0,0 +1,83 @@
+package tsdb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestChunkRoundTrip tests that points on and off the grid decode to the encoded ones.
+func TestChunkRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var points []point
+	ts := int64(1678579200)
+	v := 14.6
+	for i := 0; i < 500; i++ {
+		switch {
+		case i%100 == 99:
+			ts += 86400 * 40 // A long gap
+		case i%10 == 9:
+			ts += 60 + int64(rnd.Intn(5000)) // Off the grid
+		default:
+			ts += gridStep
+		}
+		if i%7 != 0 {
+			v += math.Round((rnd.Float64()-0.5)*20) / 10
+		}
+		p := point{t: ts, v: v, estat: "V", base: "SH"}
+		if i%50 == 0 {
+			p.estat = "T"
+		}
+		points = append(points, p)
+	}
+	points = append(points, point{t: ts + gridStep, v: math.Inf(-1)}, point{t: ts + 2*gridStep, v: -0.0})
+
+	got, err := decodeChunk(encodeChunk(points))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(points) {
+		t.Fatalf("expected %d points, got %d", len(points), len(got))
+	}
+	for i := range points {
+		if got[i] != points[i] || math.Signbit(got[i].v) != math.Signbit(points[i].v) {
+			t.Fatalf("point %d: expected %+v, got %+v", i, points[i], got[i])
+		}
+	}
+}
+
+// TestChunkCompression tests that a day of readings on the SH grid compresses below the raw 16 bytes per point.
+func TestChunkCompression(t *testing.T) {
+	var steady, varying []point
+	for i := 0; i < 48; i++ {
+		ts := 1678579200 + int64(i)*gridStep
+		steady = append(steady, point{t: ts, v: 12.5, estat: "V", base: "SH"})
+		varying = append(varying, point{t: ts, v: 10 + float64(i%12)/10, estat: "V", base: "SH"})
+	}
+
+	// Timestamps on the grid and repeated values and labels cost a bit each
+	if b := encodeChunk(steady); len(b) > 64 {
+		t.Errorf("expected a steady series to take at most 64 bytes, got %d", len(b))
+	}
+	if b := encodeChunk(varying); len(b) > 48*8 {
+		t.Errorf("expected at most 8 bytes per point, got %d bytes for 48 points", len(b))
+	}
+}
+
+// TestChunkCorrupt tests that damaged chunks are detected.
+func TestChunkCorrupt(t *testing.T) {
+	b := encodeChunk([]point{{t: 1678579200, v: 1}, {t: 1678581000, v: 2}})
+
+	for _, damaged := range [][]byte{nil, b[:6], append([]byte("XTS0"), b[4:]...)} {
+		if _, err := decodeChunk(damaged); err != errCorruptChunk {
+			t.Errorf("expected %v, got %v", errCorruptChunk, err)
+		}
+	}
+
+	flipped := append([]byte{}, b...)
+	flipped[8] ^= 0x10
+	if _, err := decodeChunk(flipped); err != errCorruptChunk {
+		t.Errorf("expected %v for a flipped bit, got %v", errCorruptChunk, err)
+	}
+}
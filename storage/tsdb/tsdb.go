@@ -0,0 +1,319 @@
+// Package tsdb is an embedded storage engine for XEMA series, for edge deployments which keep months of local
+// history without running a database. It implements the sink.Sink interface, so it can replace any other sink.
+//
+// Each station and variable has its own directory of chunk files, one per calendar month in UTC, e.g
+// <dir>/D5/32/2023-03.chunk. Timestamps are encoded as deltas of deltas against the 30 minute SH grid, so a reading
+// on the grid spends one bit on its timestamp, and values are XORed with the previous one as in Facebook's Gorilla.
+// Written readings are held in memory until Flush, which merges them into the chunks and replaces the files
+// atomically.
+package tsdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+)
+
+// DefaultBatchSize is the number of pending readings which triggers a Flush when no size is configured
+const DefaultBatchSize = 10000
+
+const chunkExt = ".chunk"
+
+var errClosed = errors.New("database closed")
+var errInvalidOption = errors.New("invalid option")
+var errInvalidStation = errors.New("invalid station code")
+
+// validStation matches the station codes accepted as directory names
+var validStation = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// Series identifies the readings of a variable at a station
+type Series struct {
+	Estacio  string // Station code
+	Variable int    // Variable code
+}
+
+// DB is an embedded time-series database stored in a directory. It is safe for concurrent use.
+type DB struct {
+	mu        sync.Mutex
+	dir       string
+	batchSize int
+	head      map[Series]map[int64]point // Pending readings by series and timestamp
+	pending   int
+	closed    bool
+}
+
+// Option configures a DB
+type Option func(db *DB) error
+
+// WithBatchSize sets the number of pending readings which triggers a Flush on Write.
+func WithBatchSize(n int) Option {
+	return func(db *DB) error {
+		if n <= 0 {
+			return errInvalidOption
+		}
+		db.batchSize = n
+		return nil
+	}
+}
+
+// Open returns a new DB pointer which stores its chunks in dir, creating it if needed
+func Open(dir string, options ...Option) (*DB, error) {
+	db := &DB{
+		dir:       dir,
+		batchSize: DefaultBatchSize,
+		head:      make(map[Series]map[int64]point),
+	}
+
+	for _, op := range options {
+		if op == nil {
+			return nil, errInvalidOption
+		}
+		if err := op(db); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// month returns the chunk name of the month of a Unix time, e.g 2023-03
+func month(t int64) string {
+	return time.Unix(t, 0).UTC().Format("2006-01")
+}
+
+// seriesDir returns the directory of the chunks of a series
+func (db *DB) seriesDir(s Series) string {
+	return filepath.Join(db.dir, strings.ToUpper(s.Estacio), strconv.Itoa(s.Variable))
+}
+
+// Write adds a reading, flushing the pending ones once the batch is full. A later reading of the same station,
+// variable and time replaces the earlier one.
+func (db *DB) Write(r export.Row) error {
+	if !validStation.MatchString(r.Estacio) {
+		return errInvalidStation
+	}
+	t, err := meteocat.ParseData(r.Lectura.Data)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errClosed
+	}
+
+	s := Series{Estacio: strings.ToUpper(r.Estacio), Variable: r.Variable}
+	points, ok := db.head[s]
+	if !ok {
+		points = make(map[int64]point)
+		db.head[s] = points
+	}
+	if _, ok := points[t.Unix()]; !ok {
+		db.pending++
+	}
+	points[t.Unix()] = point{t: t.Unix(), v: r.Lectura.Valor, estat: r.Lectura.Estat, base: r.Lectura.BaseHoraria}
+
+	if db.pending >= db.batchSize {
+		return db.flush()
+	}
+	return nil
+}
+
+// readChunk returns the points of a chunk file, or none if it does not exist
+func readChunk(path string) ([]point, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeChunk(b)
+}
+
+// writeChunk replaces a chunk file atomically
+func writeChunk(path string, points []point) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(encodeChunk(points)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// merge returns the points of a chunk with the pending ones, sorted by time. Pending points replace stored ones.
+func merge(stored []point, pending map[int64]point) []point {
+	all := make(map[int64]point, len(stored)+len(pending))
+	for _, p := range stored {
+		all[p.t] = p
+	}
+	for t, p := range pending {
+		all[t] = p
+	}
+	points := make([]point, 0, len(all))
+	for _, p := range all {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].t < points[j].t })
+	return points
+}
+
+// flush merges the pending readings into the chunk files. The caller must hold the lock.
+func (db *DB) flush() error {
+	for s, points := range db.head {
+		byMonth := make(map[string]map[int64]point)
+		for t, p := range points {
+			m, ok := byMonth[month(t)]
+			if !ok {
+				m = make(map[int64]point)
+				byMonth[month(t)] = m
+			}
+			m[t] = p
+		}
+
+		dir := db.seriesDir(s)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for name, pending := range byMonth {
+			path := filepath.Join(dir, name+chunkExt)
+			stored, err := readChunk(path)
+			if err != nil {
+				return err
+			}
+			if err := writeChunk(path, merge(stored, pending)); err != nil {
+				return err
+			}
+		}
+
+		db.pending -= len(points)
+		delete(db.head, s)
+	}
+	return nil
+}
+
+// Flush merges the pending readings into the chunk files
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errClosed
+	}
+	return db.flush()
+}
+
+// Close flushes the pending readings and rejects any further ones
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errClosed
+	}
+	err := db.flush()
+	db.closed = true
+	return err
+}
+
+// Query returns the readings of a variable at a station within [from, to) in time order, including the pending
+// ones. Only the chunks of the months in the range are read.
+func (db *DB) Query(estacio string, variable int, from, to time.Time) ([]meteocat.Lectura, error) {
+	if !validStation.MatchString(estacio) {
+		return nil, errInvalidStation
+	}
+	s := Series{Estacio: strings.ToUpper(estacio), Variable: variable}
+	start, end := from.Unix(), to.Unix()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, errClosed
+	}
+
+	var stored []point
+	first := time.Date(from.UTC().Year(), from.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	for m := first; m.Before(to); m = m.AddDate(0, 1, 0) {
+		points, err := readChunk(filepath.Join(db.seriesDir(s), m.Format("2006-01")+chunkExt))
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, points...)
+	}
+
+	var lectures []meteocat.Lectura
+	for _, p := range merge(stored, db.head[s]) {
+		if p.t < start || p.t >= end {
+			continue
+		}
+		lectures = append(lectures, meteocat.Lectura{
+			Data:        time.Unix(p.t, 0).UTC().Format("2006-01-02T15:04Z"),
+			Valor:       p.v,
+			Estat:       p.estat,
+			BaseHoraria: p.base,
+		})
+	}
+	return lectures, nil
+}
+
+// Series returns the series stored in the database, including the pending ones, sorted by station and variable
+func (db *DB) Series() ([]Series, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	seen := make(map[Series]bool)
+	for s := range db.head {
+		seen[s] = true
+	}
+
+	stations, err := ioutil.ReadDir(db.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range stations {
+		if !st.IsDir() {
+			continue
+		}
+		variables, err := ioutil.ReadDir(filepath.Join(db.dir, st.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range variables {
+			if codi, err := strconv.Atoi(v.Name()); err == nil && v.IsDir() {
+				seen[Series{Estacio: st.Name(), Variable: codi}] = true
+			}
+		}
+	}
+
+	series := make([]Series, 0, len(seen))
+	for s := range seen {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Estacio != series[j].Estacio {
+			return series[i].Estacio < series[j].Estacio
+		}
+		return series[i].Variable < series[j].Variable
+	})
+	return series, nil
+}
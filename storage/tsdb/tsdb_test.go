@@ -0,0 +1,146 @@
+package tsdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oscaromeu/meteocat"
+	"github.com/oscaromeu/meteocat/export"
+	"github.com/oscaromeu/meteocat/internal/testutil"
+	"github.com/oscaromeu/meteocat/sink"
+)
+
+var _ sink.Sink = (*DB)(nil)
+
+// TestDB tests that a day of readings of all the stations is stored in chunk files and queried back.
+func TestDB(t *testing.T) {
+	var m meteocat.Measurements
+	testutil.Load(t, "mesurades_dia_totes_estacions.json", &m)
+
+	dir, remove := testutil.TempDir(t, "tsdb")
+	defer remove()
+
+	db, err := Open(dir, WithBatchSize(5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteMeasurements(db, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "C6", "32", "2023-03.chunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 48*16 {
+		t.Errorf("expected a compressed chunk, got %d bytes", info.Size())
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := db.Series()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != len(m) || series[0] != (Series{Estacio: "C6", Variable: 32}) {
+		t.Errorf("unexpected series: %d, first %+v", len(series), series[0])
+	}
+
+	from := time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC)
+	l, err := db.Query("c6", 32, from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := m[0].Variables[0].Lectures
+	if len(l) != len(want) {
+		t.Fatalf("expected %d readings, got %d", len(want), len(l))
+	}
+	for i := range want {
+		if l[i] != want[i] {
+			t.Fatalf("reading %d: expected %+v, got %+v", i, want[i], l[i])
+		}
+	}
+
+	l, _ = db.Query("C6", 32, from.Add(time.Hour), from.Add(2*time.Hour))
+	if len(l) != 2 || l[0].Data != "2023-03-12T01:00Z" {
+		t.Errorf("unexpected readings of the second hour: %+v", l)
+	}
+}
+
+// TestDBMerge tests that readings across months are merged with the stored ones and pending ones are queried.
+func TestDBMerge(t *testing.T) {
+	dir, remove := testutil.TempDir(t, "tsdb")
+	defer remove()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	row := func(data string, valor float64) export.Row {
+		return export.Row{Estacio: "D5", Variable: 32, Lectura: meteocat.Lectura{Data: data, Valor: valor, Estat: "V", BaseHoraria: "SH"}}
+	}
+	for _, r := range []export.Row{row("2023-02-28T23:30Z", 1), row("2023-03-01T00:00Z", 2), row("2023-03-01T00:30Z", 3)} {
+		if err := db.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A correction of a stored reading and a new one, still pending
+	db.Write(row("2023-03-01T00:00Z", 2.5))
+	db.Write(row("2023-03-01T01:00Z", 4))
+
+	from := time.Date(2023, 2, 28, 0, 0, 0, 0, time.UTC)
+	l, err := db.Query("D5", 32, from, from.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	for _, r := range l {
+		values = append(values, r.Valor)
+	}
+	if len(values) != 4 || values[0] != 1 || values[1] != 2.5 || values[2] != 3 || values[3] != 4 {
+		t.Errorf("unexpected merged values %v", values)
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2023-02.chunk", "2023-03.chunk"} {
+		if _, err := os.Stat(filepath.Join(dir, "D5", "32", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if l, _ := db.Query("D5", 32, from, from.Add(48*time.Hour)); len(l) != 4 || l[1].Valor != 2.5 {
+		t.Errorf("unexpected flushed readings %+v", l)
+	}
+}
+
+// TestDBInvalid tests that unsafe station codes and writes after Close are rejected.
+func TestDBInvalid(t *testing.T) {
+	dir, remove := testutil.TempDir(t, "tsdb")
+	defer remove()
+
+	db, _ := Open(dir)
+	r := export.Row{Estacio: "../D5", Variable: 32, Lectura: meteocat.Lectura{Data: "2023-03-01T00:00Z"}}
+	if err := db.Write(r); err != errInvalidStation {
+		t.Errorf("expected %v, got %v", errInvalidStation, err)
+	}
+
+	db.Close()
+	r.Estacio = "D5"
+	if err := db.Write(r); err != errClosed {
+		t.Errorf("expected %v, got %v", errClosed, err)
+	}
+}
@@ -0,0 +1,108 @@
+package meteocat
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CallStats holds statistics of the last API call done by a client
+type CallStats struct {
+	BytesTransferred int64 // Bytes of the response body read from the network, compressed if gzip was used
+	FromCache        bool  // True when the response was served from the cache
+	Revalidated      bool  // True when the cached response was revalidated with a conditional request
+}
+
+// statsKey is the context key used to pass the CallStats of a request to the transports
+type statsKey struct{}
+
+// statsFromRequest returns the CallStats attached to the request, if any
+func statsFromRequest(req *http.Request) *CallStats {
+	st, _ := req.Context().Value(statsKey{}).(*CallStats)
+	return st
+}
+
+// countingReader counts the bytes read from the underlying reader into the CallStats
+type countingReader struct {
+	io.ReadCloser
+	stats *CallStats
+}
+
+// Read implements the io.Reader interface
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if r.stats != nil {
+		r.stats.BytesTransferred += int64(n)
+	}
+	return n, err
+}
+
+// gzipReadCloser decodes a gzip body and closes both the decoder and the underlying body
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+// Close implements the io.Closer interface
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// gzipTransport is an http.RoundTripper which asks for gzip compressed responses, decodes them and counts
+// the bytes transferred
+type gzipTransport struct {
+	transport http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &countingReader{ReadCloser: resp.Body, stats: statsFromRequest(req)}
+
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") && resp.StatusCode != http.StatusNotModified {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = &gzipReadCloser{Reader: zr, body: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+
+	return resp, nil
+}
+
+// newTransport wraps transport with the gzip support of the client
+func newTransport(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &gzipTransport{transport: transport}
+}
+
+// do sends the request with the client of the Settings and records the CallStats of the call.
+func (s *Settings) do(req *http.Request) (*http.Response, error) {
+	s.stats = CallStats{}
+	req = req.WithContext(context.WithValue(req.Context(), statsKey{}, &s.stats))
+	return s.client.Do(req)
+}
+
+// Stats returns the statistics of the last API call.
+func (s *Settings) Stats() CallStats {
+	return s.stats
+}
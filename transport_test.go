@@ -0,0 +1,115 @@
+package meteocat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestGzipTransport tests that responses are requested gzip compressed and decoded transparently.
+func TestGzipTransport(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/metadades_totes_estacions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(raw)
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("expected Accept-Encoding gzip, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer srv.Close()
+
+	oldURL := baseURL
+	baseURL = srv.URL + "%s"
+	defer func() { baseURL = oldURL }()
+
+	e, _ := NewEstacions("")
+	p, _ := NewParameters()
+	if err := e.StationsAll(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.MetadadesTotesEstacions) < 2 {
+		t.Errorf("expected the full station list, got %d stations", len(e.MetadadesTotesEstacions))
+	}
+
+	st := e.Stats()
+	if st.BytesTransferred != int64(compressed.Len()) {
+		t.Errorf("expected %d bytes transferred, got %d", compressed.Len(), st.BytesTransferred)
+	}
+	if st.FromCache || st.Revalidated {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+// TestConditionalRequests tests that stale metadata is revalidated with the stored ETag.
+func TestConditionalRequests(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/metadades_estacio_D5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, notModified := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"d5-v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"d5-v1"`)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	oldURL := baseURL
+	baseURL = srv.URL + "%s"
+	defer func() { baseURL = oldURL }()
+
+	e, err := NewEstacions("", WithCache(NewLRUCache(10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 3, 12, 10, 0, 0, 0, time.UTC)
+	e.client.Transport.(*cacheTransport).now = func() time.Time { return now }
+
+	call := func() CallStats {
+		t.Helper()
+		e.MetadadesEstacions = MetadadesEstacions{}
+		p, _ := NewParameters(OptionCodiEstacio("D5"))
+		if err := e.StationByCode(p); err != nil {
+			t.Fatal(err)
+		}
+		if e.Nom != "Barcelona - Observatori Fabra" {
+			t.Fatalf("unexpected station: %+v", e.MetadadesEstacions)
+		}
+		return e.Stats()
+	}
+
+	if st := call(); st.FromCache || st.BytesTransferred != int64(len(body)) {
+		t.Errorf("first call: unexpected stats %+v", st)
+	}
+	if st := call(); !st.FromCache || st.Revalidated || st.BytesTransferred != 0 {
+		t.Errorf("second call: unexpected stats %+v", st)
+	}
+
+	now = now.Add(TTLMetadata + time.Minute)
+	if st := call(); !st.FromCache || !st.Revalidated {
+		t.Errorf("third call: unexpected stats %+v", st)
+	}
+
+	if hits != 2 || notModified != 1 {
+		t.Errorf("expected 2 requests and 1 conditional hit, got %d and %d", hits, notModified)
+	}
+}
@@ -0,0 +1,139 @@
+package meteocat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// xddeURL is the base URL of the Lightning Detection Network (XDDE) API
+var xddeURL = "https://api.meteo.cat/xdde/v1%s"
+
+// Ellipse struct holds the confidence ellipse of the location of a discharge
+type Ellipse struct {
+	EixMajor float64 `json:"eixMajor"` // Major axis of the ellipse in meters
+	EixMenor float64 `json:"eixMenor"` // Minor axis of the ellipse in meters
+	Angle    float64 `json:"angle"`    // Angle of the major axis in degrees
+}
+
+// Descarrega is an aggregate type which represents a lightning discharge registered by the Lightning Detection
+// Network (XDDE), e.g {"id":31519,"data":"2017-03-27T10:05:38.941Z","correntPic":-19.6,"nuvolTerra":true,...}
+type Descarrega struct {
+	ID          int         `json:"id"`          // Identification code of the discharge
+	Data        string      `json:"data"`        // Date and time of the discharge in UTC
+	CorrentPic  float64     `json:"correntPic"`  // Peak current in kA, the sign represents the polarity
+	Chi2        float64     `json:"chi2"`        // Chi-squared value of the location fit
+	Ellipse     Ellipse     `json:"ellipse"`     // Location confidence ellipse
+	NumSensors  int         `json:"numSensors"`  // Number of sensors which detected the discharge
+	NuvolTerra  bool        `json:"nuvolTerra"`  // True for cloud-to-ground discharges, false for intra-cloud ones
+	Altura      float64     `json:"altura"`      // Altitude in meters of intra-cloud discharges
+	IDMunicipi  string      `json:"idMunicipi"`  // INE code of the municipi where the discharge was located
+	Coordenades Coordenades `json:"coordenades"` // Georeference data of the discharge
+}
+
+// Descarregues is a slice which holds the discharges registered in a period of time
+type Descarregues []Descarrega
+
+// CountWithin returns the number of discharges located at most radius kilometers away from c. Use the Coordenades
+// of a station to count the strikes around it.
+func (d Descarregues) CountWithin(c Coordenades, radius float64) int {
+	n := 0
+	for _, ds := range d {
+		if ds.Coordenades.Distance(c) <= radius {
+			n++
+		}
+	}
+	return n
+}
+
+// CloudToGround returns the cloud-to-ground discharges of d.
+func (d Descarregues) CloudToGround() Descarregues {
+	var r Descarregues
+	for _, ds := range d {
+		if ds.NuvolTerra {
+			r = append(r, ds)
+		}
+	}
+	return r
+}
+
+// IntraCloud returns the intra-cloud discharges of d.
+func (d Descarregues) IntraCloud() Descarregues {
+	var r Descarregues
+	for _, ds := range d {
+		if !ds.NuvolTerra {
+			r = append(r, ds)
+		}
+	}
+	return r
+}
+
+// Recompte holds the number of discharges of each type
+type Recompte struct {
+	NuvolTerra int `json:"nuvolTerra"` // Number of cloud-to-ground discharges
+	NuvolNuvol int `json:"nuvolNuvol"` // Number of intra-cloud discharges
+}
+
+// RecomptesDescarregues is a slice which holds the discharge counts of each comarca or municipi
+type RecomptesDescarregues []struct {
+	Codi         string   `json:"codi"`         // Identification code of the comarca or INE code of the municipi
+	Descarregues Recompte `json:"descarregues"` // Discharge counts
+}
+
+// XDDE holds all the data representations to unmarshall the Lightning Detection Network API responses
+type XDDE struct {
+	Descarregues
+	RecomptesDescarregues
+	Key string
+	*Settings
+}
+
+// NewXDDE returns a new XDDE pointer with the supplied parameters
+func NewXDDE(key string, options ...Option) (*XDDE, error) {
+	x := &XDDE{
+		Settings: NewSettings(),
+	}
+
+	x.Key, _ = setKey(key)
+
+	if err := setOptions(x.Settings, options); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// Returns the discharges registered over Catalonia on a given day or, if the hour is set, on a given hour.
+// The API resource is /catalunya/{any}/{mes}/{dia}/{hora} where the parameters `any`, `mes` and `dia` are mandatory
+// and `hora` is optional. Request example: https://api.meteo.cat/xdde/v1/catalunya/2017/03/27/10
+func (x *XDDE) DischargesByDay(p *Parameters) error {
+
+	if !ValidData(p.Data) {
+		return errInvalidData
+	}
+
+	path := fmt.Sprintf("/catalunya/%s/%s/%s", p.Any, p.Mes, p.Dia)
+	if p.Hour != "" {
+		path = fmt.Sprintf("%s/%s", path, p.Hour)
+	}
+
+	x.Descarregues = nil
+	return x.get(x.Key, fmt.Sprintf(xddeURL, path), &x.Descarregues)
+}
+
+// Returns the number of discharges of each comarca or municipi on a given day. The API resource is
+// /recomptes/{ambit}/{any}/{mes}/{dia} where `ambit` is one of "comarques" or "municipis" and the parameters `any`,
+// `mes` and `dia` are mandatory. Request example: https://api.meteo.cat/xdde/v1/recomptes/comarques/2017/03/27
+func (x *XDDE) DischargeCounts(ambit string, p *Parameters) error {
+
+	ambit = strings.ToLower(ambit)
+	if ambit != "comarques" && ambit != "municipis" {
+		return errInvalidOption
+	}
+
+	if !ValidData(p.Data) {
+		return errInvalidData
+	}
+
+	x.RecomptesDescarregues = nil
+	return x.get(x.Key, fmt.Sprintf(xddeURL, fmt.Sprintf("/recomptes/%s/%s/%s/%s", ambit, p.Any, p.Mes, p.Dia)), &x.RecomptesDescarregues)
+}
@@ -0,0 +1,105 @@
+package meteocat
+
+import (
+	"testing"
+)
+
+// TestDischargesByDay tests that DischargesByDay decodes the discharges of a given hour.
+func TestDischargesByDay(t *testing.T) {
+	defer newFixtureServerFor(t, &xddeURL, "/catalunya/2017/03/27/10", "descarregues_catalunya_hora.json")()
+
+	x, _ := NewXDDE("")
+	p, _ := NewParameters(
+		OptionData(Data{Any: "2017", Mes: "03", Dia: "27"}),
+		OptionTimeDate(TimeDate{Hour: "10"}),
+	)
+
+	if err := x.DischargesByDay(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(x.Descarregues) != 3 {
+		t.Fatalf("expected 3 discharges, got %d", len(x.Descarregues))
+	}
+	if d := x.Descarregues[1]; d.NuvolTerra || d.CorrentPic != 8.3 || d.Altura != 7200 {
+		t.Errorf("unexpected discharge: %+v", d)
+	}
+	if n := len(x.CloudToGround()); n != 2 {
+		t.Errorf("expected 2 cloud-to-ground discharges, got %d", n)
+	}
+	if n := len(x.IntraCloud()); n != 1 {
+		t.Errorf("expected 1 intra-cloud discharge, got %d", n)
+	}
+}
+
+// TestDischargesByDayInvalidData tests that DischargesByDay requires a complete date.
+func TestDischargesByDayInvalidData(t *testing.T) {
+	x, _ := NewXDDE("")
+	p, _ := NewParameters(OptionData(Data{Any: "2017", Mes: "03"}))
+
+	if err := x.DischargesByDay(p); err != errInvalidData {
+		t.Errorf("expected %v, got %v", errInvalidData, err)
+	}
+}
+
+// TestCountWithin tests that CountWithin counts the discharges around a station.
+func TestCountWithin(t *testing.T) {
+	d := Descarregues{
+		{Coordenades: Coordenades{Latitud: 41.4301, Longitud: 2.1402}},
+		{Coordenades: Coordenades{Latitud: 41.3601, Longitud: 2.1001}},
+		{Coordenades: Coordenades{Latitud: 41.9794, Longitud: 2.8214}},
+	}
+	fabra := Coordenades{Latitud: 41.41864, Longitud: 2.12379}
+
+	for radius, want := range map[float64]int{1: 0, 5: 1, 10: 2, 100: 3} {
+		if got := d.CountWithin(fabra, radius); got != want {
+			t.Errorf("CountWithin(%v km): expected %d, got %d", radius, want, got)
+		}
+	}
+}
+
+// TestDischargeCounts tests that DischargeCounts decodes the counts of the comarques and of the municipis.
+func TestDischargeCounts(t *testing.T) {
+	defer newFixturesServerFor(t, &xddeURL, map[string]string{
+		"/recomptes/comarques/2017/03/27": "recomptes_comarques.json",
+		"/recomptes/municipis/2017/03/27": "recomptes_municipis.json",
+	})()
+
+	x, _ := NewXDDE("")
+	p, _ := NewParameters(OptionData(Data{Any: "2017", Mes: "03", Dia: "27"}))
+
+	if err := x.DischargeCounts("Comarques", p); err != nil {
+		t.Fatal(err)
+	}
+	if len(x.RecomptesDescarregues) != 2 {
+		t.Fatalf("expected 2 comarques, got %d", len(x.RecomptesDescarregues))
+	}
+	if r := x.RecomptesDescarregues[0]; r.Codi != "13" || r.Descarregues.NuvolTerra != 12 || r.Descarregues.NuvolNuvol != 31 {
+		t.Errorf("unexpected count: %+v", r)
+	}
+
+	if err := x.DischargeCounts("municipis", p); err != nil {
+		t.Fatal(err)
+	}
+	if len(x.RecomptesDescarregues) != 3 {
+		t.Fatalf("expected 3 municipis, got %d", len(x.RecomptesDescarregues))
+	}
+	if r := x.RecomptesDescarregues[2]; r.Codi != "170792" || r.Descarregues.NuvolTerra != 0 || r.Descarregues.NuvolNuvol != 4 {
+		t.Errorf("unexpected count: %+v", r)
+	}
+}
+
+// TestDischargeCountsInvalid tests that DischargeCounts rejects unknown ambits and incomplete dates.
+func TestDischargeCountsInvalid(t *testing.T) {
+	x, _ := NewXDDE("")
+	p, _ := NewParameters(OptionData(Data{Any: "2017", Mes: "03", Dia: "27"}))
+
+	if err := x.DischargeCounts("provincies", p); err != errInvalidOption {
+		t.Errorf("expected %v, got %v", errInvalidOption, err)
+	}
+
+	p, _ = NewParameters(OptionData(Data{Any: "2017"}))
+	if err := x.DischargeCounts("comarques", p); err != errInvalidData {
+		t.Errorf("expected %v, got %v", errInvalidData, err)
+	}
+}